@@ -0,0 +1,484 @@
+package vfsgo
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// ArchiveTar, ArchiveTarGz, ArchiveZip: formats of ExportArchive and
+	// ImportArchive
+	ArchiveTar   = "tar"
+	ArchiveTarGz = "tar.gz"
+	ArchiveZip   = "zip"
+
+	// paxMeta: PAX record of header fields a tar header can not keep
+	paxMeta = "VFSGO.meta"
+	// paxComment: PAX record of description read by other tools
+	paxComment = "comment"
+	// zipMetaTag: id of zip extra field of header fields a zip header can
+	// not keep
+	zipMetaTag = 0x5646
+)
+
+// archiveMeta: header fields kept in archive entry beside name, mode and
+// content, times are kept to nanosecond
+type archiveMeta struct {
+	ManifestEntry
+	ModifiedTime time.Time `json:"modified_time"`
+}
+
+func newArchiveMeta(header FileHeader) archiveMeta {
+	return archiveMeta{ManifestEntry: manifestEntry(header), ModifiedTime: header.ModifiedTime}
+}
+
+// archiveWriter: entries are added in order, content is nil for folder
+type archiveWriter interface {
+	add(rel string, header FileHeader, content io.Reader) error
+	Close() error
+}
+
+type tarWriter struct {
+	tw *tar.Writer
+	gz *gzip.Writer
+}
+
+func (w *tarWriter) add(rel string, header FileHeader, content io.Reader) error {
+	meta, err := json.Marshal(newArchiveMeta(header))
+	if err != nil {
+		return xerrors.Errorf("error in json.Marshal: %w", err)
+	}
+
+	hdr := &tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       rel,
+		Mode:       0644,
+		Size:       header.Size,
+		ModTime:    header.ModifiedTime,
+		Format:     tar.FormatPAX,
+		PAXRecords: map[string]string{paxMeta: string(meta)},
+	}
+	if header.Description != "" {
+		hdr.PAXRecords[paxComment] = header.Description
+	}
+
+	if content == nil {
+		hdr.Typeflag, hdr.Name, hdr.Mode, hdr.Size = tar.TypeDir, rel+"/", 0755, 0
+	}
+
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return xerrors.Errorf("error in WriteHeader: %w", err)
+	}
+
+	if content == nil {
+		return nil
+	}
+
+	if _, err := io.Copy(w.tw, content); err != nil {
+		return xerrors.Errorf("error in io.Copy: %w", err)
+	}
+
+	return nil
+}
+
+func (w *tarWriter) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+
+	return nil
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (w *zipWriter) add(rel string, header FileHeader, content io.Reader) error {
+	meta, err := json.Marshal(newArchiveMeta(header))
+	if err != nil {
+		return xerrors.Errorf("error in json.Marshal: %w", err)
+	}
+
+	if len(meta) > 0xffff {
+		return errorf(ErrInvalidName, "header of %s too large for zip", rel)
+	}
+
+	extra := make([]byte, 4, 4+len(meta))
+	binary.LittleEndian.PutUint16(extra, zipMetaTag)
+	binary.LittleEndian.PutUint16(extra[2:], uint16(len(meta)))
+
+	fh := &zip.FileHeader{
+		Name:     rel,
+		Comment:  header.Description,
+		Modified: header.ModifiedTime,
+		Method:   zip.Deflate,
+		Extra:    append(extra, meta...),
+	}
+	fh.SetMode(0644)
+
+	if content == nil {
+		fh.Name, fh.Method = rel+"/", zip.Store
+		fh.SetMode(os.ModeDir | 0755)
+	}
+
+	zf, err := w.zw.CreateHeader(fh)
+	if err != nil {
+		return xerrors.Errorf("error in CreateHeader: %w", err)
+	}
+
+	if content == nil {
+		return nil
+	}
+
+	if _, err := io.Copy(zf, content); err != nil {
+		return xerrors.Errorf("error in io.Copy: %w", err)
+	}
+
+	return nil
+}
+
+func (w *zipWriter) Close() error {
+	return w.zw.Close()
+}
+
+// zipMeta: header fields kept in extra of zip entry, ok false if it has none
+func zipMeta(extra []byte) (archiveMeta, bool) {
+	var meta archiveMeta
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+
+		if tag == zipMetaTag {
+			return meta, json.Unmarshal(extra[4:4+size], &meta) == nil
+		}
+		extra = extra[4+size:]
+	}
+
+	return meta, false
+}
+
+// ExportArchive: write folder vfsPath with everything under it to w in
+// format, paths in archive are relative to vfsPath. The archive is written
+// while walking, nothing is kept in memory or on disk
+func (cs *commandService) ExportArchive(vfsPath, format string, w io.Writer) error {
+	if cs.currentBlock == nil {
+		return NewError(ErrNoUser, "current block is nil")
+	}
+
+	var aw archiveWriter
+	switch format {
+	case ArchiveTar:
+		aw = &tarWriter{tw: tar.NewWriter(w)}
+	case ArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		aw = &tarWriter{tw: tar.NewWriter(gz), gz: gz}
+	case ArchiveZip:
+		aw = &zipWriter{zw: zip.NewWriter(w)}
+	default:
+		return errorf(ErrInvalidName, "unknown format %s", format)
+	}
+
+	block, err := cs.travelFolder(vfsPath)
+	if err != nil {
+		return xerrors.Errorf("err in travelFolder: %w", err)
+	}
+
+	if err := cs.archiveDir(aw, block, ""); err != nil {
+		return err
+	}
+
+	if err := aw.Close(); err != nil {
+		return xerrors.Errorf("err in Close: %w", err)
+	}
+
+	return nil
+}
+
+func (cs *commandService) archiveDir(aw archiveWriter, block *BlockINode, rel string) error {
+	for _, name := range sortedNames(block) {
+		header := block.FileMap[name]
+		r := path.Join(rel, name)
+
+		if header.Type == Directory {
+			child, ok := childBlock(cs.currentUser, header)
+			if !ok {
+				return errorf(ErrNotExist, "block of %s not exist", r)
+			}
+
+			if err := aw.add(r, header, nil); err != nil {
+				return err
+			}
+
+			if err := cs.archiveDir(aw, &child, r); err != nil {
+				return err
+			}
+			continue
+		}
+
+		content, err := OpenFileContent(block, name)
+		if err != nil {
+			return xerrors.Errorf("err in OpenFileContent: %w", err)
+		}
+
+		err = aw.add(r, header, content)
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// archiveEntry: entry read from archive, content is nil for folder
+type archiveEntry struct {
+	name    string
+	size    int64
+	meta    archiveMeta
+	content io.Reader
+	// other: neither folder nor regular file
+	other bool
+}
+
+// archiveReader: next entry of archive, io.EOF after the last one
+type archiveReader func() (archiveEntry, error)
+
+func tarEntries(r io.Reader) archiveReader {
+	tr := tar.NewReader(r)
+
+	return func() (archiveEntry, error) {
+		hdr, err := tr.Next()
+		if err != nil {
+			return archiveEntry{}, err
+		}
+
+		e := archiveEntry{name: hdr.Name, size: hdr.Size, content: tr}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			e.content = nil
+		case tar.TypeReg, tar.TypeRegA:
+		default:
+			e.other = true
+		}
+
+		if err := json.Unmarshal([]byte(hdr.PAXRecords[paxMeta]), &e.meta); err != nil {
+			e.meta = archiveMeta{ModifiedTime: hdr.ModTime}
+			e.meta.CreatedTime = hdr.ModTime
+			e.meta.Description = hdr.PAXRecords[paxComment]
+		}
+
+		return e, nil
+	}
+}
+
+func zipEntries(zr *zip.Reader) archiveReader {
+	i := 0
+	var opened io.ReadCloser
+
+	return func() (archiveEntry, error) {
+		if opened != nil {
+			opened.Close()
+			opened = nil
+		}
+
+		if i >= len(zr.File) {
+			return archiveEntry{}, io.EOF
+		}
+		f := zr.File[i]
+		i++
+
+		e := archiveEntry{name: f.Name, size: int64(f.UncompressedSize64)}
+		meta, ok := zipMeta(f.Extra)
+		if !ok {
+			meta = archiveMeta{ModifiedTime: f.Modified}
+			meta.CreatedTime = f.Modified
+			meta.Description = f.Comment
+		}
+		e.meta = meta
+
+		switch mode := f.Mode(); {
+		case mode.IsDir():
+			return e, nil
+		case !mode.IsRegular():
+			e.other = true
+			return e, nil
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return archiveEntry{}, xerrors.Errorf("error in Open: %w", err)
+		}
+		opened, e.content = rc, rc
+
+		return e, nil
+	}
+}
+
+// ImportArchive: read archive in format from r into folder vfsPath, see
+// Import for opts and report. Folders missing in archive are created for
+// entries under them. A zip is spooled to a temp file as its index is at
+// the end
+func (cs *commandService) ImportArchive(r io.Reader, vfsPath, format string, opts ImportOptions) (ImportReport, error) {
+	unlock, err := cs.lock()
+	if err != nil {
+		return ImportReport{}, err
+	}
+	defer unlock()
+
+	report := ImportReport{Rejected: []string{}}
+
+	if cs.currentBlock == nil {
+		return report, NewError(ErrNoUser, "current block is nil")
+	}
+
+	for _, p := range opts.Exclude {
+		if _, err := path.Match(p, ""); err != nil {
+			return report, errorf(ErrInvalidName, "invalid pattern %s", p)
+		}
+	}
+
+	block, err := cs.travelFolder(vfsPath)
+	if err != nil {
+		return report, xerrors.Errorf("err in travelFolder: %w", err)
+	}
+
+	var next archiveReader
+	switch format {
+	case ArchiveTar:
+		next = tarEntries(r)
+	case ArchiveTarGz:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return report, errorf(ErrCorrupt, "gzip of archive: %v", err)
+		}
+		defer gz.Close()
+		next = tarEntries(gz)
+	case ArchiveZip:
+		spool, err := os.CreateTemp("", "vfsgo-zip-*")
+		if err != nil {
+			return report, xerrors.Errorf("err in os.CreateTemp: %w", err)
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+
+		size, err := io.Copy(spool, r)
+		if err != nil {
+			return report, xerrors.Errorf("err in io.Copy: %w", err)
+		}
+
+		zr, err := zip.NewReader(spool, size)
+		if err != nil {
+			return report, errorf(ErrCorrupt, "zip archive: %v", err)
+		}
+		next = zipEntries(zr)
+	default:
+		return report, errorf(ErrInvalidName, "unknown format %s", format)
+	}
+
+	cwd := cs.currentBlock.NodeID
+	defer func() {
+		b := cs.currentUser.BlockMap[cwd]
+		cs.currentBlock = &b
+	}()
+
+	// index is saved once after every entry is imported
+	cs.batch++
+	im := importer{cs: cs, opts: opts, report: &report, manifest: make(map[string]ManifestEntry)}
+	err = im.archive(block.NodeID, next)
+	if endErr := cs.endBatch(); err == nil && endErr != nil {
+		err = xerrors.Errorf("err in endBatch: %w", endErr)
+	}
+
+	return report, err
+}
+
+// archive: import every entry of next under block root
+func (im *importer) archive(root uint64, next archiveReader) error {
+	for {
+		e, err := next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errorf(ErrCorrupt, "archive: %v", err)
+		}
+
+		if err := im.archiveEntry(root, e); err != nil {
+			return err
+		}
+
+		if im.opts.Progress != nil {
+			im.opts.Progress(*im.report)
+		}
+	}
+}
+
+// archiveEntry: entry of archive under block root, its path is cleaned so
+// it can not climb out of root
+func (im *importer) archiveEntry(root uint64, e archiveEntry) error {
+	rel := strings.TrimPrefix(path.Clean("/"+e.name), "/")
+	if rel == "" {
+		return nil
+	}
+	im.report.Path = rel
+
+	segments := strings.Split(rel, "/")
+	for i := range segments {
+		if excluded(im.opts.Exclude, strings.Join(segments[:i+1], "/")) {
+			im.report.Excluded++
+			return nil
+		}
+	}
+
+	for _, seg := range segments {
+		if validateName(seg) != nil {
+			im.report.Rejected = append(im.report.Rejected, rel)
+			return nil
+		}
+	}
+
+	if e.other {
+		im.report.Rejected = append(im.report.Rejected, rel)
+		return nil
+	}
+
+	// folders above entry, they may come later in archive or not at all
+	id := root
+	for i, seg := range segments[:len(segments)-1] {
+		child, ok, err := im.folder(id, seg, strings.Join(segments[:i+1], "/"))
+		if err != nil || !ok {
+			return err
+		}
+		id = child
+	}
+
+	name := segments[len(segments)-1]
+	im.manifest[rel] = e.meta.ManifestEntry
+
+	if e.content == nil {
+		if _, ok, err := im.folder(id, name, rel); err != nil || !ok {
+			return err
+		}
+
+		return im.setHeader(id, name, rel, e.meta.ModifiedTime)
+	}
+
+	return im.file(id, name, rel, e.size, e.meta.ModifiedTime, e.content)
+}
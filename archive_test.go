@@ -0,0 +1,146 @@
+package vfsgo
+
+import (
+	"archive/tar"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	cmdService := newFindTree(t)
+
+	must(t, cmdService.Tag("/a/note.txt", "red", "blue"))
+	must(t, cmdService.SetAttr("/a/b", "owner", "me"))
+
+	for _, format := range []string{ArchiveTar, ArchiveTarGz, ArchiveZip} {
+		var buf bytes.Buffer
+		if err := cmdService.ExportArchive("/a", format, &buf); err != nil {
+			t.Errorf("%s: %s", format, err.Error())
+			return
+		}
+
+		dir := "/" + strings.Replace(format, ".", "_", 1)
+		if err := cmdService.CreateFolder(dir[1:]); err != nil {
+			t.Error(err.Error())
+			return
+		}
+
+		report, err := cmdService.ImportArchive(&buf, dir, format, ImportOptions{})
+		if err != nil {
+			t.Errorf("%s: %s", format, err.Error())
+			return
+		}
+
+		if report.Dirs != 1 || report.Files != 2 || report.Bytes != 5 {
+			t.Errorf("%s: unexpected report %+v", format, report)
+			return
+		}
+
+		for _, p := range []string{"note.txt", "b", "b/deep.txt"} {
+			want, err := cmdService.Stat("/a/" + p)
+			if err != nil {
+				t.Error(err.Error())
+				return
+			}
+
+			got, err := cmdService.Stat(dir + "/" + p)
+			if err != nil {
+				t.Errorf("%s: %s", format, err.Error())
+				return
+			}
+
+			if got.Description != want.Description || !got.CreatedTime.Equal(want.CreatedTime) ||
+				!got.ModifiedTime.Equal(want.ModifiedTime) || !reflect.DeepEqual(got.Tags, want.Tags) ||
+				!reflect.DeepEqual(got.Attrs, want.Attrs) || got.Size != want.Size {
+				t.Errorf("%s: header of %s changed by round trip %+v", format, p, got)
+				return
+			}
+		}
+
+		if err := cmdService.ChangeFolder(dir); err != nil {
+			t.Error(err.Error())
+			return
+		}
+
+		if got := readAll(t, cmdService, "note.txt"); got != "hello" {
+			t.Errorf("%s: unexpected content %q", format, got)
+			return
+		}
+
+		if err := cmdService.ChangeFolder("/"); err != nil {
+			t.Error(err.Error())
+			return
+		}
+	}
+
+	if err := cmdService.ExportArchive("/", "rar", &bytes.Buffer{}); err == nil {
+		t.Error("unknown format should fail")
+		return
+	}
+}
+
+func TestImportForeignTar(t *testing.T) {
+	mtime := time.Date(2021, 5, 6, 7, 8, 9, 0, time.UTC)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	entries := []*tar.Header{
+		{Typeflag: tar.TypeReg, Name: "../../escape.txt", Size: 3, ModTime: mtime},
+		{Typeflag: tar.TypeReg, Name: "x/y/z.txt", Size: 3, ModTime: mtime, PAXRecords: map[string]string{"comment": "zed"}},
+		{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "x"},
+		{Typeflag: tar.TypeReg, Name: "bad name.txt", Size: 3, ModTime: mtime},
+		{Typeflag: tar.TypeReg, Name: "skip.log", Size: 3, ModTime: mtime},
+	}
+	for _, hdr := range entries {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Error(err.Error())
+			return
+		}
+
+		if hdr.Size > 0 {
+			tw.Write([]byte("abc"))
+		}
+	}
+	tw.Close()
+
+	cmdService := NewCommandService(t.TempDir())
+	must(t, cmdService.Register("testTar"))
+	must(t, cmdService.Use("testTar"))
+	must(t, cmdService.CreateFolder("in"))
+
+	report, err := cmdService.ImportArchive(&buf, "/in", ArchiveTar, ImportOptions{Exclude: []string{"*.log"}})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if report.Files != 2 || report.Dirs != 2 || report.Excluded != 1 || strings.Join(report.Rejected, ",") != "link,bad name.txt" {
+		t.Errorf("unexpected report %+v", report)
+		return
+	}
+
+	header, err := cmdService.Stat("/in/x/y/z.txt")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if header.Description != "zed" || !header.ModifiedTime.Equal(mtime) || !header.CreatedTime.Equal(mtime) {
+		t.Errorf("unexpected header %+v", header)
+		return
+	}
+
+	// path climbing out of folder is kept in it
+	if _, err := cmdService.Stat("/in/escape.txt"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if _, err := cmdService.ImportArchive(strings.NewReader("not a zip"), "/in", ArchiveZip, ImportOptions{}); err == nil {
+		t.Error("broken archive should fail")
+		return
+	}
+}
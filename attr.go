@@ -0,0 +1,147 @@
+package vfsgo
+
+import (
+	"sort"
+
+	"golang.org/x/xerrors"
+)
+
+// HasTag: tag is in tags of entry
+func (f *FileHeader) HasTag(tag string) bool {
+	i := sort.SearchStrings(f.Tags, tag)
+	return i < len(f.Tags) && f.Tags[i] == tag
+}
+
+// updateHeader: change header of entry at path with fn and save it
+func (cs *commandService) updateHeader(filePath string, fn func(header *FileHeader) error) error {
+	block, name, err := cs.travelEntry(filePath)
+	if err != nil {
+		return xerrors.Errorf("err in travelEntry: %w", err)
+	}
+
+	header := block.FileMap[name]
+	if err := fn(&header); err != nil {
+		return err
+	}
+
+	if err := header.Save(block.GetBlockPath()); err != nil {
+		return xerrors.Errorf("err in header.Save: %w", err)
+	}
+
+	block.FileMap[name] = header
+	if err := cs.saveBlocks(block); err != nil {
+		return xerrors.Errorf("err in saveBlocks: %w", err)
+	}
+
+	return nil
+}
+
+// Tag: add tags to entry, tags are kept sorted without duplicate
+func (cs *commandService) Tag(filePath string, tags ...string) error {
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for _, tag := range tags {
+		if err := cs.validateCreateFolder(tag); err != nil || tag == "" {
+			return errorf(ErrInvalidName, "invalid tag %s", tag)
+		}
+	}
+
+	return cs.updateHeader(filePath, func(header *FileHeader) error {
+		set := make(map[string]bool, len(header.Tags)+len(tags))
+		for _, tag := range append(header.Tags, tags...) {
+			set[tag] = true
+		}
+
+		header.Tags = sortedTags(set)
+		return nil
+	})
+}
+
+// Untag: remove tags from entry, missing tags are ignored
+func (cs *commandService) Untag(filePath string, tags ...string) error {
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return cs.updateHeader(filePath, func(header *FileHeader) error {
+		set := make(map[string]bool, len(header.Tags))
+		for _, tag := range header.Tags {
+			set[tag] = true
+		}
+
+		for _, tag := range tags {
+			delete(set, tag)
+		}
+
+		header.Tags = sortedTags(set)
+		return nil
+	})
+}
+
+func sortedTags(set map[string]bool) []string {
+	if len(set) == 0 {
+		return nil
+	}
+
+	tags := make([]string, 0, len(set))
+	for tag := range set {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	return tags
+}
+
+// SetAttr: set attribute key of entry, empty value remove the key
+func (cs *commandService) SetAttr(filePath, key, value string) error {
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := cs.validateCreateFolder(key); err != nil || key == "" {
+		return errorf(ErrInvalidName, "invalid attribute %s", key)
+	}
+
+	return cs.updateHeader(filePath, func(header *FileHeader) error {
+		// copy so other copies of header keep the old attributes
+		attrs := make(map[string]string, len(header.Attrs)+1)
+		for k, v := range header.Attrs {
+			attrs[k] = v
+		}
+
+		if value == "" {
+			delete(attrs, key)
+		} else {
+			attrs[key] = value
+		}
+
+		header.Attrs = attrs
+		if len(attrs) == 0 {
+			header.Attrs = nil
+		}
+		return nil
+	})
+}
+
+// GetAttr: value of attribute key of entry
+func (cs *commandService) GetAttr(filePath, key string) (string, error) {
+	header, err := cs.Stat(filePath)
+	if err != nil {
+		return "", xerrors.Errorf("err in Stat: %w", err)
+	}
+
+	value, ok := header.Attrs[key]
+	if !ok {
+		return "", errorf(ErrNotExist, "attribute %s not exist", key)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,116 @@
+package vfsgo
+
+import (
+	"testing"
+)
+
+func TestTagAttr(t *testing.T) {
+	cmdService := newFindTree(t)
+
+	if err := cmdService.Tag("a/note.txt", "work", "draft", "work"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.Tag("/a/b/deep.txt", "work"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.Tag("top.log", "bad tag"); err == nil {
+		t.Error("tag with space should fail")
+		return
+	}
+
+	if err := cmdService.SetAttr("a/note.txt", "owner", "amy"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.SetAttr("a/b", "owner", "bob"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	header, err := cmdService.Stat("a/note.txt")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if len(header.Tags) != 2 || header.Tags[0] != "draft" || header.Tags[1] != "work" {
+		t.Errorf("unexpected tags %v", header.Tags)
+		return
+	}
+
+	if value, err := cmdService.GetAttr("a/note.txt", "owner"); err != nil || value != "amy" {
+		t.Errorf("unexpected attribute %s", value)
+		return
+	}
+
+	matches, err := cmdService.Find("/", FindQuery{Filter: Filter{Tags: []string{"work"}}})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if got := matchPaths(matches); got != "/a/b/deep.txt,/a/note.txt" {
+		t.Errorf("unexpected tag match %s", got)
+		return
+	}
+
+	matches, err = cmdService.Find("/", FindQuery{Filter: Filter{Attrs: map[string]string{"owner": ""}}})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if got := matchPaths(matches); got != "/a/b,/a/note.txt" {
+		t.Errorf("unexpected attribute match %s", got)
+		return
+	}
+
+	page, err := cmdService.ListPage("a", ListQuery{Filter: Filter{Attrs: map[string]string{"owner": "bob"}}})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if len(page.Entries) != 1 || page.Entries[0].Name != "b" {
+		t.Error("unexpected attribute list")
+		return
+	}
+
+	// removed on untag and empty value, kept after reload
+	if err := cmdService.Untag("a/note.txt", "work"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.SetAttr("a/note.txt", "owner", ""); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	reloaded := NewCommandService(cmdService.GetCurrentUser().RootPath)
+	if err := reloaded.Use("testFind"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	header, err = reloaded.Stat("/a/note.txt")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if len(header.Tags) != 1 || header.Tags[0] != "draft" || header.Attrs != nil {
+		t.Errorf("unexpected metadata after reload %v %v", header.Tags, header.Attrs)
+		return
+	}
+
+	if _, err := reloaded.GetAttr("/a/note.txt", "owner"); err == nil {
+		t.Error("removed attribute should not exist")
+		return
+	}
+}
@@ -0,0 +1,530 @@
+package vfsgo
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// BackupManifestName: last entry of backup, checksum of every other
+	// entry in it
+	BackupManifestName = "vfsgo-backup.json"
+
+	// backupUserDir, backupBlobDir: entries of users and shared blob store
+	// in backup, users come first
+	backupUserDir = "users"
+	backupBlobDir = "blobs"
+)
+
+// BackupManifest: users in backup and checksum of every file of them
+// keyed by path in backup
+type BackupManifest struct {
+	CreatedTime time.Time             `json:"created_time"`
+	Users       []string              `json:"users"`
+	Files       map[string]BackupFile `json:"files"`
+}
+
+type BackupFile struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// RestoreReport: Users restored, Files and Bytes are files of them taken
+// from backup. Blobs were put into the shared store, blobs it had already
+// only took refs
+type RestoreReport struct {
+	Users []string
+	Files int
+	Bytes int64
+	Blobs int
+}
+
+// backupUsers: names of users under root
+func backupUsers(root string) ([]string, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, xerrors.Errorf("error in os.ReadDir: %w", err)
+	}
+
+	users := []string{}
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(root, e.Name(), UserINodeFileName)); err == nil {
+			users = append(users, e.Name())
+		}
+	}
+
+	return users, nil
+}
+
+// Backup: write every user under root and the shared blob store to w in
+// format as files on disk, so encrypted users are kept as they are. Root is
+// locked for backup, it is taken once commands of every process using root
+// ended and commands wait until it is done
+func (cs *commandService) Backup(w io.Writer, format string) error {
+	var gz *gzip.Writer
+	switch format {
+	case ArchiveTar:
+	case ArchiveTarGz:
+		gz = gzip.NewWriter(w)
+		w = gz
+	default:
+		return errorf(ErrInvalidName, "unknown backup format %s", format)
+	}
+
+	unlock, err := lockBackup(cs.root)
+	if err != nil {
+		return xerrors.Errorf("err in lockBackup: %w", err)
+	}
+	defer unlock()
+
+	users, err := backupUsers(cs.root)
+	if err != nil {
+		return xerrors.Errorf("err in backupUsers: %w", err)
+	}
+
+	b := backupWriter{
+		tw:       tar.NewWriter(w),
+		manifest: BackupManifest{CreatedTime: time.Now(), Users: users, Files: make(map[string]BackupFile)},
+	}
+
+	for _, name := range users {
+		if err := b.dir(filepath.Join(cs.root, name), path.Join(backupUserDir, name)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(cs.root, BlobDirName)); err == nil {
+		if err := b.dir(filepath.Join(cs.root, BlobDirName), backupBlobDir); err != nil {
+			return err
+		}
+	}
+
+	buf, err := json.MarshalIndent(b.manifest, "", "  ")
+	if err != nil {
+		return xerrors.Errorf("err in json.MarshalIndent: %w", err)
+	}
+
+	hdr := &tar.Header{Typeflag: tar.TypeReg, Name: BackupManifestName, Mode: 0644, Size: int64(len(buf)), ModTime: b.manifest.CreatedTime}
+	if err := b.tw.WriteHeader(hdr); err != nil {
+		return xerrors.Errorf("err in WriteHeader: %w", err)
+	}
+
+	if _, err := b.tw.Write(buf); err != nil {
+		return xerrors.Errorf("err in Write: %w", err)
+	}
+
+	if err := b.tw.Close(); err != nil {
+		return xerrors.Errorf("err in Close: %w", err)
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return xerrors.Errorf("err in Close: %w", err)
+		}
+	}
+
+	return nil
+}
+
+type backupWriter struct {
+	tw       *tar.Writer
+	manifest BackupManifest
+}
+
+// dir: every regular file under host as entry under rel
+func (b *backupWriter) dir(host, rel string) error {
+	return filepath.WalkDir(host, func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return xerrors.Errorf("error in WalkDir: %w", err)
+		}
+
+		// locks are of processes using root, not of its content
+		if !e.Type().IsRegular() || e.Name() == LockFileName {
+			return nil
+		}
+
+		r, err := filepath.Rel(host, p)
+		if err != nil {
+			return xerrors.Errorf("error in filepath.Rel: %w", err)
+		}
+
+		return b.file(p, path.Join(rel, filepath.ToSlash(r)))
+	})
+}
+
+func (b *backupWriter) file(host, rel string) error {
+	file, err := os.Open(host)
+	if err != nil {
+		return xerrors.Errorf("error in os.Open: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return xerrors.Errorf("error in Stat: %w", err)
+	}
+
+	hdr := &tar.Header{Typeflag: tar.TypeReg, Name: rel, Mode: int64(info.Mode().Perm()), Size: info.Size(), ModTime: info.ModTime()}
+	if err := b.tw.WriteHeader(hdr); err != nil {
+		return xerrors.Errorf("error in WriteHeader: %w", err)
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(b.tw, h), file)
+	if err != nil {
+		return xerrors.Errorf("error in io.Copy: %w", err)
+	}
+
+	b.manifest.Files[rel] = BackupFile{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}
+	return nil
+}
+
+// Restore: put users of backup read from r in format under root, all of
+// them or users only. Every file is checked against manifest before any
+// user is put, and a user existing under root is not replaced. Refs of
+// shared blobs are counted again from the restored users, so users can be
+// restored into a root having others
+func (cs *commandService) Restore(r io.Reader, format string, users []string) (RestoreReport, error) {
+	unlock, err := cs.lock()
+	if err != nil {
+		return RestoreReport{}, err
+	}
+	defer unlock()
+
+	report := RestoreReport{Users: []string{}}
+
+	switch format {
+	case ArchiveTar:
+	case ArchiveTarGz:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return report, errorf(ErrCorrupt, "gzip of backup: %v", err)
+		}
+		defer gz.Close()
+		r = gz
+	default:
+		return report, errorf(ErrInvalidName, "unknown backup format %s", format)
+	}
+
+	want, restored := make(map[string]bool), []string{}
+	for _, name := range users {
+		if want[name] {
+			continue
+		}
+
+		if err := cs.validRegister(name); err != nil {
+			return report, xerrors.Errorf("validate: %w", err)
+		}
+
+		if _, err := os.Stat(filepath.Join(cs.root, name)); err == nil {
+			return report, errorf(ErrExist, "user %s already exist", name)
+		}
+		want[name] = true
+		restored = append(restored, name)
+	}
+
+	staging, err := os.MkdirTemp(cs.root, ".restore-*")
+	if err != nil {
+		return report, xerrors.Errorf("err in os.MkdirTemp: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	rs := restorer{
+		staging: staging,
+		want:    want,
+		report:  &report,
+		seen:    make(map[string]BackupFile),
+		refs:    make(map[string]int),
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, errorf(ErrCorrupt, "backup: %v", err)
+		}
+
+		if err := rs.entry(hdr, tr); err != nil {
+			return report, err
+		}
+	}
+
+	if err := rs.check(); err != nil {
+		return report, err
+	}
+
+	if len(want) == 0 {
+		restored = rs.manifest.Users
+	}
+
+	in := make(map[string]bool)
+	for _, name := range rs.manifest.Users {
+		in[name] = true
+	}
+
+	for _, name := range restored {
+		if !in[name] {
+			return report, errorf(ErrNotExist, "user %s not in backup", name)
+		}
+
+		// names of manifest are checked as names given are
+		if err := cs.validRegister(name); err != nil {
+			return report, xerrors.Errorf("validate: %w", err)
+		}
+
+		if _, err := os.Stat(filepath.Join(cs.root, name)); err == nil {
+			return report, errorf(ErrExist, "user %s already exist", name)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(cs.root, BlobDirName), 0755); err != nil {
+		return report, xerrors.Errorf("err in os.MkdirAll: %w", err)
+	}
+
+	store := NewBlobStore(cs.root)
+	unlockStore, err := store.lock()
+	if err != nil {
+		return report, xerrors.Errorf("err in lock: %w", err)
+	}
+	defer unlockStore()
+
+	missing, err := rs.missingBlobs(store)
+	if err != nil {
+		return report, err
+	}
+
+	if len(missing) > 0 {
+		return report, errorf(ErrCorrupt, "blob %s not in backup", missing[0])
+	}
+
+	// blobs are in store with their refs before any user refers to them
+	if err := rs.putBlobs(store); err != nil {
+		return report, err
+	}
+
+	for _, name := range restored {
+		src := filepath.Join(staging, backupUserDir, name)
+		if err := os.Rename(src, filepath.Join(cs.root, name)); err != nil {
+			return report, xerrors.Errorf("err in os.Rename: %w", err)
+		}
+		report.Users = append(report.Users, name)
+	}
+
+	return report, nil
+}
+
+type restorer struct {
+	staging string
+	want    map[string]bool
+	report  *RestoreReport
+
+	manifest    BackupManifest
+	hasManifest bool
+
+	// seen: checksum of every entry read, refs: refs of shared blobs taken
+	// by files of restored users
+	seen map[string]BackupFile
+	refs map[string]int
+}
+
+func (rs *restorer) selected(name string) bool {
+	return len(rs.want) == 0 || rs.want[name]
+}
+
+// entry: entry of backup checked, and kept in staging when it is restored.
+// Blobs are only kept when a restored file has a ref of them
+func (rs *restorer) entry(hdr *tar.Header, r io.Reader) error {
+	name := hdr.Name
+	if name == BackupManifestName {
+		if err := json.NewDecoder(r).Decode(&rs.manifest); err != nil {
+			return errorf(ErrCorrupt, "manifest of backup: %v", err)
+		}
+		rs.hasManifest = true
+		return nil
+	}
+
+	if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+		return errorf(ErrCorrupt, "entry %s of backup is not a file", name)
+	}
+
+	// a path climbing out of staging is never restored
+	if path.Clean("/"+name) != "/"+name {
+		return errorf(ErrCorrupt, "entry %s of backup has invalid path", name)
+	}
+
+	dst := ""
+	parts := strings.SplitN(name, "/", 3)
+	switch {
+	case len(parts) < 3:
+	case parts[0] == backupUserDir && rs.selected(parts[1]):
+		dst = filepath.Join(rs.staging, filepath.FromSlash(name))
+	case parts[0] == backupBlobDir && !strings.HasSuffix(name, BlobRefSuffix):
+		if rs.refs[strings.TrimSuffix(parts[2], CompressedBlobSuffix)] > 0 {
+			dst = filepath.Join(rs.staging, filepath.FromSlash(name))
+		}
+	}
+
+	h := sha256.New()
+	w := io.Writer(h)
+
+	var file *os.File
+	if dst != "" {
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return xerrors.Errorf("error in os.MkdirAll: %w", err)
+		}
+
+		var err error
+		if file, err = os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(hdr.Mode).Perm()); err != nil {
+			return xerrors.Errorf("error in os.OpenFile: %w", err)
+		}
+		defer file.Close()
+		w = io.MultiWriter(file, h)
+	}
+
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return errorf(ErrCorrupt, "entry %s of backup: %v", name, err)
+	}
+	rs.seen[name] = BackupFile{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}
+
+	if dst == "" || parts[0] != backupUserDir {
+		return nil
+	}
+
+	rs.report.Files++
+	rs.report.Bytes += n
+
+	if path.Base(name) == BlockINodeFileName {
+		return rs.count(dst)
+	}
+
+	return nil
+}
+
+// count: refs files of block inode at path take of shared blobs, a block
+// encrypted in full mode has its blobs in the store of its user
+func (rs *restorer) count(p string) error {
+	block, ok, err := readPlainBlock(p)
+	if err != nil || !ok {
+		return err
+	}
+
+	for _, header := range block.FileMap {
+		if header.Type != File {
+			continue
+		}
+
+		for _, c := range header.contentChunks() {
+			if !validHash(c.Hash) {
+				return errorf(ErrCorrupt, "invalid blob hash %s in %s", c.Hash, p)
+			}
+			rs.refs[c.Hash]++
+		}
+	}
+
+	return nil
+}
+
+// check: every entry read match manifest, and nothing in manifest missing
+func (rs *restorer) check() error {
+	if !rs.hasManifest {
+		return NewError(ErrCorrupt, "backup has no manifest")
+	}
+
+	for name, want := range rs.manifest.Files {
+		got, ok := rs.seen[name]
+		if !ok {
+			return errorf(ErrCorrupt, "entry %s of backup missing", name)
+		}
+
+		if got != want {
+			return errorf(ErrCorrupt, "entry %s of backup not match its checksum", name)
+		}
+	}
+
+	for name := range rs.seen {
+		if _, ok := rs.manifest.Files[name]; !ok {
+			return errorf(ErrCorrupt, "entry %s of backup not in manifest", name)
+		}
+	}
+
+	return nil
+}
+
+// staged: blob of hash kept in staging, empty if none
+func (rs *restorer) staged(hash string) string {
+	for _, suffix := range []string{"", CompressedBlobSuffix} {
+		p := filepath.Join(rs.staging, backupBlobDir, hash[:2], hash+suffix)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+
+	return ""
+}
+
+// missingBlobs: blobs refs are taken of that neither store nor backup has
+func (rs *restorer) missingBlobs(store *BlobStore) ([]string, error) {
+	missing := []string{}
+	for hash := range rs.refs {
+		_, err := store.stat(hash)
+		if err == nil || rs.staged(hash) != "" {
+			continue
+		}
+		if !errors.Is(err, ErrNotExist) {
+			return nil, xerrors.Errorf("error in stat: %w", err)
+		}
+
+		missing = append(missing, hash)
+	}
+	sort.Strings(missing)
+
+	return missing, nil
+}
+
+// putBlobs: blobs store has not are moved into it, then refs of restored
+// files are added
+func (rs *restorer) putBlobs(store *BlobStore) error {
+	for hash, n := range rs.refs {
+		if _, err := store.stat(hash); err != nil {
+			src := rs.staged(hash)
+			dst := store.Path(hash) + strings.TrimPrefix(filepath.Base(src), hash)
+
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return xerrors.Errorf("error in os.MkdirAll: %w", err)
+			}
+
+			if err := os.Rename(src, dst); err != nil {
+				return xerrors.Errorf("error in os.Rename: %w", err)
+			}
+			rs.report.Blobs++
+		}
+
+		if err := store.addRef(hash, n); err != nil {
+			return xerrors.Errorf("error in addRef: %w", err)
+		}
+	}
+
+	return nil
+}
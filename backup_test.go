@@ -0,0 +1,306 @@
+package vfsgo
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newBackupRoot: alice and bob sharing one content, bob has one of his own
+func newBackupRoot(t *testing.T) (string, ICommandService) {
+	root := t.TempDir()
+	cmdService := NewCommandService(root)
+
+	must(t, cmdService.Register("alice"))
+	must(t, cmdService.Register("bob"))
+	must(t, cmdService.Use("alice"))
+	must(t, cmdService.CreateFolder("docs"))
+	must(t, cmdService.ChangeFolder("docs"))
+	must(t, cmdService.CreateFile("a.txt", "alice"))
+	must(t, cmdService.WriteFile("a.txt", strings.NewReader("shared content")))
+	must(t, cmdService.Use("bob"))
+	must(t, cmdService.CreateFile("b.txt", "bob"))
+	must(t, cmdService.WriteFile("b.txt", strings.NewReader("shared content")))
+	must(t, cmdService.CreateFile("c.txt", "bob"))
+	must(t, cmdService.WriteFile("c.txt", strings.NewReader("bob only")))
+
+	return root, cmdService
+}
+
+func contentHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestBackupRestore(t *testing.T) {
+	root, cmdService := newBackupRoot(t)
+
+	var buf bytes.Buffer
+	if err := cmdService.Backup(&buf, ArchiveTarGz); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// restored elsewhere, paths recorded in inodes are of the old root
+	target := t.TempDir()
+	restored := NewCommandService(target)
+	report, err := restored.Restore(bytes.NewReader(buf.Bytes()), ArchiveTarGz, nil)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if !reflect.DeepEqual(report.Users, []string{"alice", "bob"}) || report.Blobs != 2 {
+		t.Errorf("unexpected report %+v", report)
+		return
+	}
+
+	for _, name := range []string{"alice", "bob"} {
+		if err := cmdService.Use(name); err != nil {
+			t.Error(err.Error())
+			return
+		}
+
+		want, err := cmdService.RootHash()
+		if err != nil {
+			t.Error(err.Error())
+			return
+		}
+
+		if err := restored.Use(name); err != nil {
+			t.Error(err.Error())
+			return
+		}
+
+		if got, err := restored.RootHash(); err != nil || got != want {
+			t.Errorf("root hash of %s changed by restore: %s, want %s", name, got, want)
+			return
+		}
+
+		if got := damageList(t, restored); got != "" {
+			t.Errorf("damages of %s after restore %s", name, got)
+			return
+		}
+	}
+
+	if got := readAll(t, restored, "b.txt"); got != "shared content" {
+		t.Errorf("unexpected content %q", got)
+		return
+	}
+
+	if err := restored.WriteFile("c.txt", strings.NewReader("changed")); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if refs, err := NewBlobStore(root).Refs(contentHash("changed")); err != nil || refs != 0 {
+		t.Error("restored user wrote under old root")
+		return
+	}
+
+	if refs, err := NewBlobStore(target).Refs(contentHash("changed")); err != nil || refs != 1 {
+		t.Errorf("unexpected refs %d of written content", refs)
+		return
+	}
+}
+
+func TestRestoreUsers(t *testing.T) {
+	_, cmdService := newBackupRoot(t)
+
+	var buf bytes.Buffer
+	if err := cmdService.Backup(&buf, ArchiveTar); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	target := t.TempDir()
+	restored := NewCommandService(target)
+
+	report, err := restored.Restore(bytes.NewReader(buf.Bytes()), ArchiveTar, []string{"alice"})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if !reflect.DeepEqual(report.Users, []string{"alice"}) || report.Blobs != 1 {
+		t.Errorf("unexpected report %+v", report)
+		return
+	}
+
+	if err := restored.Use("bob"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("bob restored: %v", err)
+		return
+	}
+
+	store := NewBlobStore(target)
+	if refs, err := store.Refs(contentHash("shared content")); err != nil || refs != 1 {
+		t.Errorf("unexpected refs %d of shared content", refs)
+		return
+	}
+
+	// users already under root are kept, blobs shared with them take refs
+	if _, err := restored.Restore(bytes.NewReader(buf.Bytes()), ArchiveTar, nil); !errors.Is(err, ErrExist) {
+		t.Errorf("alice replaced: %v", err)
+		return
+	}
+
+	if _, err := restored.Restore(bytes.NewReader(buf.Bytes()), ArchiveTar, []string{"carol"}); !errors.Is(err, ErrNotExist) {
+		t.Errorf("carol restored: %v", err)
+		return
+	}
+
+	report, err = restored.Restore(bytes.NewReader(buf.Bytes()), ArchiveTar, []string{"bob"})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if report.Blobs != 1 {
+		t.Errorf("unexpected report %+v", report)
+		return
+	}
+
+	if refs, err := store.Refs(contentHash("shared content")); err != nil || refs != 2 {
+		t.Errorf("unexpected refs %d of shared content", refs)
+		return
+	}
+
+	if err := restored.Use("bob"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if got := readAll(t, restored, "c.txt"); got != "bob only" {
+		t.Errorf("unexpected content %q", got)
+		return
+	}
+}
+
+func TestRestoreDamaged(t *testing.T) {
+	_, cmdService := newBackupRoot(t)
+
+	var buf bytes.Buffer
+	if err := cmdService.Backup(&buf, ArchiveTar); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	damaged := bytes.Replace(buf.Bytes(), []byte("bob only"), []byte("bob 0nly"), 1)
+	if bytes.Equal(damaged, buf.Bytes()) {
+		t.Error("content not found in backup")
+		return
+	}
+
+	target := t.TempDir()
+	if _, err := NewCommandService(target).Restore(bytes.NewReader(damaged), ArchiveTar, nil); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("damaged backup restored: %v", err)
+		return
+	}
+
+	entries, err := os.ReadDir(target)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	for _, e := range entries {
+		if e.Name() != LockFileName {
+			t.Errorf("damaged backup left %s under root", e.Name())
+			return
+		}
+	}
+}
+
+func TestBackupWaitsForCommands(t *testing.T) {
+	root, cmdService := newBackupRoot(t)
+
+	// a command of another process holds the flock of root shared
+	file, err := os.Open(filepath.Join(root, LockFileName))
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer file.Close()
+
+	if err := flockFile(file, false); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmdService.Backup(io.Discard, ArchiveTar) }()
+
+	select {
+	case err := <-done:
+		t.Errorf("backup taken while a command runs: %v", err)
+		return
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := funlockFile(file); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := <-done; err != nil {
+		t.Error(err.Error())
+		return
+	}
+}
+
+func TestRestoreInvalidUser(t *testing.T) {
+	inode := []byte("{}")
+	manifest, err := json.Marshal(BackupManifest{
+		Users: []string{"a%b"},
+		Files: map[string]BackupFile{
+			"users/a%b/" + UserINodeFileName: {Size: int64(len(inode)), SHA256: contentHash(string(inode))},
+		},
+	})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range []struct {
+		name string
+		data []byte
+	}{{"users/a%b/" + UserINodeFileName, inode}, {BackupManifestName, manifest}} {
+		if err := tw.WriteHeader(&tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.data))}); err != nil {
+			t.Error(err.Error())
+			return
+		}
+
+		if _, err := tw.Write(e.data); err != nil {
+			t.Error(err.Error())
+			return
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	target := t.TempDir()
+	if _, err := NewCommandService(target).Restore(&buf, ArchiveTar, nil); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("user of invalid name restored: %v", err)
+		return
+	}
+
+	if _, err := os.Stat(filepath.Join(target, "a%b")); !os.IsNotExist(err) {
+		t.Errorf("user of invalid name under root: %v", err)
+		return
+	}
+}
@@ -0,0 +1,486 @@
+package vfsgo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	BlobDirName   = ".blobs"
+	BlobRefSuffix = ".ref"
+)
+
+// blobMu: ref counts are shared by every user under root, services of
+// different users may run at the same time. Processes sharing the root are
+// kept apart by flock of LockFileName in store dir, see lock
+var blobMu sync.Mutex
+
+// BlobStore: file contents keyed by sha256 of content under root, shared by
+// every file, version and user, a blob is removed when its last ref is
+// released. Store of an encrypted user is under the user and its blobs are
+// encrypted and named by keyed hash
+type BlobStore struct {
+	root   string
+	seal   *sealer
+	locked bool
+}
+
+// NewBlobStore: store under root, the dir of all users
+func NewBlobStore(root string) *BlobStore {
+	return &BlobStore{root: root}
+}
+
+// blobStore: store of the root the block belongs to, or of its user when
+// the user is encrypted in full mode
+func (b *BlockINode) blobStore() *BlobStore {
+	if s, ok := keyOf(b.UserPath); ok && (s == nil || !s.namesOnly) {
+		return &BlobStore{root: b.UserPath, seal: s, locked: s == nil}
+	}
+
+	return NewBlobStore(filepath.Dir(b.UserPath))
+}
+
+// Path: blob file of hash, fanned out by the first byte
+func (s *BlobStore) Path(hash string) string {
+	name := hash
+	if s.seal != nil {
+		name = s.seal.name(hash)
+	}
+
+	return filepath.Join(s.root, BlobDirName, name[:2], name)
+}
+
+func (s *BlobStore) check(hash string) error {
+	if s.locked {
+		return NewError(ErrLocked, "user is encrypted, unlock it first")
+	}
+
+	if !validHash(hash) {
+		return errorf(ErrInvalidName, "invalid blob hash %s", hash)
+	}
+
+	return nil
+}
+
+func validHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// Blob: stored content, Size is the length of content and Stored the bytes
+// taken on disk
+type Blob struct {
+	Hash        string
+	Size        int64
+	Stored      int64
+	Compression string
+}
+
+// Put: store content read from r and take a ref of it, the same content is
+// stored once in the form it is first put. With codec it is compressed if
+// that is smaller
+func (s *BlobStore) Put(r io.Reader, codec string) (Blob, error) {
+	if s.locked {
+		return Blob{}, NewError(ErrLocked, "user is encrypted, unlock it first")
+	}
+
+	dir := filepath.Join(s.root, BlobDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Blob{}, xerrors.Errorf("error in os.MkdirAll: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "put.*")
+	if err != nil {
+		return Blob{}, xerrors.Errorf("error in os.CreateTemp: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		tmp.Close()
+		return Blob{}, xerrors.Errorf("error in io.Copy: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return Blob{}, xerrors.Errorf("error in tmp.Close: %w", err)
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	unlock, err := s.lock()
+	if err != nil {
+		return Blob{}, err
+	}
+	defer unlock()
+
+	_, err = s.stat(hash)
+	if errors.Is(err, ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(s.Path(hash)), 0755); err != nil {
+			return Blob{}, xerrors.Errorf("error in os.MkdirAll: %w", err)
+		}
+
+		if err := s.store(tmp.Name(), hash, size, codec); err != nil {
+			return Blob{}, xerrors.Errorf("error in store: %w", err)
+		}
+	} else if err != nil {
+		return Blob{}, xerrors.Errorf("error in stat: %w", err)
+	}
+
+	if err := s.addRef(hash, 1); err != nil {
+		return Blob{}, xerrors.Errorf("error in addRef: %w", err)
+	}
+
+	return s.stat(hash)
+}
+
+// store: move content at tmp into blob of hash, compressed if smaller and
+// encrypted if store is
+func (s *BlobStore) store(tmp, hash string, size int64, codec string) error {
+	src, dst := tmp, s.Path(hash)
+	if codec == CompressionGzip {
+		z, err := compressTemp(tmp, size)
+		if err != nil {
+			return xerrors.Errorf("error in compressTemp: %w", err)
+		}
+
+		if z != "" {
+			defer os.Remove(z)
+			src, dst = z, dst+CompressedBlobSuffix
+		}
+	}
+
+	if s.seal == nil {
+		return os.Rename(src, dst)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return xerrors.Errorf("error in os.Open: %w", err)
+	}
+	defer in.Close()
+
+	return writeTemp(dst, func(w io.Writer) error {
+		return writeSegments(w, in, s.seal.aead)
+	})
+}
+
+// compressTemp: chunked compressed copy of tmp beside it, empty if it is
+// not smaller than size
+func compressTemp(tmp string, size int64) (string, error) {
+	in, err := os.Open(tmp)
+	if err != nil {
+		return "", xerrors.Errorf("error in os.Open: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(tmp), "put.*")
+	if err != nil {
+		return "", xerrors.Errorf("error in os.CreateTemp: %w", err)
+	}
+
+	if err := writeChunked(out, in); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return "", xerrors.Errorf("error in writeChunked: %w", err)
+	}
+
+	info, err := out.Stat()
+	if err == nil {
+		err = out.Close()
+	} else {
+		out.Close()
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", xerrors.Errorf("error in out.Close: %w", err)
+	}
+
+	if info.Size() >= size {
+		os.Remove(out.Name())
+		return "", nil
+	}
+
+	return out.Name(), nil
+}
+
+// writeTemp: write file at path by fn through a temp file in the same dir,
+// so path is never left half written
+func writeTemp(path string, fn func(w io.Writer) error) error {
+	out, err := os.CreateTemp(filepath.Dir(path), ".tmp.*")
+	if err != nil {
+		return xerrors.Errorf("error in os.CreateTemp: %w", err)
+	}
+	defer os.Remove(out.Name())
+
+	if err := fn(out); err != nil {
+		out.Close()
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		return xerrors.Errorf("error in out.Close: %w", err)
+	}
+
+	return os.Rename(out.Name(), path)
+}
+
+// Stat: stored form of blob
+func (s *BlobStore) Stat(hash string) (Blob, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return Blob{}, err
+	}
+	defer unlock()
+
+	return s.stat(hash)
+}
+
+func (s *BlobStore) stat(hash string) (Blob, error) {
+	if err := s.check(hash); err != nil {
+		return Blob{}, err
+	}
+
+	for _, codec := range []string{CompressionNone, CompressionGzip} {
+		path := s.Path(hash)
+		if codec == CompressionGzip {
+			path += CompressedBlobSuffix
+		}
+
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return Blob{}, xerrors.Errorf("error in os.Stat: %w", err)
+		}
+
+		if codec == CompressionNone && s.seal == nil {
+			return Blob{Hash: hash, Size: info.Size(), Stored: info.Size(), Compression: codec}, nil
+		}
+
+		content, err := s.open(path, codec)
+		if err != nil {
+			return Blob{}, xerrors.Errorf("error in open: %w", err)
+		}
+		size := content.size()
+		content.Close()
+
+		return Blob{Hash: hash, Size: size, Stored: info.Size(), Compression: codec}, nil
+	}
+
+	return Blob{}, errorf(ErrNotExist, "blob %s not exist", hash)
+}
+
+// lock: lock refs of store for this process and, once the store dir
+// exists, for every process using it
+func (s *BlobStore) lock() (func(), error) {
+	blobMu.Lock()
+
+	file, err := os.OpenFile(filepath.Join(s.root, BlobDirName, LockFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if os.IsNotExist(err) {
+		return blobMu.Unlock, nil
+	}
+	if err != nil {
+		blobMu.Unlock()
+		return nil, xerrors.Errorf("error in os.OpenFile: %w", err)
+	}
+
+	if err := flockFile(file, true); err != nil {
+		file.Close()
+		blobMu.Unlock()
+		return nil, xerrors.Errorf("error in flockFile: %w", err)
+	}
+
+	return func() {
+		funlockFile(file)
+		file.Close()
+		blobMu.Unlock()
+	}, nil
+}
+
+// Ref: take one more ref of blob
+func (s *BlobStore) Ref(hash string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := s.stat(hash); err != nil {
+		return xerrors.Errorf("error in stat: %w", err)
+	}
+
+	return s.addRef(hash, 1)
+}
+
+// Release: drop one ref of blob, blob is removed with its last ref
+func (s *BlobStore) Release(hash string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.addRef(hash, -1)
+}
+
+// Refs: ref count of blob
+func (s *BlobStore) Refs(hash string) (int, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	return s.refs(hash)
+}
+
+func (s *BlobStore) refs(hash string) (int, error) {
+	buf, err := ioutil.ReadFile(s.Path(hash) + BlobRefSuffix)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, xerrors.Errorf("error in ioutil.ReadFile: %w", err)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+	if err != nil {
+		return 0, xerrors.Errorf("error in strconv.Atoi: %w", err)
+	}
+
+	return n, nil
+}
+
+func (s *BlobStore) addRef(hash string, delta int) error {
+	if !validHash(hash) {
+		return errorf(ErrInvalidName, "invalid blob hash %s", hash)
+	}
+
+	n, err := s.refs(hash)
+	if err != nil {
+		return err
+	}
+
+	n += delta
+	if n <= 0 {
+		for _, suffix := range []string{"", CompressedBlobSuffix} {
+			if err := os.Remove(s.Path(hash) + suffix); err != nil && !os.IsNotExist(err) {
+				return xerrors.Errorf("error in os.Remove: %w", err)
+			}
+		}
+
+		if err := os.Remove(s.Path(hash) + BlobRefSuffix); err != nil && !os.IsNotExist(err) {
+			return xerrors.Errorf("error in os.Remove: %w", err)
+		}
+		return nil
+	}
+
+	if err := ioutil.WriteFile(s.Path(hash)+BlobRefSuffix, []byte(strconv.Itoa(n)), 0666); err != nil {
+		return xerrors.Errorf("error in ioutil.WriteFile: %w", err)
+	}
+
+	return nil
+}
+
+// Open: open blob for read, compressed blob is decompressed and encrypted
+// blob decrypted on read
+func (s *BlobStore) Open(hash string) (Content, error) {
+	if err := s.check(hash); err != nil {
+		return nil, err
+	}
+
+	content, err := s.open(s.Path(hash), CompressionNone)
+	if os.IsNotExist(err) {
+		content, err = s.open(s.Path(hash)+CompressedBlobSuffix, CompressionGzip)
+	}
+	if os.IsNotExist(err) {
+		return nil, errorf(ErrNotExist, "blob %s not exist", hash)
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("error in open: %w", err)
+	}
+
+	return content, nil
+}
+
+// sizedContent: content knowing its length
+type sizedContent interface {
+	Content
+	size() int64
+}
+
+// open: blob file at path stored in form of codec, error of os.Open is
+// returned as is
+func (s *BlobStore) open(path, codec string) (sizedContent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var content sizedContent = osContent{file}
+	if s.seal != nil {
+		if content, err = openSealed(file, s.seal.aead); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	if codec == CompressionGzip {
+		c, err := openChunked(content, content.size())
+		if err != nil {
+			content.Close()
+			return nil, err
+		}
+		content = c
+	}
+
+	return content, nil
+}
+
+type osContent struct {
+	*os.File
+}
+
+func (f osContent) size() int64 {
+	info, err := f.Stat()
+	if err != nil {
+		return 0
+	}
+
+	return info.Size()
+}
+
+// Verify: rehash blob, ErrCorrupt if content not match its hash
+func (s *BlobStore) Verify(hash string) error {
+	file, err := s.Open(hash)
+	if err != nil {
+		return xerrors.Errorf("error in Open: %w", err)
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return xerrors.Errorf("error in io.Copy: %w", err)
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != hash {
+		return errorf(ErrCorrupt, "blob %s is corrupted", hash)
+	}
+
+	return nil
+}
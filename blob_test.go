@@ -0,0 +1,197 @@
+package vfsgo
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlobStore(t *testing.T) {
+	store := NewBlobStore(t.TempDir())
+
+	blob, err := store.Put(strings.NewReader("same"), CompressionNone)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	hash := blob.Hash
+
+	again, err := store.Put(strings.NewReader("same"), CompressionGzip)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if hash != again.Hash || blob.Size != 4 || again.Compression != CompressionNone {
+		t.Error("same content should be the same blob")
+		return
+	}
+
+	if n, _ := store.Refs(hash); n != 2 {
+		t.Errorf("unexpected refs %d", n)
+		return
+	}
+
+	if err := store.Verify(hash); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := ioutil.WriteFile(store.Path(hash), []byte("evil"), 0666); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := store.Verify(hash); !errors.Is(err, ErrCorrupt) {
+		t.Error("changed blob should be corrupted")
+		return
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := store.Release(hash); err != nil {
+			t.Error(err.Error())
+			return
+		}
+	}
+
+	if _, err := os.Stat(store.Path(hash)); !os.IsNotExist(err) {
+		t.Error("blob should be removed with last ref")
+		return
+	}
+}
+
+func TestDedupContent(t *testing.T) {
+	root := t.TempDir()
+	cmdService := NewCommandService(root)
+
+	for _, name := range []string{"testDedupA", "testDedupB"} {
+		must(t, cmdService.Register(name))
+		must(t, cmdService.Use(name))
+		must(t, cmdService.CreateFile("file", ""))
+		must(t, cmdService.WriteFile("file", strings.NewReader("shared content")))
+	}
+
+	if err := cmdService.Copy("file", "/"); err == nil {
+		t.Error("copy onto itself should fail")
+		return
+	}
+
+	if err := cmdService.CreateFolder("dir"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.Copy("file", "dir"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	store := NewBlobStore(root)
+	hash := cmdService.GetCurrentBlock().FileMap["file"].ContentHash
+	if n, _ := store.Refs(hash); n != 3 {
+		t.Errorf("unexpected refs %d of shared content", n)
+		return
+	}
+
+	if err := cmdService.WriteFile("file", strings.NewReader("changed")); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.Remove("dir"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if n, _ := store.Refs(hash); n != 1 {
+		t.Errorf("unexpected refs %d after rewrite and remove", n)
+		return
+	}
+
+	if err := cmdService.Use("testDedupA"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.DeleteFile("file"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if _, err := os.Stat(store.Path(hash)); !os.IsNotExist(err) {
+		t.Error("blob should be removed with last file")
+		return
+	}
+}
+
+func TestDeleteUserReleasesBlobs(t *testing.T) {
+	root, _ := newBackupRoot(t)
+	store := NewBlobStore(root)
+
+	if err := DeleteUser(root, "bob"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if n, err := store.Refs(contentHash("shared content")); err != nil || n != 1 {
+		t.Errorf("unexpected refs %d of shared content", n)
+		return
+	}
+
+	if _, err := os.Stat(store.Path(contentHash("bob only"))); !os.IsNotExist(err) {
+		t.Error("blob of deleted user should be removed")
+		return
+	}
+}
+
+func TestBlobStoreLock(t *testing.T) {
+	store := NewBlobStore(t.TempDir())
+
+	blob, err := store.Put(strings.NewReader("locked"), CompressionNone)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// another process holds the flock of store
+	file, err := os.Open(filepath.Join(store.root, BlobDirName, LockFileName))
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer file.Close()
+
+	if err := flockFile(file, true); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- store.Ref(blob.Hash) }()
+
+	select {
+	case err := <-done:
+		t.Errorf("ref taken while store is locked: %v", err)
+		return
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := funlockFile(file); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := <-done; err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if n, _ := store.Refs(blob.Hash); n != 2 {
+		t.Errorf("unexpected refs %d", n)
+		return
+	}
+}
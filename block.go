@@ -1,8 +1,8 @@
 package vfsgo
 
 import (
+	"bytes"
 	"encoding/json"
-	"io/ioutil"
 	"os"
 	"strconv"
 
@@ -21,6 +21,10 @@ type BlockINode struct {
 	NodeID     uint64 `json:"node_id"`
 	// FileMap: file name -> file hash name
 	FileMap map[string]FileHeader `json:"file_map"`
+	// Usage: cached aggregate of subtree, nil if user not keep usage cache
+	Usage *BlockUsage `json:"usage,omitempty"`
+	// Compression: codec of content written under block, empty inherit
+	Compression string `json:"compression,omitempty"`
 }
 
 func (b *BlockINode) GetBlockPath() string {
@@ -32,30 +36,83 @@ func (b *BlockINode) GetBlockINodePath() string {
 }
 
 func (b *BlockINode) Save() error {
-	file, err := os.OpenFile(b.GetBlockINodePath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	block, err := b.hideNames()
 	if err != nil {
-		return xerrors.Errorf("error in os.OpenFile: %w", os.Create)
+		return xerrors.Errorf("error in hideNames: %w", err)
 	}
-	defer file.Close()
 
-	buf, err := json.Marshal(b)
+	buf, err := json.Marshal(block)
 	if err != nil {
-		return xerrors.Errorf("error in json.Marshal: %w", os.Create)
+		return xerrors.Errorf("error in json.Marshal: %w", err)
 	}
 
-	if _, err := file.Write(buf); err != nil {
-		return xerrors.Errorf("error in file.Write: %w", os.Create)
+	if err := writeINode(b.GetBlockINodePath(), buf); err != nil {
+		return xerrors.Errorf("error in writeINode: %w", err)
 	}
 
 	return nil
 }
 
+// hideNames: copy of block to save, with names hidden when user is
+// encrypted in names mode
+func (b *BlockINode) hideNames() (*BlockINode, error) {
+	s := namesSealer(b.UserPath)
+	if s == nil {
+		return b, nil
+	}
+
+	fileMap, err := s.hideNames(b.FileMap)
+	if err != nil {
+		return nil, err
+	}
+
+	block := *b
+	block.FileMap = fileMap
+	return &block, nil
+}
+
+// showNames: names of loaded block in plain
+func (b *BlockINode) showNames() error {
+	s := namesSealer(b.UserPath)
+	if s == nil {
+		return nil
+	}
+
+	fileMap, err := s.showNames(b.FileMap)
+	if err != nil {
+		return err
+	}
+
+	b.FileMap = fileMap
+	return nil
+}
+
+// readPlainBlock: block inode at path as saved, not ok when it is
+// encrypted. Names of a block encrypted in names mode are left hidden
+func readPlainBlock(path string) (BlockINode, bool, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return BlockINode{}, false, xerrors.Errorf("error in os.ReadFile: %w", err)
+	}
+
+	if bytes.HasPrefix(buf, []byte(sealMagic)) {
+		return BlockINode{}, false, nil
+	}
+
+	var block BlockINode
+	if err := json.Unmarshal(buf, &block); err != nil {
+		return BlockINode{}, false, errorf(ErrCorrupt, "block inode %s: %v", path, err)
+	}
+
+	return block, true, nil
+}
+
 func CreateBlock(block *BlockINode, id uint64) (BlockINode, error) {
 	if id != 0 {
 		// check is parent block exist
 		_, err := os.Stat(block.GetBlockPath())
 		if err != nil {
-			return BlockINode{}, xerrors.New(("parent block path not exist"))
+			return BlockINode{}, NewError(ErrNotExist, "parent block path not exist")
 		}
 	}
 
@@ -85,20 +142,19 @@ func GetBlock(user *User, id uint64) (BlockINode, error) {
 		NodeID:   id,
 	}
 
-	file, err := os.Open(block.GetBlockINodePath())
+	b, err := readINode(block.GetBlockINodePath())
 	if err != nil {
-		return BlockINode{}, xerrors.Errorf("error in os.Open: %w", err)
-	}
-	defer file.Close()
-
-	b, err := ioutil.ReadAll(file)
-	if err != nil {
-		return BlockINode{}, xerrors.Errorf("error in ioutil.ReadAll: %w", err)
+		return BlockINode{}, xerrors.Errorf("error in readINode: %w", err)
 	}
 
 	if err := json.Unmarshal(b, &block); err != nil {
 		return BlockINode{}, xerrors.Errorf("error in json.Unmarshal: %w", err)
 	}
+	block.UserPath = user.GetUserPath()
+
+	if err := block.showNames(); err != nil {
+		return BlockINode{}, xerrors.Errorf("error in showNames: %w", err)
+	}
 
 	return block, nil
 }
@@ -106,7 +162,7 @@ func GetBlock(user *User, id uint64) (BlockINode, error) {
 func DeleteBlock(user *User, id uint64) error {
 	block, ok := user.BlockMap[id]
 	if !ok {
-		return xerrors.New("block not exist")
+		return NewError(ErrNotExist, "block not exist")
 	}
 
 	// remove folder
@@ -0,0 +1,567 @@
+package vfsgo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// ChunkMinSize, ChunkAvgSize, ChunkMaxSize: bounds of content defined
+	// chunks, a content not longer than one chunk is kept as a single blob
+	ChunkMinSize = 64 << 10
+	ChunkAvgSize = 256 << 10
+	ChunkMaxSize = 1 << 20
+
+	// chunkWindow: bytes the gear hash depends on
+	chunkWindow = 64
+)
+
+// gear: random value of every byte for the rolling hash, derived from sha256
+// so chunk boundaries are the same in every build
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	for i := range table {
+		sum := sha256.Sum256([]byte{byte(i)})
+		table[i] = binary.BigEndian.Uint64(sum[:])
+	}
+
+	return table
+}()
+
+// cutPoint: length of the first chunk of data, a boundary is where the hash
+// of the last chunkWindow bytes hits the mask so an insert only moves the
+// boundaries near it
+func cutPoint(data []byte) int {
+	if len(data) <= ChunkMinSize {
+		return len(data)
+	}
+
+	if len(data) > ChunkMaxSize {
+		data = data[:ChunkMaxSize]
+	}
+
+	var hash uint64
+	for i := ChunkMinSize - chunkWindow; i < len(data); i++ {
+		hash = hash<<1 + gear[data[i]]
+		if i >= ChunkMinSize && hash&(ChunkAvgSize-1) == 0 {
+			return i + 1
+		}
+	}
+
+	return len(data)
+}
+
+// SplitChunks: call fn with every content defined chunk of r in order, data
+// is only valid during the call. Clients split their copy the same way to
+// find which chunks of a file changed
+func SplitChunks(r io.Reader, fn func(data []byte) error) error {
+	buf := make([]byte, ChunkMaxSize)
+	n, eof := 0, false
+
+	for {
+		if !eof {
+			m, err := io.ReadFull(r, buf[n:])
+			n += m
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eof = true
+			} else if err != nil {
+				return xerrors.Errorf("error in io.ReadFull: %w", err)
+			}
+		}
+
+		if n == 0 {
+			return nil
+		}
+
+		cut := cutPoint(buf[:n])
+		if err := fn(buf[:cut]); err != nil {
+			return err
+		}
+
+		copy(buf, buf[cut:n])
+		n -= cut
+	}
+}
+
+// putChunks: store every chunk of r, refs taken are dropped on error
+func putChunks(store *BlobStore, r io.Reader, codec string) ([]Blob, error) {
+	chunks := []Blob{}
+
+	err := SplitChunks(r, func(data []byte) error {
+		blob, err := store.Put(bytes.NewReader(data), codec)
+		if err != nil {
+			return xerrors.Errorf("error in Put: %w", err)
+		}
+
+		chunks = append(chunks, blob)
+		return nil
+	})
+	if err != nil {
+		releaseChunks(store, chunks)
+		return nil, err
+	}
+
+	return chunks, nil
+}
+
+func releaseChunks(store *BlobStore, chunks []Blob) error {
+	for _, c := range chunks {
+		if err := store.Release(c.Hash); err != nil {
+			return xerrors.Errorf("error in Release: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func refChunks(store *BlobStore, chunks []Blob) error {
+	for _, c := range chunks {
+		if err := store.Ref(c.Hash); err != nil {
+			return xerrors.Errorf("error in Ref: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// contentChunks: blobs content of file is made of, in order
+func (f *FileHeader) contentChunks() []Blob {
+	if len(f.Chunks) > 0 {
+		return append([]Blob(nil), f.Chunks...)
+	}
+
+	if f.ContentHash != "" {
+		return []Blob{{Hash: f.ContentHash, Size: f.Size, Stored: f.StoredSize, Compression: f.Compression}}
+	}
+
+	return nil
+}
+
+// setContent: file content made of chunks, a single chunk is kept in
+// ContentHash and Compression is the one of any compressed chunk
+func (f *FileHeader) setContent(chunks []Blob) {
+	f.ContentHash, f.Chunks = "", nil
+	f.Size, f.StoredSize = 0, 0
+	f.Compression = CompressionNone
+
+	for _, c := range chunks {
+		f.Size += c.Size
+		f.StoredSize += c.Stored
+		if c.Compression != CompressionNone {
+			f.Compression = c.Compression
+		}
+	}
+
+	switch {
+	case len(chunks) == 1:
+		f.ContentHash = chunks[0].Hash
+	case len(chunks) > 1:
+		f.Chunks = chunks
+	}
+}
+
+// manifestReader: content of chunks read as one, the chunk last read is
+// kept open and guarded by mu so ReadAt is safe for concurrent use
+type manifestReader struct {
+	store   *BlobStore
+	chunks  []Blob
+	offsets []int64
+
+	mu      sync.Mutex
+	opened  int
+	content Content
+	pos     int64
+}
+
+func openManifest(store *BlobStore, chunks []Blob) *manifestReader {
+	offsets := make([]int64, len(chunks)+1)
+	for i, c := range chunks {
+		offsets[i+1] = offsets[i] + c.Size
+	}
+
+	return &manifestReader{store: store, chunks: chunks, offsets: offsets, opened: -1}
+}
+
+func (r *manifestReader) size() int64 {
+	return r.offsets[len(r.chunks)]
+}
+
+// find: index of chunk containing off
+func (r *manifestReader) find(off int64) int {
+	return sort.Search(len(r.chunks), func(i int) bool {
+		return r.offsets[i+1] > off
+	})
+}
+
+func (r *manifestReader) open(i int) (Content, error) {
+	if i == r.opened {
+		return r.content, nil
+	}
+
+	content, err := r.store.Open(r.chunks[i].Hash)
+	if err != nil {
+		return nil, xerrors.Errorf("error in Open: %w", err)
+	}
+
+	if r.content != nil {
+		r.content.Close()
+	}
+	r.opened, r.content = i, content
+
+	return content, nil
+}
+
+func (r *manifestReader) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for n < len(p) {
+		if off >= r.size() {
+			return n, io.EOF
+		}
+
+		i := r.find(off)
+		content, err := r.open(i)
+		if err != nil {
+			return n, err
+		}
+
+		want := len(p) - n
+		if left := r.offsets[i+1] - off; int64(want) > left {
+			want = int(left)
+		}
+
+		m, err := content.ReadAt(p[n:n+want], off-r.offsets[i])
+		n += m
+		off += int64(m)
+		if m < want {
+			if err == nil || err == io.EOF {
+				err = errorf(ErrCorrupt, "chunk %s shorter than manifest", r.chunks[i].Hash)
+			}
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+func (r *manifestReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *manifestReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.content == nil {
+		return nil
+	}
+
+	err := r.content.Close()
+	r.content, r.opened = nil, -1
+	return err
+}
+
+// WriteFileContentAt: write everything read from r into file at offset, a
+// gap after the end is filled by zero. Only the chunks the write touch are
+// stored again, so an append rewrites the last chunk only
+func WriteFileContentAt(block *BlockINode, filename string, offset int64, r io.Reader, codec string) (FileHeader, error) {
+	header, ok := block.FileMap[filename]
+	if !ok {
+		return FileHeader{}, NewError(ErrNotExist, "file not found")
+	}
+
+	if header.Type != File {
+		return FileHeader{}, NewError(ErrIsDir, "not a file")
+	}
+
+	if offset < 0 {
+		return FileHeader{}, errorf(ErrInvalidName, "negative offset %d", offset)
+	}
+
+	spool, err := os.CreateTemp("", "vfsgo-write-*")
+	if err != nil {
+		return FileHeader{}, xerrors.Errorf("error in os.CreateTemp: %w", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	n, err := io.Copy(spool, r)
+	if err != nil {
+		return FileHeader{}, xerrors.Errorf("error in io.Copy: %w", err)
+	}
+
+	store := block.blobStore()
+	cur := header.contentChunks()
+
+	// refs taken are released on every error until the new manifest is
+	// saved, the ones of old content only after
+	taken := [][]Blob{}
+	release := func() {
+		for _, chunks := range taken {
+			releaseChunks(store, chunks)
+		}
+	}
+
+	// content beside header is put into store first, its refs are dropped
+	// once the new manifest took its own
+	legacy := false
+	if len(cur) == 0 {
+		in, err := os.Open(header.GetContentPath(block.GetBlockPath()))
+		if err != nil && !os.IsNotExist(err) {
+			return FileHeader{}, xerrors.Errorf("error in os.Open: %w", err)
+		}
+
+		if err == nil {
+			cur, err = putChunks(store, in, codec)
+			in.Close()
+			if err != nil {
+				return FileHeader{}, xerrors.Errorf("error in putChunks: %w", err)
+			}
+			legacy = true
+			taken = append(taken, cur)
+		}
+	}
+
+	old := openManifest(store, cur)
+	defer old.Close()
+
+	size := old.size()
+	if n == 0 && offset <= size {
+		if legacy {
+			if err := releaseChunks(store, cur); err != nil {
+				return FileHeader{}, xerrors.Errorf("error in releaseChunks: %w", err)
+			}
+		}
+		return header, nil
+	}
+
+	// chunks i..j are stored again with the write spliced in
+	i, j := old.find(offset), old.find(offset+n-1)
+	if i > len(cur)-1 {
+		i = len(cur) - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	if j > len(cur)-1 {
+		j = len(cur) - 1
+	}
+
+	start, end := old.offsets[i], old.offsets[j+1]
+	parts := []io.Reader{io.NewSectionReader(old, start, minInt64(offset, size)-start)}
+	if offset > size {
+		parts = append(parts, io.LimitReader(zeroReader{}, offset-size))
+	}
+	parts = append(parts, io.NewSectionReader(spool, 0, n))
+	if offset+n < end {
+		parts = append(parts, io.NewSectionReader(old, offset+n, end-offset-n))
+	}
+
+	region, err := putChunks(store, io.MultiReader(parts...), codec)
+	if err != nil {
+		release()
+		return FileHeader{}, xerrors.Errorf("error in putChunks: %w", err)
+	}
+	taken = append(taken, region)
+
+	kept := append(append([]Blob(nil), cur[:i]...), cur[minInt(j+1, len(cur)):]...)
+	if err := refChunks(store, kept); err != nil {
+		release()
+		return FileHeader{}, xerrors.Errorf("error in refChunks: %w", err)
+	}
+	taken = append(taken, kept)
+
+	chunks := append(append(append([]Blob(nil), cur[:i]...), region...), cur[minInt(j+1, len(cur)):]...)
+
+	prev := header
+	header.setContent(chunks)
+	header.ModifiedTime = time.Now()
+	if err := header.Save(block.GetBlockPath()); err != nil {
+		release()
+		return FileHeader{}, xerrors.Errorf("error in header.Save: %w", err)
+	}
+
+	block.FileMap[filename] = header
+	if err := block.Save(); err != nil {
+		block.FileMap[filename] = prev
+		prev.Save(block.GetBlockPath())
+		release()
+		return FileHeader{}, xerrors.Errorf("error in block.Save: %w", err)
+	}
+
+	// new manifest holds its refs, old content is released
+	if legacy {
+		if err := releaseChunks(store, cur); err != nil {
+			return FileHeader{}, xerrors.Errorf("error in releaseChunks: %w", err)
+		}
+	}
+
+	if err := releaseContent(block, prev); err != nil {
+		return FileHeader{}, xerrors.Errorf("error in releaseContent: %w", err)
+	}
+
+	return header, nil
+}
+
+// migrateContents: contents beside headers, written before the blob store,
+// are put into store of current user so their hashes are recorded in the
+// merkle tree and checked by Verify
+func (cs *commandService) migrateContents() error {
+	deltas := make(map[uint64]BlockUsage)
+	for id := range cs.currentUser.BlockMap {
+		block := cs.currentUser.BlockMap[id]
+		store := block.blobStore()
+
+		legacies := []string{}
+		for name, header := range block.FileMap {
+			if header.Type != File || len(header.contentChunks()) > 0 {
+				continue
+			}
+
+			legacy := header.GetContentPath(block.GetBlockPath())
+			if _, err := os.Stat(legacy); os.IsNotExist(err) {
+				continue
+			}
+
+			in, err := OpenFileContent(&block, name)
+			if err != nil {
+				return xerrors.Errorf("error in OpenFileContent: %w", err)
+			}
+
+			codec := header.Compression
+			if codec == "" {
+				codec = CompressionNone
+			}
+
+			chunks, err := putChunks(store, in, codec)
+			in.Close()
+			if err != nil {
+				return xerrors.Errorf("error in putChunks: %w", err)
+			}
+
+			delta := fileUsage(header).neg()
+			header.setContent(chunks)
+			if err := header.Save(block.GetBlockPath()); err != nil {
+				releaseChunks(store, chunks)
+				return xerrors.Errorf("error in header.Save: %w", err)
+			}
+			block.FileMap[name] = header
+
+			delta.add(fileUsage(header))
+			d := deltas[id]
+			d.add(delta)
+			deltas[id] = d
+			legacies = append(legacies, legacy)
+		}
+
+		if len(legacies) == 0 {
+			continue
+		}
+
+		if err := cs.writeBlocks(&block); err != nil {
+			return xerrors.Errorf("err in writeBlocks: %w", err)
+		}
+
+		// content beside header is dropped once block refers to its blobs
+		for _, legacy := range legacies {
+			if err := os.Remove(legacy); err != nil {
+				return xerrors.Errorf("error in os.Remove: %w", err)
+			}
+		}
+	}
+
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	for id, delta := range deltas {
+		if err := cs.adjustUsage(id, delta); err != nil {
+			return xerrors.Errorf("err in adjustUsage: %w", err)
+		}
+	}
+
+	if err := cs.currentUser.Save(); err != nil {
+		return xerrors.Errorf("err in currentUser.Save: %w", err)
+	}
+
+	return nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+
+	return len(p), nil
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// WriteFileAt: write r into file of current folder at offset, a resumed
+// upload write the rest at the size of what arrived
+func (cs *commandService) WriteFileAt(fileName string, offset int64, r io.Reader) error {
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if cs.currentBlock == nil {
+		return NewError(ErrNoUser, "block is nil")
+	}
+
+	old := fileUsage(cs.currentBlock.FileMap[fileName])
+	header, err := WriteFileContentAt(cs.currentBlock, fileName, offset, r, cs.compressionOf(cs.currentBlock))
+	if err != nil {
+		return xerrors.Errorf("err in WriteFileContentAt: %w", err)
+	}
+
+	cs.currentUser.BlockMap[cs.currentBlock.NodeID] = *cs.currentBlock
+	delta := fileUsage(header)
+	delta.add(old.neg())
+	if err := cs.adjustUsage(cs.currentBlock.NodeID, delta); err != nil {
+		return xerrors.Errorf("err in adjustUsage: %w", err)
+	}
+
+	if err := cs.currentUser.Save(); err != nil {
+		return xerrors.Errorf("err in currentUser.Save: %w", err)
+	}
+
+	if err := cs.reindex(cs.currentBlock, fileName); err != nil {
+		return xerrors.Errorf("err in reindex: %w", err)
+	}
+
+	return nil
+}
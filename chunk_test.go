@@ -0,0 +1,343 @@
+package vfsgo
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func randomData(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func readFileBytes(t *testing.T, cmdService ICommandService, name string) []byte {
+	r, err := cmdService.ReadFile(name)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer r.Close()
+
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return buf
+}
+
+// readConcurrently: read r at random offsets from several goroutines and
+// compare with data
+func readConcurrently(t *testing.T, r io.ReaderAt, data []byte) {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+
+			rnd := rand.New(rand.NewSource(seed))
+			buf := make([]byte, 4096)
+			for i := 0; i < 200; i++ {
+				off := rnd.Int63n(int64(len(data) - len(buf)))
+				if _, err := r.ReadAt(buf, off); err != nil {
+					errs <- err
+					return
+				}
+
+				if !bytes.Equal(buf, data[off:off+int64(len(buf))]) {
+					errs <- fmt.Errorf("unexpected content read at %d", off)
+					return
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestSplitChunks(t *testing.T) {
+	data := randomData(1, 4<<20)
+
+	var sizes []int
+	err := SplitChunks(bytes.NewReader(data), func(chunk []byte) error {
+		sizes = append(sizes, len(chunk))
+		return nil
+	})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	total := 0
+	for i, size := range sizes {
+		total += size
+		if size > ChunkMaxSize || (size < ChunkMinSize && i != len(sizes)-1) {
+			t.Errorf("unexpected chunk size %d", size)
+			return
+		}
+	}
+
+	if total != len(data) || len(sizes) < 4 {
+		t.Errorf("unexpected chunks %v", sizes)
+		return
+	}
+
+	// an insert only moves boundaries near it
+	inserted := append(append(append([]byte(nil), data[:100]...), 'x'), data[100:]...)
+	var moved []int
+	SplitChunks(bytes.NewReader(inserted), func(chunk []byte) error {
+		moved = append(moved, len(chunk))
+		return nil
+	})
+
+	if len(moved) != len(sizes) || moved[0] != sizes[0]+1 {
+		t.Errorf("unexpected chunks after insert %v %v", sizes, moved)
+		return
+	}
+
+	for i := 1; i < len(sizes); i++ {
+		if moved[i] != sizes[i] {
+			t.Errorf("chunk %d moved by insert", i)
+			return
+		}
+	}
+}
+
+func TestChunkedFile(t *testing.T) {
+	cmdService := NewCommandService(t.TempDir())
+	data := randomData(2, 3<<20)
+
+	must(t, cmdService.Register("testChunk"))
+	must(t, cmdService.Use("testChunk"))
+	must(t, cmdService.CreateFile("big", ""))
+	must(t, cmdService.WriteFile("big", bytes.NewReader(data)))
+
+	header := cmdService.GetCurrentBlock().FileMap["big"]
+	if len(header.Chunks) < 2 || header.ContentHash != "" || header.Size != int64(len(data)) {
+		t.Errorf("unexpected header %d chunks %d", len(header.Chunks), header.Size)
+		return
+	}
+
+	if !bytes.Equal(readFileBytes(t, cmdService, "big"), data) {
+		t.Error("unexpected content of chunked file")
+		return
+	}
+
+	// read across the boundary of first and second chunk
+	r, err := cmdService.ReadFile("big")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	part := make([]byte, 100)
+	off := header.Chunks[0].Size - 50
+	_, err = r.(Content).ReadAt(part, off)
+	r.Close()
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if !bytes.Equal(part, data[off:off+100]) {
+		t.Error("unexpected content read across chunks")
+		return
+	}
+
+	// append only stores the last chunk again
+	tail := randomData(3, 1000)
+	if err := cmdService.WriteFileAt("big", header.Size, bytes.NewReader(tail)); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	appended := cmdService.GetCurrentBlock().FileMap["big"]
+	for i := 0; i < len(header.Chunks)-1; i++ {
+		if appended.Chunks[i].Hash != header.Chunks[i].Hash {
+			t.Errorf("chunk %d changed by append", i)
+			return
+		}
+	}
+
+	data = append(data, tail...)
+	if !bytes.Equal(readFileBytes(t, cmdService, "big"), data) {
+		t.Error("unexpected content after append")
+		return
+	}
+
+	// overwrite in the middle
+	copy(data[1<<20:], "overwritten")
+	if err := cmdService.WriteFileAt("big", 1<<20, bytes.NewReader([]byte("overwritten"))); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if !bytes.Equal(readFileBytes(t, cmdService, "big"), data) {
+		t.Error("unexpected content after overwrite")
+		return
+	}
+
+	// write past the end fills the gap by zero
+	if err := cmdService.WriteFileAt("big", int64(len(data))+10, bytes.NewReader([]byte("end"))); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	data = append(append(data, make([]byte, 10)...), "end"...)
+	if !bytes.Equal(readFileBytes(t, cmdService, "big"), data) {
+		t.Error("unexpected content after write past the end")
+		return
+	}
+
+	if size := cmdService.GetCurrentBlock().FileMap["big"].Size; size != int64(len(data)) {
+		t.Errorf("unexpected size %d", size)
+		return
+	}
+
+	// write at a small file
+	must(t, cmdService.CreateFile("small", ""))
+	must(t, cmdService.WriteFileAt("small", 3, bytes.NewReader([]byte("abc"))))
+	must(t, cmdService.WriteFileAt("small", 0, bytes.NewReader([]byte("x"))))
+
+	if got := readFileBytes(t, cmdService, "small"); !bytes.Equal(got, []byte("x\x00\x00abc")) {
+		t.Errorf("unexpected content %q", got)
+		return
+	}
+}
+
+func TestChunkDedup(t *testing.T) {
+	cmdService := NewCommandService(t.TempDir())
+	shared := randomData(4, 2<<20)
+
+	must(t, cmdService.Register("testDedup"))
+	must(t, cmdService.Use("testDedup"))
+	must(t, cmdService.CreateFile("one", ""))
+	must(t, cmdService.WriteFile("one", bytes.NewReader(shared)))
+	must(t, cmdService.CreateFile("two", ""))
+	must(t, cmdService.WriteFile("two", bytes.NewReader(append(append([]byte(nil), shared...), randomData(5, 1<<20)...))))
+
+	block := cmdService.GetCurrentBlock()
+	one := block.FileMap["one"]
+
+	// every chunk but the last of one is shared by two
+	for _, c := range one.Chunks[:len(one.Chunks)-1] {
+		refs, err := block.blobStore().Refs(c.Hash)
+		if err != nil {
+			t.Error(err.Error())
+			return
+		}
+
+		if refs != 2 {
+			t.Errorf("unexpected refs %d of shared chunk", refs)
+			return
+		}
+	}
+
+	if err := cmdService.DeleteFile("one"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if refs, _ := block.blobStore().Refs(one.Chunks[0].Hash); refs != 1 {
+		t.Errorf("unexpected refs %d after delete", refs)
+		return
+	}
+}
+
+// blobFiles: blobs of store under root, refs and locks are not counted
+func blobFiles(t *testing.T, root string) int {
+	n := 0
+	err := filepath.Walk(filepath.Join(root, BlobDirName), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || strings.HasSuffix(path, BlobRefSuffix) || info.Name() == LockFileName {
+			return err
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return n
+}
+
+func TestWriteAtReleasesOnError(t *testing.T) {
+	root := t.TempDir()
+	cmdService := NewCommandService(root)
+	if err := cmdService.Register("testRelease"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.Use("testRelease"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.CreateFile("big", ""); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.WriteFile("big", bytes.NewReader(randomData(4, 3<<20))); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// last chunk lost, it can not be kept by a write at the head
+	header := cmdService.GetCurrentBlock().FileMap["big"]
+	last := header.Chunks[len(header.Chunks)-1].Hash
+	if err := os.Remove(NewBlobStore(root).Path(last)); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	before := blobFiles(t, root)
+
+	if err := cmdService.WriteFileAt("big", 0, strings.NewReader("head")); err == nil {
+		t.Error("write keeping a lost chunk should fail")
+		return
+	}
+
+	if got := blobFiles(t, root); got != before {
+		t.Errorf("failed write left %d blobs, want %d", got, before)
+		return
+	}
+
+	if got := cmdService.GetCurrentBlock().FileMap["big"]; got.Chunks[0].Hash != header.Chunks[0].Hash {
+		t.Error("failed write changed file")
+		return
+	}
+}
+
+func TestManifestConcurrentRead(t *testing.T) {
+	cmdService := NewCommandService(t.TempDir())
+	data := randomData(5, 5<<20)
+
+	must(t, cmdService.Register("testConcurrent"))
+	must(t, cmdService.Use("testConcurrent"))
+	must(t, cmdService.CreateFile("big", ""))
+	must(t, cmdService.WriteFile("big", bytes.NewReader(data)))
+
+	r, err := cmdService.ReadFile("big")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer r.Close()
+
+	readConcurrently(t, r.(Content), data)
+}
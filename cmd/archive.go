@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lemotw/vfsgo"
+)
+
+const (
+	exportArchiveUsage = "Usage: export-archive [-f tar|tar.gz|zip] [folder] hostfile"
+	importArchiveUsage = "Usage: import-archive [-f tar|tar.gz|zip] [-x pattern]... hostfile [folder]"
+)
+
+// archiveFormat: format given by -f, else by extension of host file
+func archiveFormat(format, host string) string {
+	if format != "" {
+		return format
+	}
+
+	switch {
+	case strings.HasSuffix(host, ".tar.gz"), strings.HasSuffix(host, ".tgz"):
+		return vfsgo.ArchiveTarGz
+	case strings.HasSuffix(host, ".zip"):
+		return vfsgo.ArchiveZip
+	}
+
+	return vfsgo.ArchiveTar
+}
+
+// exportArchiveCmd: write folder into host file as archive
+func exportArchiveCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	format := ""
+	if len(args) >= 2 && args[0] == "-f" {
+		format = args[1]
+		args = args[2:]
+	}
+
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintln(stderr, exportArchiveUsage)
+		return
+	}
+
+	dir, host := "", args[len(args)-1]
+	if len(args) == 2 {
+		dir = args[0]
+	}
+	format = archiveFormat(format, host)
+
+	file, err := os.Create(host)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error: "+err.Error())
+		return
+	}
+
+	err = serv.ExportArchive(dir, format, file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(host)
+		if errors.Is(err, vfsgo.ErrInvalidName) {
+			fmt.Fprintln(stderr, exportArchiveUsage)
+			return
+		}
+		fmt.Fprintln(stderr, errorMessage(err, dir))
+		return
+	}
+
+	fmt.Fprintf(stdout, "Export [%s] as %s successfully.\n", host, format)
+}
+
+// importArchiveCmd: read archive in host file into folder, rejected
+// entries are warned at the end
+func importArchiveCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	format, opts := "", vfsgo.ImportOptions{}
+	for len(args) >= 2 && (args[0] == "-f" || args[0] == "-x") {
+		if args[0] == "-f" {
+			format = args[1]
+		} else {
+			opts.Exclude = append(opts.Exclude, args[1])
+		}
+		args = args[2:]
+	}
+
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintln(stderr, importArchiveUsage)
+		return
+	}
+
+	host, dir := args[0], ""
+	if len(args) == 2 {
+		dir = args[1]
+	}
+
+	file, err := os.Open(host)
+	if err != nil {
+		fmt.Fprintln(stderr, errorMessage(vfsgo.ErrNotExist, host))
+		return
+	}
+	defer file.Close()
+
+	report, err := serv.ImportArchive(file, dir, archiveFormat(format, host), opts)
+	if errors.Is(err, vfsgo.ErrInvalidName) {
+		fmt.Fprintln(stderr, importArchiveUsage)
+		return
+	}
+
+	if err != nil {
+		fmt.Fprintln(stderr, errorMessage(err, host))
+		return
+	}
+
+	for _, p := range report.Rejected {
+		fmt.Fprintf(stderr, "Warning: The [%s] is not imported.\n", p)
+	}
+
+	fmt.Fprintf(stdout, "Import [%s] successfully: %d folders, %d files, %d bytes, %d unchanged, %d excluded.\n",
+		host, report.Dirs, report.Files, report.Bytes, report.Unchanged, report.Excluded)
+}
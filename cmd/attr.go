@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/lemotw/vfsgo"
+)
+
+const (
+	tagUsage     = "Usage: tag path [tag]..."
+	untagUsage   = "Usage: untag path tag..."
+	setattrUsage = "Usage: setattr path key [value]"
+	getattrUsage = "Usage: getattr path [key]"
+)
+
+// tagCmd: add tags to entry, print tags of entry if no tag given
+func tagCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	if len(args) < 1 {
+		fmt.Fprintln(stderr, tagUsage)
+		return
+	}
+
+	if len(args) > 1 {
+		if err := serv.Tag(args[0], args[1:]...); err != nil {
+			fmt.Fprintln(stderr, errorMessage(err, args[0]))
+			return
+		}
+	}
+
+	header, err := serv.Stat(args[0])
+	if err != nil {
+		fmt.Fprintln(stderr, errorMessage(err, args[0]))
+		return
+	}
+
+	fmt.Fprintln(stdout, strings.Join(header.Tags, " "))
+}
+
+func untagCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	if len(args) < 2 {
+		fmt.Fprintln(stderr, untagUsage)
+		return
+	}
+
+	if err := serv.Untag(args[0], args[1:]...); err != nil {
+		fmt.Fprintln(stderr, errorMessage(err, args[0]))
+		return
+	}
+
+	fmt.Fprintf(stdout, "Untag [%s] successfully.\n", args[0])
+}
+
+// setattrCmd: set attribute of entry, remove it if no value given
+func setattrCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	if len(args) != 2 && len(args) != 3 {
+		fmt.Fprintln(stderr, setattrUsage)
+		return
+	}
+
+	value := ""
+	if len(args) == 3 {
+		value = args[2]
+	}
+
+	if err := serv.SetAttr(args[0], args[1], value); err != nil {
+		fmt.Fprintln(stderr, errorMessage(err, args[0]))
+		return
+	}
+
+	fmt.Fprintf(stdout, "Set [%s] of [%s] successfully.\n", args[1], args[0])
+}
+
+// getattrCmd: value of attribute, or every key=value of entry if no key given
+func getattrCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	switch len(args) {
+	case 1:
+		header, err := serv.Stat(args[0])
+		if err != nil {
+			fmt.Fprintln(stderr, errorMessage(err, args[0]))
+			return
+		}
+
+		keys := make([]string, 0, len(header.Attrs))
+		for key := range header.Attrs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fmt.Fprintf(stdout, "%s=%s\n", key, header.Attrs[key])
+		}
+	case 2:
+		value, err := serv.GetAttr(args[0], args[1])
+		if err != nil {
+			fmt.Fprintln(stderr, errorMessage(err, args[1]))
+			return
+		}
+
+		fmt.Fprintln(stdout, value)
+	default:
+		fmt.Fprintln(stderr, getattrUsage)
+	}
+}
+
+// parseAttrFilter: key=value of -attr flag, key alone match any value
+func parseAttrFilter(s string, filter *vfsgo.Filter) bool {
+	key, value, _ := strings.Cut(s, "=")
+	if key == "" {
+		return false
+	}
+
+	if filter.Attrs == nil {
+		filter.Attrs = make(map[string]string)
+	}
+	filter.Attrs[key] = value
+
+	return true
+}
+
+// parseTagFilter: take --tag and --attr flags out of ls arguments
+func parseTagFilter(args []string) ([]string, vfsgo.Filter, bool) {
+	rest := make([]string, 0, len(args))
+	filter := vfsgo.Filter{}
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--tag", "--attr":
+			if i+1 >= len(args) {
+				return nil, filter, false
+			}
+
+			if args[i] == "--tag" {
+				filter.Tags = append(filter.Tags, args[i+1])
+			} else if !parseAttrFilter(args[i+1], &filter) {
+				return nil, filter, false
+			}
+			i++
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+
+	return rest, filter, true
+}
+
+// listFiltered: every page of filtered entries in folder
+func listFiltered(serv vfsgo.ICommandService, dir string, q vfsgo.ListQuery) ([]vfsgo.FileHeader, error) {
+	files := []vfsgo.FileHeader{}
+	for {
+		page, err := serv.ListPage(dir, q)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, page.Entries...)
+		if page.NextCursor == "" {
+			return files, nil
+		}
+		q.Cursor = page.NextCursor
+	}
+}
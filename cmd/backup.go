@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lemotw/vfsgo"
+)
+
+const (
+	backupUsage  = "Usage: backup [-f tar|tar.gz] hostfile"
+	restoreUsage = "Usage: restore [-f tar|tar.gz] [-u user]... hostfile"
+)
+
+// backupCmd: write every user under root into host file
+func backupCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	format := ""
+	if len(args) >= 2 && args[0] == "-f" {
+		format = args[1]
+		args = args[2:]
+	}
+
+	if len(args) != 1 {
+		fmt.Fprintln(stderr, backupUsage)
+		return
+	}
+
+	host := args[0]
+	format = archiveFormat(format, host)
+
+	file, err := os.Create(host)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error: "+err.Error())
+		return
+	}
+
+	err = serv.Backup(file, format)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(host)
+		if errors.Is(err, vfsgo.ErrInvalidName) {
+			fmt.Fprintln(stderr, backupUsage)
+			return
+		}
+		fmt.Fprintln(stderr, errorMessage(err, host))
+		return
+	}
+
+	fmt.Fprintf(stdout, "Backup [%s] as %s successfully.\n", host, format)
+}
+
+// restoreCmd: put users of backup in host file under root, the user
+// already existing or missing is named by the error
+func restoreCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	format, users := "", []string{}
+	for len(args) >= 2 && (args[0] == "-f" || args[0] == "-u") {
+		if args[0] == "-f" {
+			format = args[1]
+		} else {
+			users = append(users, args[1])
+		}
+		args = args[2:]
+	}
+
+	if len(args) != 1 {
+		fmt.Fprintln(stderr, restoreUsage)
+		return
+	}
+
+	host := args[0]
+	file, err := os.Open(host)
+	if err != nil {
+		fmt.Fprintln(stderr, errorMessage(vfsgo.ErrNotExist, host))
+		return
+	}
+	defer file.Close()
+
+	report, err := serv.Restore(file, archiveFormat(format, host), users)
+	switch {
+	case errors.Is(err, vfsgo.ErrInvalidName):
+		fmt.Fprintln(stderr, restoreUsage)
+		return
+	case errors.Is(err, vfsgo.ErrExist), errors.Is(err, vfsgo.ErrNotExist):
+		status = exitCode(err)
+		fmt.Fprintln(stderr, "Error: "+err.Error())
+		return
+	case err != nil:
+		fmt.Fprintln(stderr, errorMessage(err, host))
+		return
+	}
+
+	fmt.Fprintf(stdout, "Restore [%s] successfully: users %s, %d files, %d bytes, %d blobs.\n",
+		host, strings.Join(report.Users, ", "), report.Files, report.Bytes, report.Blobs)
+}
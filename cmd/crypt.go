@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/lemotw/vfsgo"
+)
+
+const (
+	encryptUsage   = "Usage: encrypt [--names] passphrase"
+	unlockUsage    = "Usage: unlock username passphrase"
+	lockUsage      = "Usage: lock username"
+	rotateKeyUsage = "Usage: rotate-key passphrase new-passphrase"
+)
+
+// encryptCmd: encrypt everything of current user by passphrase, or only
+// names and descriptions with --names
+func encryptCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	mode := vfsgo.EncryptFull
+	if len(args) == 2 && args[0] == "--names" {
+		mode = vfsgo.EncryptNames
+		args = args[1:]
+	}
+
+	if len(args) != 1 {
+		fmt.Fprintln(stderr, encryptUsage)
+		return
+	}
+
+	if err := serv.Encrypt(args[0], mode); err != nil {
+		fmt.Fprintln(stderr, errorMessage(err, "user"))
+		return
+	}
+
+	fmt.Fprintln(stdout, "User is encrypted.")
+}
+
+// unlockCmd: open encrypted user for use until lock
+func unlockCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	if len(args) != 2 {
+		fmt.Fprintln(stderr, unlockUsage)
+		return
+	}
+
+	if err := serv.Unlock(args[0], args[1]); err != nil {
+		fmt.Fprintln(stderr, errorMessage(err, args[0]))
+		return
+	}
+
+	fmt.Fprintf(stdout, "Unlock [%s] successfully.\n", args[0])
+}
+
+// lockCmd: forget key of user, it can not be used until unlock
+func lockCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	if len(args) != 1 {
+		fmt.Fprintln(stderr, lockUsage)
+		return
+	}
+
+	if err := serv.Lock(args[0]); err != nil {
+		fmt.Fprintln(stderr, errorMessage(err, args[0]))
+		return
+	}
+
+	fmt.Fprintf(stdout, "Lock [%s] successfully.\n", args[0])
+}
+
+// rotateKeyCmd: reencrypt current user by a new key
+func rotateKeyCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	if len(args) != 2 {
+		fmt.Fprintln(stderr, rotateKeyUsage)
+		return
+	}
+
+	if err := serv.RotateKey(args[0], args[1]); err != nil {
+		fmt.Fprintln(stderr, errorMessage(err, "user"))
+		return
+	}
+
+	fmt.Fprintln(stdout, "Key is rotated.")
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/lemotw/vfsgo/remote"
+)
+
+// runDaemon: serve commands of thin cli on unix socket until interrupted
+func runDaemon(root, socket string) error {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+
+	// socket file left by a daemon which is not running any more
+	if _, err := os.Stat(socket); err == nil {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			return errors.New("daemon already running on " + socket)
+		}
+		os.Remove(socket)
+	}
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		l.Close()
+	}()
+
+	log.Printf("daemon serving on %s", socket)
+	err = remote.NewServer(root).Serve(l)
+	os.Remove(socket)
+
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// forward: send one command to daemon, session keep current user and folder
+// between invocations. Exit status of the command is returned
+func forward(socket, session, command string) (int, error) {
+	client, err := remote.DialSession("unix", socket, session)
+	if err != nil {
+		return exitFailure, err
+	}
+	defer client.Close()
+
+	_, code := sendCMD(client, command)
+
+	return code, nil
+}
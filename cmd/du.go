@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/lemotw/vfsgo"
+)
+
+const (
+	duUsage       = "Usage: du [path] [-d depth] [-s] [-h]"
+	duCacheUsage  = "Usage: du-cache on|off"
+	compressUsage = "Usage: compress [folder] gzip|none|inherit"
+)
+
+type duOptions struct {
+	depth int
+	human bool
+}
+
+func parseDu(args []string) (string, duOptions, bool) {
+	root := "."
+	opts := duOptions{depth: -1}
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		root = args[0]
+		args = args[1:]
+	}
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-d":
+			if i+1 >= len(args) {
+				return "", opts, false
+			}
+
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 0 {
+				return "", opts, false
+			}
+			opts.depth = n
+			i++
+		case "-s":
+			opts.depth = 0
+		case "-h":
+			opts.human = true
+		default:
+			return "", opts, false
+		}
+	}
+
+	return root, opts, true
+}
+
+// duCmd: usage of every folder under path down to depth, folders before
+// the folder containing them like du
+func duCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	root, opts, ok := parseDu(args)
+	if !ok {
+		fmt.Fprintln(stderr, duUsage)
+		return
+	}
+
+	du, err := serv.Usage(root, opts.depth)
+	if err != nil {
+		fmt.Fprintln(stderr, errorMessage(err, root))
+		return
+	}
+
+	printUsage(stdout, &du, opts)
+}
+
+func printUsage(w io.Writer, du *vfsgo.DiskUsage, opts duOptions) {
+	for i := range du.Children {
+		printUsage(w, &du.Children[i], opts)
+	}
+
+	size, stored := strconv.FormatInt(du.Bytes, 10), strconv.FormatInt(du.Stored, 10)
+	if opts.human {
+		size, stored = humanSize(du.Bytes), humanSize(du.Stored)
+	}
+
+	fmt.Fprintf(w, "%s\t%s stored\t%d files\t%d dirs\t%s\n", size, stored, du.Files, du.Dirs, du.Path)
+}
+
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%c", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// duCacheCmd: switch cached aggregate usage of current user
+func duCacheCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		fmt.Fprintln(stderr, duCacheUsage)
+		return
+	}
+
+	if err := serv.SetUsageCache(args[0] == "on"); err != nil {
+		fmt.Fprintln(stderr, errorMessage(err, args[0]))
+		return
+	}
+
+	fmt.Fprintf(stdout, "Usage cache is %s.\n", args[0])
+}
+
+// compressCmd: codec of content written from now on under folder, without
+// folder the default of current user
+func compressCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintln(stderr, compressUsage)
+		return
+	}
+
+	dir, codec := "", args[len(args)-1]
+	if len(args) == 2 {
+		dir = args[0]
+	}
+
+	name := codec
+	if codec == "inherit" {
+		codec = ""
+	}
+
+	if err := serv.SetCompression(dir, codec); err != nil {
+		fmt.Fprintln(stderr, errorMessage(err, name))
+		return
+	}
+
+	if dir == "" {
+		dir = "user default"
+	}
+	fmt.Fprintf(stdout, "Compression of %s is %s.\n", dir, name)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lemotw/vfsgo"
+)
+
+// exit status of command by class of its error
+const (
+	exitOK         = 0
+	exitFailure    = 1
+	exitInvalid    = 2
+	exitNotExist   = 3
+	exitExist      = 4
+	exitPermission = 5
+	exitCorrupt    = 6
+)
+
+// status: exit status of the command running, set by every error reported
+var status = exitOK
+
+// statusWriter: stderr of command, anything but a warning written to it
+// fails the command, a usage as invalid
+type statusWriter struct {
+	w io.Writer
+}
+
+func (s *statusWriter) Write(p []byte) (int, error) {
+	switch {
+	case status != exitOK, bytes.HasPrefix(p, []byte("Warning:")):
+	case bytes.HasPrefix(p, []byte("Usage")):
+		status = exitInvalid
+	default:
+		status = exitFailure
+	}
+
+	return s.w.Write(p)
+}
+
+// exitCode: exit status of command failed by err
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, vfsgo.ErrNotExist):
+		return exitNotExist
+	case errors.Is(err, vfsgo.ErrExist):
+		return exitExist
+	case errors.Is(err, vfsgo.ErrCorrupt):
+		return exitCorrupt
+	case errors.Is(err, vfsgo.ErrNoUser), errors.Is(err, vfsgo.ErrPermission),
+		errors.Is(err, vfsgo.ErrLocked), errors.Is(err, vfsgo.ErrWrongKey):
+		return exitPermission
+	case errors.Is(err, vfsgo.ErrNotDir), errors.Is(err, vfsgo.ErrIsDir), errors.Is(err, vfsgo.ErrInvalidName):
+		return exitInvalid
+	}
+
+	return exitFailure
+}
+
+// errorMessage: message of command error in the form of doc/command.md, name
+// is the user, folder or file the command work on
+func errorMessage(err error, name string) string {
+	status = exitCode(err)
+
+	switch {
+	case errors.Is(err, vfsgo.ErrNoUser):
+		return "Error: You have to choose a user first."
+	case errors.Is(err, vfsgo.ErrExist):
+		return fmt.Sprintf("Error: The [%s] has already existed.", name)
+	case errors.Is(err, vfsgo.ErrNotExist):
+		return fmt.Sprintf("Error: The [%s] doesn't exist.", name)
+	case errors.Is(err, vfsgo.ErrNotDir):
+		return fmt.Sprintf("Error: The [%s] is not a directory.", name)
+	case errors.Is(err, vfsgo.ErrIsDir):
+		return fmt.Sprintf("Error: The [%s] is a directory.", name)
+	case errors.Is(err, vfsgo.ErrInvalidName):
+		return fmt.Sprintf("Error: The [%s] contain invalid chars.", name)
+	case errors.Is(err, vfsgo.ErrCorrupt):
+		return fmt.Sprintf("Error: The [%s] is corrupted.", name)
+	case errors.Is(err, vfsgo.ErrLocked):
+		return fmt.Sprintf("Error: The [%s] is locked, unlock it first.", name)
+	case errors.Is(err, vfsgo.ErrWrongKey):
+		return fmt.Sprintf("Error: Wrong passphrase for [%s].", name)
+	case errors.Is(err, vfsgo.ErrPermission):
+		return fmt.Sprintf("Error: Permission denied on [%s].", name)
+	}
+
+	return "Error: " + err.Error()
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lemotw/vfsgo"
+)
+
+const (
+	findUsage = "Usage: find [path] [-name glob] [-regex regex] [-type f|d] [-size [+|-]bytes] [-desc text] [-tag tag] [-attr key[=value]] " +
+		"[-created-after|-created-before|-modified-after|-modified-before date] [-mindepth n] [-maxdepth n] [-delete|-move folder]"
+)
+
+// timeLayouts: accepted date of find, command is split on space so there is
+// no space in layout
+var timeLayouts = []string{"2006-01-02", "2006-01-02T15:04:05", time.RFC3339}
+
+type findAction struct {
+	delete bool
+	moveTo *string
+}
+
+func parseTime(s string) (time.Time, bool) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// parseSize: N is exact size, +N is larger than N and -N is smaller than N
+func parseSize(s string, q *vfsgo.FindQuery) bool {
+	n, err := strconv.ParseInt(strings.TrimLeft(s, "+-"), 10, 64)
+	if err != nil || n < 0 {
+		return false
+	}
+
+	switch s[0] {
+	case '+':
+		n++
+		q.MinSize = &n
+	case '-':
+		n--
+		q.MaxSize = &n
+	default:
+		q.MinSize, q.MaxSize = &n, &n
+	}
+
+	return true
+}
+
+// parseFind: [path] then predicates and action, path is current folder if
+// not given
+func parseFind(args []string) (string, vfsgo.FindQuery, findAction, bool) {
+	root := "."
+	q := vfsgo.FindQuery{}
+	action := findAction{}
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		root = args[0]
+		args = args[1:]
+	}
+
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-delete" {
+			action.delete = true
+			continue
+		}
+
+		if i+1 >= len(args) {
+			return "", q, action, false
+		}
+		flag, value := args[i], args[i+1]
+		i++
+
+		switch flag {
+		case "-name":
+			q.NameGlob = value
+		case "-regex":
+			q.NameRegex = value
+		case "-desc":
+			q.DescriptionContains = value
+		case "-type":
+			var fileType vfsgo.FileType
+			switch value {
+			case "f":
+				fileType = vfsgo.File
+			case "d":
+				fileType = vfsgo.Directory
+			default:
+				return "", q, action, false
+			}
+			q.Type = &fileType
+		case "-size":
+			if !parseSize(value, &q) {
+				return "", q, action, false
+			}
+		case "-created-after", "-created-before", "-modified-after", "-modified-before":
+			t, ok := parseTime(value)
+			if !ok {
+				return "", q, action, false
+			}
+
+			switch flag {
+			case "-created-after":
+				q.CreatedAfter = t
+			case "-created-before":
+				q.CreatedBefore = t
+			case "-modified-after":
+				q.ModifiedAfter = t
+			default:
+				q.ModifiedBefore = t
+			}
+		case "-mindepth", "-maxdepth":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return "", q, action, false
+			}
+
+			if flag == "-mindepth" {
+				q.MinDepth = n
+			} else {
+				q.MaxDepth = n
+			}
+		case "-tag":
+			q.Tags = append(q.Tags, value)
+		case "-attr":
+			if !parseAttrFilter(value, &q.Filter) {
+				return "", q, action, false
+			}
+		case "-move":
+			action.moveTo = &value
+		default:
+			return "", q, action, false
+		}
+	}
+
+	if action.delete && action.moveTo != nil {
+		return "", q, action, false
+	}
+
+	return root, q, action, true
+}
+
+// findCmd: print path of matches, or delete or move them
+func findCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	root, q, action, ok := parseFind(args)
+	if !ok {
+		fmt.Fprintln(stderr, findUsage)
+		return
+	}
+
+	matches, err := serv.Find(root, q)
+	if err != nil {
+		fmt.Fprintln(stderr, errorMessage(err, root))
+		return
+	}
+
+	switch {
+	case action.delete:
+		// deepest first, so entries are gone before their folder
+		batch(serv, printTo(stderr), func() {
+			for i := len(matches) - 1; i >= 0; i-- {
+				if err := serv.Remove(matches[i].Path); err != nil {
+					fmt.Fprintln(stderr, errorMessage(err, matches[i].Path))
+					continue
+				}
+				fmt.Fprintln(stdout, matches[i].Path)
+			}
+		})
+	case action.moveTo != nil:
+		// entries of a moved folder go with it
+		moved := []string{}
+		batch(serv, printTo(stderr), func() {
+			for _, m := range matches {
+				if underAny(m.Path, moved) {
+					continue
+				}
+
+				if err := serv.Move(m.Path, *action.moveTo); err != nil {
+					fmt.Fprintln(stderr, errorMessage(err, m.Path))
+					continue
+				}
+				moved = append(moved, m.Path)
+				fmt.Fprintln(stdout, m.Path)
+			}
+		})
+	default:
+		for _, m := range matches {
+			if m.Header.Type == vfsgo.Directory {
+				fmt.Fprintln(stdout, m.Path+"/")
+			} else {
+				fmt.Fprintln(stdout, m.Path)
+			}
+		}
+	}
+}
+
+func underAny(p string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+
+	return false
+}
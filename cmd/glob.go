@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/lemotw/vfsgo"
+)
+
+const (
+	rmUsage = "Usage: rm [--dry-run] pattern..."
+	mvUsage = "Usage: mv [--dry-run] pattern... folder"
+	cpUsage = "Usage: cp [--dry-run] pattern... folder"
+)
+
+// parseDryRun: take --dry-run out of args
+func parseDryRun(args []string) ([]string, bool) {
+	rest := make([]string, 0, len(args))
+	dryRun := false
+
+	for _, arg := range args {
+		if arg == "--dry-run" {
+			dryRun = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+
+	return rest, dryRun
+}
+
+// expand: entries matched by patterns without duplicate, entries under a
+// matched folder are dropped since they go with the folder
+func expand(serv vfsgo.ICommandService, patterns []string, stderr io.Writer) []vfsgo.Match {
+	seen := map[string]bool{}
+	matches := []vfsgo.Match{}
+
+	for _, pattern := range patterns {
+		found, err := serv.Glob(pattern)
+		if err != nil {
+			fmt.Fprintln(stderr, errorMessage(err, pattern))
+			continue
+		}
+
+		if len(found) == 0 {
+			fmt.Fprintf(stderr, "Error: The [%s] doesn't exist.\n", pattern)
+			continue
+		}
+
+		for _, m := range found {
+			if !seen[m.Path] {
+				seen[m.Path] = true
+				matches = append(matches, m)
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Path < matches[j].Path
+	})
+
+	kept := matches[:0]
+	dirs := []string{}
+	for _, m := range matches {
+		if underAny(m.Path, dirs) {
+			continue
+		}
+
+		if m.Header.Type == vfsgo.Directory {
+			dirs = append(dirs, strings.TrimRight(m.Path, "/"))
+		}
+		kept = append(kept, m)
+	}
+
+	return kept
+}
+
+// batch: run fn in a batch of serv, so index changed by every entry is
+// saved once at the end
+func batch(serv vfsgo.ICommandService, report func(msg string), fn func()) {
+	if err := serv.BeginBatch(); err != nil {
+		report(errorMessage(err, "index"))
+		return
+	}
+
+	fn()
+
+	if err := serv.EndBatch(); err != nil {
+		report(errorMessage(err, "index"))
+	}
+}
+
+func printTo(w io.Writer) func(msg string) {
+	return func(msg string) {
+		fmt.Fprintln(w, msg)
+	}
+}
+
+// rmCmd: remove every entry matched by patterns
+func rmCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	patterns, dryRun := parseDryRun(args)
+	if len(patterns) == 0 {
+		fmt.Fprintln(stderr, rmUsage)
+		return
+	}
+
+	matches := expand(serv, patterns, stderr)
+	batch(serv, printTo(stderr), func() {
+		for _, m := range matches {
+			if dryRun {
+				fmt.Fprintf(stdout, "would remove %s\n", m.Path)
+				continue
+			}
+
+			if err := serv.Remove(m.Path); err != nil {
+				fmt.Fprintln(stderr, errorMessage(err, m.Path))
+				continue
+			}
+			fmt.Fprintf(stdout, "Remove [%s] successfully.\n", m.Path)
+		}
+	})
+}
+
+// transferCmd: move or copy every entry matched by patterns into folder
+// given last
+func transferCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer, copying bool) {
+	usage, verb, fn := mvUsage, "move", serv.Move
+	if copying {
+		usage, verb, fn = cpUsage, "copy", serv.Copy
+	}
+
+	args, dryRun := parseDryRun(args)
+	if len(args) < 2 {
+		fmt.Fprintln(stderr, usage)
+		return
+	}
+	patterns, dst := args[:len(args)-1], args[len(args)-1]
+
+	if header, err := serv.Stat(dst); err != nil || header.Type != vfsgo.Directory {
+		if err == nil {
+			err = vfsgo.ErrNotDir
+		}
+		fmt.Fprintln(stderr, errorMessage(err, dst))
+		return
+	}
+
+	matches := expand(serv, patterns, stderr)
+	batch(serv, printTo(stderr), func() {
+		for _, m := range matches {
+			if dryRun {
+				fmt.Fprintf(stdout, "would %s %s -> %s\n", verb, m.Path, dst)
+				continue
+			}
+
+			if err := fn(m.Path, dst); err != nil {
+				fmt.Fprintln(stderr, errorMessage(err, m.Path))
+				continue
+			}
+			fmt.Fprintf(stdout, "%s%s [%s] to [%s] successfully.\n", strings.ToUpper(verb[:1]), verb[1:], m.Path, dst)
+		}
+	})
+}
+
+// deleteGlob: delete-file and delete-folder with pattern, only entries of
+// fileType are deleted
+func deleteGlob(serv vfsgo.ICommandService, pattern string, fileType vfsgo.FileType) {
+	matches, err := serv.Glob(pattern)
+	if err != nil {
+		log.Println(errorMessage(err, pattern))
+		return
+	}
+
+	deleted := 0
+	batch(serv, func(msg string) { log.Println(msg) }, func() {
+		for _, m := range matches {
+			if m.Header.Type != fileType {
+				continue
+			}
+
+			if err := serv.Remove(m.Path); err != nil {
+				log.Println(errorMessage(err, m.Path))
+				continue
+			}
+			deleted++
+			log.Println(fmt.Sprintf("Delete [%s] successfully.", m.Path))
+		}
+	})
+
+	if deleted == 0 {
+		log.Println(errorMessage(vfsgo.ErrNotExist, pattern))
+	}
+}
+
+// lsGlob: ls with pattern, path of matches are printed
+func lsGlob(serv vfsgo.ICommandService, pattern string, q vfsgo.ListQuery) {
+	match, err := q.Compile()
+	if err != nil {
+		log.Println(errorMessage(err, pattern))
+		return
+	}
+
+	matches, err := serv.Glob(pattern)
+	if err != nil {
+		log.Println(errorMessage(err, pattern))
+		return
+	}
+
+	kept := matches[:0]
+	for _, m := range matches {
+		if match(m.Header) {
+			kept = append(kept, m)
+		}
+	}
+	vfsgo.SortMatches(kept, q.Keys, q.DirsFirst)
+
+	log.Println("files: ")
+	for _, m := range kept {
+		if m.Header.Type == vfsgo.Directory {
+			log.Println(m.Path + "/")
+		} else {
+			log.Println(m.Path)
+		}
+	}
+}
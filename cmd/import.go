@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/lemotw/vfsgo"
+)
+
+const (
+	importUsage = "Usage: import [-x pattern]... hostdir [folder]"
+	exportUsage = "Usage: export [-p conflict|overwrite|skip] [folder] hostdir"
+)
+
+// importCmd: copy host dir into folder, progress is printed at most once a
+// second and rejected entries are warned at the end
+func importCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	opts := vfsgo.ImportOptions{}
+	for len(args) >= 2 && args[0] == "-x" {
+		opts.Exclude = append(opts.Exclude, args[1])
+		args = args[2:]
+	}
+
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintln(stderr, importUsage)
+		return
+	}
+
+	host, dir := args[0], ""
+	if len(args) == 2 {
+		dir = args[1]
+	}
+
+	last := time.Now()
+	opts.Progress = func(r vfsgo.ImportReport) {
+		if time.Since(last) < time.Second {
+			return
+		}
+		last = time.Now()
+		fmt.Fprintf(stdout, "%d files\t%d bytes\t%s\n", r.Files, r.Bytes, r.Path)
+	}
+
+	report, err := serv.Import(host, dir, opts)
+	if err != nil {
+		fmt.Fprintln(stderr, errorMessage(err, host))
+		return
+	}
+
+	for _, p := range report.Rejected {
+		fmt.Fprintf(stderr, "Warning: The [%s] is not imported.\n", p)
+	}
+
+	fmt.Fprintf(stdout, "Import [%s] successfully: %d folders, %d files, %d bytes, %d unchanged, %d excluded.\n",
+		host, report.Dirs, report.Files, report.Bytes, report.Unchanged, report.Excluded)
+}
+
+// exportCmd: write folder into host dir, progress is printed at most once a
+// second and conflicts are warned at the end
+func exportCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	opts := vfsgo.ExportOptions{}
+	if len(args) >= 2 && args[0] == "-p" {
+		opts.Policy = args[1]
+		args = args[2:]
+	}
+
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintln(stderr, exportUsage)
+		return
+	}
+
+	dir, host := "", args[len(args)-1]
+	if len(args) == 2 {
+		dir = args[0]
+	}
+
+	last := time.Now()
+	opts.Progress = func(r vfsgo.ExportReport) {
+		if time.Since(last) < time.Second {
+			return
+		}
+		last = time.Now()
+		fmt.Fprintf(stdout, "%d files\t%d bytes\t%s\n", r.Files, r.Bytes, r.Path)
+	}
+
+	report, err := serv.Export(dir, host, opts)
+	for _, p := range report.Conflicts {
+		fmt.Fprintf(stderr, "Warning: The [%s] has already existed on host.\n", p)
+	}
+
+	if errors.Is(err, vfsgo.ErrInvalidName) {
+		fmt.Fprintln(stderr, exportUsage)
+		return
+	}
+
+	if err != nil {
+		name := dir
+		if errors.Is(err, vfsgo.ErrExist) || errors.Is(err, vfsgo.ErrNotDir) {
+			name = host
+		}
+		fmt.Fprintln(stderr, errorMessage(err, name))
+		return
+	}
+
+	fmt.Fprintf(stdout, "Export [%s] successfully: %d folders, %d files, %d bytes, %d skipped.\n",
+		host, report.Dirs, report.Files, report.Bytes, report.Skipped)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/lemotw/vfsgo"
+)
+
+const (
+	lsUsage          = "ls command format: ls path [--sort-name|--sort-created|--sort-modified|--sort-size|--sort-type|--sort-desc asc|desc]... [--dirs-first] [--tag tag]... [--attr key[=value]]..."
+	listFoldersUsage = "Usage: list-folders [--sort-name|--sort-created|--sort-modified|--sort-size|--sort-desc] [asc|desc]"
+	listFilesUsage   = "Usage: list-files [foldername] [--sort-name|--sort-created|--sort-modified|--sort-size|--sort-desc] [asc|desc]"
+
+	createdAtLayout = "2006-01-02 15:04:05"
+)
+
+var sortFlags = map[string]vfsgo.SortType{
+	"--sort-name":     vfsgo.SortByName,
+	"--sort-created":  vfsgo.SortByCreatedTime,
+	"--sort-modified": vfsgo.SortByModifiedTime,
+	"--sort-size":     vfsgo.SortBySize,
+	"--sort-type":     vfsgo.SortByType,
+	"--sort-desc":     vfsgo.SortByDescription,
+}
+
+var sortOrders = map[string]string{
+	"asc":  vfsgo.ASC,
+	"desc": vfsgo.DESC,
+}
+
+// parseSort: [--sort-xxx] [asc|desc], name ascending if no flag, ok is false
+// on invalid flag
+func parseSort(args []string) (*vfsgo.SortType, *string, bool) {
+	field, order := vfsgo.SortByName, vfsgo.ASC
+
+	if len(args) > 2 {
+		return nil, nil, false
+	}
+
+	if len(args) >= 1 {
+		f, ok := sortFlags[args[0]]
+		if !ok {
+			return nil, nil, false
+		}
+		field = f
+	}
+
+	if len(args) == 2 {
+		o, ok := sortOrders[args[1]]
+		if !ok {
+			return nil, nil, false
+		}
+		order = o
+	}
+
+	return &field, &order, true
+}
+
+// parseSortKeys: ([--sort-xxx asc|desc]... [--dirs-first]) in any order,
+// every sort flag is one more key
+func parseSortKeys(args []string) ([]vfsgo.SortKey, bool, bool) {
+	keys := make([]vfsgo.SortKey, 0, len(args)/2)
+	dirsFirst := false
+
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--dirs-first" {
+			dirsFirst = true
+			continue
+		}
+
+		field, ok := sortFlags[args[i]]
+		if !ok || i+1 >= len(args) {
+			return nil, false, false
+		}
+
+		order, ok := sortOrders[args[i+1]]
+		if !ok {
+			return nil, false, false
+		}
+		i++
+
+		keys = append(keys, vfsgo.SortKey{Field: field, Order: order})
+	}
+
+	return keys, dirsFirst, true
+}
+
+// listFolders: folders at current scope, [foldername] [description] [created at] [username]
+func listFolders(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	sortField, sortOrder, ok := parseSort(args)
+	if !ok {
+		fmt.Fprintln(stderr, listFoldersUsage)
+		return
+	}
+
+	fileType := vfsgo.Directory
+	folders, err := serv.ListEntries(".", &fileType, sortField, sortOrder)
+	if err != nil {
+		fmt.Fprintln(stderr, errorMessage(err, "."))
+		return
+	}
+
+	user := serv.GetCurrentUser()
+	if user == nil {
+		fmt.Fprintln(stderr, errorMessage(vfsgo.ErrNoUser, "."))
+		return
+	}
+
+	username := user.Name
+	if len(folders) == 0 {
+		fmt.Fprintf(stderr, "Warning: The [%s] doesn't have any folders.\n", username)
+		return
+	}
+
+	for _, folder := range folders {
+		fmt.Fprintf(stdout, "%s\t%s\t%s\t%s\n", folder.Name, folder.Description, folder.CreatedTime.Format(createdAtLayout), username)
+	}
+}
+
+// listFiles: files in folder, [filename] [description] [created at] [username/foldername]
+func listFiles(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "--") {
+		fmt.Fprintln(stderr, listFilesUsage)
+		return
+	}
+	folderName := args[0]
+
+	sortField, sortOrder, ok := parseSort(args[1:])
+	if !ok {
+		fmt.Fprintln(stderr, listFilesUsage)
+		return
+	}
+
+	fileType := vfsgo.File
+	files, err := serv.ListEntries(folderName, &fileType, sortField, sortOrder)
+	if err != nil {
+		fmt.Fprintln(stderr, errorMessage(err, folderName))
+		return
+	}
+
+	user := serv.GetCurrentUser()
+	if user == nil {
+		fmt.Fprintln(stderr, errorMessage(vfsgo.ErrNoUser, folderName))
+		return
+	}
+
+	if len(files) == 0 {
+		fmt.Fprintln(stderr, "Warning: The folder is empty.")
+		return
+	}
+
+	location := user.Name
+	if folder := strings.Trim(path.Clean(folderName), "/"); folder != "." && folder != "" {
+		location += "/" + folder
+	}
+
+	for _, file := range files {
+		fmt.Fprintf(stdout, "%s\t%s\t%s\t%s\n", file.Name, file.Description, file.CreatedTime.Format(createdAtLayout), location)
+	}
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"bufio"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -12,7 +14,16 @@ import (
 	"github.com/lemotw/vfsgo"
 )
 
-const FSROOTPATH = "/fs"
+const (
+	FSROOTPATH = "/fs"
+	SOCKETNAME = "vfsgo.sock"
+)
+
+var (
+	daemonMode = flag.Bool("daemon", false, "serve commands on unix socket")
+	socketPath = flag.String("socket", "", "unix socket of daemon (default fs root/"+SOCKETNAME+")")
+	session    = flag.String("session", "default", "daemon session keeping current user and folder")
+)
 
 func getProjRoot() (string, error) {
 	projRoot, err := os.Getwd()
@@ -35,7 +46,15 @@ func getProjRoot() (string, error) {
 	return projRoot, nil
 }
 
-func sendCMD(serv vfsgo.ICommandService, command string) bool {
+// sendCMD: run command on serv, report whether to quit and exit status of
+// the command
+func sendCMD(serv vfsgo.ICommandService, command string) (bool, int) {
+	status = exitOK
+	quit := runCMD(serv, command, &statusWriter{w: os.Stderr})
+	return quit, status
+}
+
+func runCMD(serv vfsgo.ICommandService, command string, stderr io.Writer) bool {
 	cmdSplitSlice := strings.Split(strings.TrimSpace(command), " ")
 
 	cmdSlice := make([]string, 0, len(cmdSplitSlice))
@@ -55,127 +74,191 @@ func sendCMD(serv vfsgo.ICommandService, command string) bool {
 	case "register":
 		if len(cmdSlice) != 2 {
 			log.Println("register command format: register username password")
+			status = exitInvalid
 			return false
 		}
 		log.Println("exec: register")
 		if err := serv.Register(cmdSlice[1]); err != nil {
-			log.Println(err.Error())
+			log.Println(errorMessage(err, cmdSlice[1]))
 		} else {
 			log.Println(fmt.Sprintf("Add [%s] successfully.", cmdSlice[1]))
 		}
 	case "use":
 		if len(cmdSlice) != 2 {
 			log.Println("use command format: use username")
+			status = exitInvalid
 			return false
 		}
 		log.Println("exec: use")
 		if err := serv.Use(cmdSlice[1]); err != nil {
-			log.Println(err.Error())
+			log.Println(errorMessage(err, cmdSlice[1]))
 		} else {
 			log.Println(fmt.Sprintf("You are using [%s].", cmdSlice[1]))
 		}
 	case "create-folder":
 		if len(cmdSlice) != 2 {
 			log.Println("create-folder command format: create-folder foldername path")
+			status = exitInvalid
 			return false
 		}
 		log.Println("exec: create-folder")
 		if err := serv.CreateFolder(cmdSlice[1]); err != nil {
-			log.Println(err.Error())
+			log.Println(errorMessage(err, cmdSlice[1]))
 		} else {
 			log.Println(fmt.Sprintf("Create [%s] successfully.", cmdSlice[1]))
 		}
 	case "delete-folder":
 		if len(cmdSlice) != 2 {
 			log.Println("delete-folder command format: delete-folder foldername path")
+			status = exitInvalid
 			return false
 		}
 		log.Println("exec: delete-folder")
-		if err := serv.DeleteFolder(cmdSlice[1]); err != nil {
-			log.Println(err.Error())
+		if vfsgo.HasMeta(cmdSlice[1]) {
+			deleteGlob(serv, cmdSlice[1], vfsgo.Directory)
+		} else if err := serv.DeleteFolder(cmdSlice[1]); err != nil {
+			log.Println(errorMessage(err, cmdSlice[1]))
 		} else {
 			log.Println(fmt.Sprintf("Delete [%s] successfully.", cmdSlice[1]))
 		}
 	case "cd":
 		if len(cmdSlice) != 2 {
 			log.Println("cd command format: cd path")
+			status = exitInvalid
 			return false
 		}
 		log.Println("exec: cd")
 		if err := serv.ChangeFolder(cmdSlice[1]); err != nil {
-			log.Println(err.Error())
+			log.Println(errorMessage(err, cmdSlice[1]))
 		}
 	case "ls":
-
-		var sortField *vfsgo.SortType
-		var sortOrder *string
-
-		if !(len(cmdSlice) == 2 || len(cmdSlice) == 4) {
-			log.Println("ls command format: ls path [sortField sortOrder]")
+		if len(cmdSlice) < 2 {
+			log.Println(lsUsage)
+			status = exitInvalid
 			return false
 		}
 
-		if len(cmdSlice) == 4 {
-			if cmdSlice[2] == "--sort-name" {
-				f := vfsgo.SortByName
-				sortField = &f
-			}
-
-			if cmdSlice[2] == "--sort-created" {
-				f := vfsgo.SortByCreatedTime
-				sortField = &f
-			}
-
-			if cmdSlice[3] == "asc" {
-				order := vfsgo.ASC
-				sortOrder = &order
-			}
+		args, filter, ok := parseTagFilter(cmdSlice[2:])
+		if !ok {
+			log.Println(lsUsage)
+			status = exitInvalid
+			return false
+		}
 
-			if cmdSlice[3] == "desc" {
-				order := vfsgo.DESC
-				sortOrder = &order
-			}
+		keys, dirsFirst, ok := parseSortKeys(args)
+		if !ok {
+			log.Println(lsUsage)
+			status = exitInvalid
+			return false
 		}
 
 		log.Println("exec: ls")
-		if files, err := serv.List(cmdSlice[1], sortField, sortOrder); err != nil {
-			log.Println(err.Error())
+		q := vfsgo.ListQuery{Filter: filter, Keys: keys, DirsFirst: dirsFirst}
+		if vfsgo.HasMeta(cmdSlice[1]) {
+			lsGlob(serv, cmdSlice[1], q)
+		} else if files, err := listFiltered(serv, cmdSlice[1], q); err != nil {
+			log.Println(errorMessage(err, cmdSlice[1]))
 		} else {
 			log.Println("files: ")
 			for _, file := range files {
-				log.Println(file)
+				if file.Type == vfsgo.Directory {
+					log.Println(file.Name + "/")
+				} else {
+					log.Println(file.Name)
+				}
 			}
 		}
+	case "list-folders":
+		listFolders(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "list-files":
+		listFiles(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "find":
+		findCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "tree":
+		treeCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "du":
+		duCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "du-cache":
+		duCacheCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "compress":
+		compressCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "encrypt":
+		encryptCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "unlock":
+		unlockCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "lock":
+		lockCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "rotate-key":
+		rotateKeyCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "verify":
+		verifyCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "root-hash":
+		rootHashCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "import":
+		importCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "export":
+		exportCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "export-archive":
+		exportArchiveCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "import-archive":
+		importArchiveCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "backup":
+		backupCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "restore":
+		restoreCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "search":
+		searchCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "tag":
+		tagCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "untag":
+		untagCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "setattr":
+		setattrCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "getattr":
+		getattrCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "rm":
+		rmCmd(serv, cmdSlice[1:], os.Stdout, stderr)
+	case "mv":
+		transferCmd(serv, cmdSlice[1:], os.Stdout, stderr, false)
+	case "cp":
+		transferCmd(serv, cmdSlice[1:], os.Stdout, stderr, true)
+	case "rename":
+		renameCmd(serv, cmdSlice[1:], os.Stdout, stderr)
 	case "rename-folder":
 		if len(cmdSlice) != 3 {
 			log.Println("rename-folder command format: rename-folder oldname newname path")
+			status = exitInvalid
 			return false
 		}
 		log.Println("exec: rename-folder")
 		if err := serv.RenameFolder(cmdSlice[1], cmdSlice[2]); err != nil {
-			log.Println(err.Error())
+			log.Println(errorMessage(err, cmdSlice[1]))
 		} else {
 			log.Println(fmt.Sprintf("Rename [%s] to [%s] successfully.", cmdSlice[1], cmdSlice[2]))
 		}
 	case "create-file":
 		if len(cmdSlice) != 3 {
 			log.Println("create-file command format: create-file filename path")
+			status = exitInvalid
 			return false
 		}
 		log.Println("exec: create-file")
 		if err := serv.CreateFile(cmdSlice[1], cmdSlice[2]); err != nil {
-			log.Println(err.Error())
+			log.Println(errorMessage(err, cmdSlice[1]))
 		} else {
 			log.Println(fmt.Sprintf("Create [%s] successfully.", cmdSlice[1]))
 		}
 	case "delete-file":
 		if len(cmdSlice) != 2 {
 			log.Println("delete-file command format: delete-file filename path")
+			status = exitInvalid
 			return false
 		}
 		log.Println("exec: delete-file")
-		if err := serv.DeleteFile(cmdSlice[1]); err != nil {
-			log.Println(err.Error())
+		if vfsgo.HasMeta(cmdSlice[1]) {
+			deleteGlob(serv, cmdSlice[1], vfsgo.File)
+		} else if err := serv.DeleteFile(cmdSlice[1]); err != nil {
+			log.Println(errorMessage(err, cmdSlice[1]))
 		} else {
 			log.Println(fmt.Sprintf("Delete [%s] successfully.", cmdSlice[1]))
 		}
@@ -187,11 +270,34 @@ func sendCMD(serv vfsgo.ICommandService, command string) bool {
 }
 
 func main() {
+	flag.Parse()
+
 	path, err := getProjRoot()
 	if err != nil {
 		panic(err)
 	}
 
+	socket := *socketPath
+	if socket == "" {
+		socket = path + FSROOTPATH + "/" + SOCKETNAME
+	}
+
+	if *daemonMode {
+		if err := runDaemon(path+FSROOTPATH, socket); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// command in arguments is forwarded to daemon
+	if flag.NArg() > 0 {
+		code, err := forward(socket, *session, strings.Join(flag.Args(), " "))
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(code)
+	}
+
 	reader := bufio.NewReader(os.Stdin)
 	serv := vfsgo.NewCommandService(path + FSROOTPATH)
 
@@ -200,7 +306,7 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
-		if sendCMD(serv, command) {
+		if quit, _ := sendCMD(serv, command); quit {
 			log.Println("goodbye!! ")
 			break
 		}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/lemotw/vfsgo"
+)
+
+const (
+	renameUsage = "Usage: rename [--dry-run] [-t f|d] [--start n] -e s/regex/replace/[gi] [foldername]"
+)
+
+// parseSubstitute: sed like s/regex/replace/flags, any char after s is the
+// delimiter and it can be escaped with backslash, flag i ignore case and
+// flag g replace every match instead of the first one
+func parseSubstitute(expr string) (vfsgo.RenameRule, bool) {
+	rule := vfsgo.RenameRule{}
+	if len(expr) < 2 || expr[0] != 's' {
+		return rule, false
+	}
+	delim := expr[1]
+
+	parts := []string{}
+	var part strings.Builder
+	for i := 2; i < len(expr); i++ {
+		switch {
+		case expr[i] == '\\' && i+1 < len(expr) && expr[i+1] == delim:
+			part.WriteByte(delim)
+			i++
+		case expr[i] == delim:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(expr[i])
+		}
+	}
+
+	if len(parts) != 2 {
+		return rule, false
+	}
+
+	rule.Regex, rule.Replace = parts[0], parts[1]
+	ignoreCase := false
+	for _, flag := range part.String() {
+		switch {
+		case flag == 'i' && !ignoreCase:
+			ignoreCase = true
+			rule.Regex = "(?i)" + rule.Regex
+		case flag == 'g' && !rule.Global:
+			rule.Global = true
+		default:
+			return rule, false
+		}
+	}
+
+	return rule, true
+}
+
+func parseRename(args []string) (string, vfsgo.RenameRule, bool, bool) {
+	dir := "."
+	rule := vfsgo.RenameRule{Start: 1}
+	dryRun, hasExpr := false, false
+
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--dry-run" {
+			dryRun = true
+			continue
+		}
+
+		if !strings.HasPrefix(args[i], "-") {
+			dir = args[i]
+			continue
+		}
+
+		if i+1 >= len(args) {
+			return "", rule, false, false
+		}
+		value := args[i+1]
+		i++
+
+		switch args[i-1] {
+		case "-e":
+			sub, ok := parseSubstitute(value)
+			if !ok {
+				return "", rule, false, false
+			}
+			rule.Regex, rule.Replace, rule.Global, hasExpr = sub.Regex, sub.Replace, sub.Global, true
+		case "-t":
+			fileType := vfsgo.File
+			switch value {
+			case "f":
+			case "d":
+				fileType = vfsgo.Directory
+			default:
+				return "", rule, false, false
+			}
+			rule.Type = &fileType
+		case "--start":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return "", rule, false, false
+			}
+			rule.Start = n
+		default:
+			return "", rule, false, false
+		}
+	}
+
+	return dir, rule, dryRun, hasExpr
+}
+
+// renameCmd: rename entries of folder by expression, nothing is renamed
+// on collision
+func renameCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	dir, rule, dryRun, ok := parseRename(args)
+	if !ok {
+		fmt.Fprintln(stderr, renameUsage)
+		return
+	}
+
+	renames, err := serv.RenameBatch(dir, rule, dryRun)
+	if err != nil {
+		// collision message name both entries
+		if errors.Is(err, vfsgo.ErrExist) {
+			status = exitCode(err)
+			fmt.Fprintln(stderr, "Error: "+err.Error())
+		} else {
+			fmt.Fprintln(stderr, errorMessage(err, dir))
+		}
+		return
+	}
+
+	if len(renames) == 0 {
+		fmt.Fprintln(stderr, "Warning: Nothing to rename.")
+		return
+	}
+
+	for _, r := range renames {
+		if dryRun {
+			fmt.Fprintf(stdout, "would rename %s -> %s\n", r.Old, r.New)
+		} else {
+			fmt.Fprintf(stdout, "Rename [%s] to [%s] successfully.\n", r.Old, r.New)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/lemotw/vfsgo"
+)
+
+const (
+	searchUsage = "Usage: search [-n limit] term|prefix*|\"phrase\"..."
+)
+
+// searchCmd: entries of current user matching query, best match first,
+// [path] [score] [description]
+func searchCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	limit := 0
+	if len(args) >= 2 && args[0] == "-n" {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			fmt.Fprintln(stderr, searchUsage)
+			return
+		}
+		limit = n
+		args = args[2:]
+	}
+
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, searchUsage)
+		return
+	}
+	query := strings.Join(args, " ")
+
+	results, err := serv.Search(query, limit)
+	if err != nil {
+		fmt.Fprintln(stderr, errorMessage(err, query))
+		return
+	}
+
+	if len(results) == 0 {
+		fmt.Fprintln(stderr, "Warning: Nothing matched.")
+		return
+	}
+
+	for _, r := range results {
+		p := r.Path
+		if r.Header.Type == vfsgo.Directory {
+			p += "/"
+		}
+		fmt.Fprintf(stdout, "%s\t%.2f\t%s\n", p, r.Score, r.Header.Description)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lemotw/vfsgo"
+)
+
+const (
+	treeUsage = "Usage: tree [path] [-L depth] [--desc] [--time] [--json]"
+)
+
+type treeOptions struct {
+	depth int
+	desc  bool
+	time  bool
+	json  bool
+}
+
+// jsonNode: json form of tree for tooling, close to tree -J
+type jsonNode struct {
+	Type         string     `json:"type"`
+	Name         string     `json:"name"`
+	Description  string     `json:"description,omitempty"`
+	Size         int64      `json:"size,omitempty"`
+	CreatedTime  *time.Time `json:"created_time,omitempty"`
+	ModifiedTime *time.Time `json:"modified_time,omitempty"`
+	Contents     []jsonNode `json:"contents,omitempty"`
+}
+
+type jsonReport struct {
+	Type        string `json:"type"`
+	Directories int    `json:"directories"`
+	Files       int    `json:"files"`
+}
+
+func parseTree(args []string) (string, treeOptions, bool) {
+	root := "."
+	opts := treeOptions{}
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		root = args[0]
+		args = args[1:]
+	}
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-L":
+			if i+1 >= len(args) {
+				return "", opts, false
+			}
+
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n <= 0 {
+				return "", opts, false
+			}
+			opts.depth = n
+			i++
+		case "--desc":
+			opts.desc = true
+		case "--time":
+			opts.time = true
+		case "--json":
+			opts.json = true
+		default:
+			return "", opts, false
+		}
+	}
+
+	return root, opts, true
+}
+
+// treeCmd: print hierarchy under path with count of folders and files
+func treeCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	root, opts, ok := parseTree(args)
+	if !ok {
+		fmt.Fprintln(stderr, treeUsage)
+		return
+	}
+
+	tree, err := serv.Tree(root, opts.depth)
+	if err != nil {
+		fmt.Fprintln(stderr, errorMessage(err, root))
+		return
+	}
+
+	dirs, files := tree.Count()
+
+	if opts.json {
+		tree.Header.Name = root
+		out := []interface{}{toJSONNode(&tree, opts), jsonReport{Type: "report", Directories: dirs, Files: files}}
+		b, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			fmt.Fprintln(stderr, "Error: "+err.Error())
+			return
+		}
+		fmt.Fprintln(stdout, string(b))
+		return
+	}
+
+	fmt.Fprintln(stdout, root)
+	printTree(stdout, tree.Children, "", opts)
+	fmt.Fprintf(stdout, "\n%d directories, %d files\n", dirs, files)
+}
+
+func printTree(w io.Writer, nodes []vfsgo.TreeNode, prefix string, opts treeOptions) {
+	for i := range nodes {
+		branch, indent := "├── ", "│   "
+		if i == len(nodes)-1 {
+			branch, indent = "└── ", "    "
+		}
+
+		fmt.Fprintln(w, prefix+branch+treeLine(&nodes[i].Header, opts))
+		printTree(w, nodes[i].Children, prefix+indent, opts)
+	}
+}
+
+func treeLine(h *vfsgo.FileHeader, opts treeOptions) string {
+	line := h.Name
+	if h.Type == vfsgo.Directory {
+		line += "/"
+	}
+
+	if opts.time {
+		line = fmt.Sprintf("[%s] %s", h.ModifiedTime.Format(createdAtLayout), line)
+	}
+
+	if opts.desc && h.Description != "" {
+		line += "  " + h.Description
+	}
+
+	return line
+}
+
+func toJSONNode(n *vfsgo.TreeNode, opts treeOptions) jsonNode {
+	node := jsonNode{Type: "file", Name: n.Header.Name, Size: n.Header.Size}
+	if n.Header.Type == vfsgo.Directory {
+		node.Type = "directory"
+		node.Size = 0
+	}
+
+	if opts.desc {
+		node.Description = n.Header.Description
+	}
+
+	if opts.time && !n.Header.CreatedTime.IsZero() {
+		created, modified := n.Header.CreatedTime, n.Header.ModifiedTime
+		node.CreatedTime, node.ModifiedTime = &created, &modified
+	}
+
+	for i := range n.Children {
+		node.Contents = append(node.Contents, toJSONNode(&n.Children[i], opts))
+	}
+
+	return node
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/lemotw/vfsgo"
+)
+
+const (
+	verifyUsage   = "Usage: verify"
+	rootHashUsage = "Usage: root-hash"
+)
+
+// verifyCmd: check whole tree of current user, [path] [reason] of every
+// damage found
+func verifyCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	if len(args) != 0 {
+		fmt.Fprintln(stderr, verifyUsage)
+		return
+	}
+
+	damages, err := serv.Verify()
+	if err != nil {
+		fmt.Fprintln(stderr, errorMessage(err, "user"))
+		return
+	}
+
+	if len(damages) == 0 {
+		fmt.Fprintln(stdout, "Nothing damaged.")
+		return
+	}
+
+	for _, d := range damages {
+		fmt.Fprintf(stdout, "%s\t%s\n", d.Path, d.Reason)
+	}
+	fmt.Fprintf(stderr, "Warning: %d damages found.\n", len(damages))
+}
+
+// rootHashCmd: merkle root hash of current user, equal on replicas holding
+// the same tree
+func rootHashCmd(serv vfsgo.ICommandService, args []string, stdout, stderr io.Writer) {
+	if len(args) != 0 {
+		fmt.Fprintln(stderr, rootHashUsage)
+		return
+	}
+
+	hash, err := serv.RootHash()
+	if err != nil {
+		fmt.Fprintln(stderr, errorMessage(err, "user"))
+		return
+	}
+
+	fmt.Fprintln(stdout, hash)
+}
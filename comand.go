@@ -1,9 +1,13 @@
 package vfsgo
 
 import (
+	"errors"
+	"io"
 	"os"
-	"sort"
+	"path"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"golang.org/x/xerrors"
 )
@@ -13,6 +17,10 @@ type SortType int
 const (
 	SortByName SortType = iota + 1
 	SortByCreatedTime
+	SortByModifiedTime
+	SortBySize
+	SortByType
+	SortByDescription
 
 	ASC  string = "ASC"
 	DESC string = "DESC"
@@ -33,15 +41,59 @@ type ICommandService interface {
 	CreateFile(fileName, desc string) error
 	DeleteFile(fileName string) error
 	RenameFile(oldName, newName string, newDesc string) error
+	WriteFile(fileName string, r io.Reader) error
+	WriteFileAt(fileName string, offset int64, r io.Reader) error
+	ReadFile(fileName string) (io.ReadCloser, error)
 
+	Stat(filePath string) (FileHeader, error)
 	List(dirName string, sortField *SortType, sortOrder *string) ([]string, error)
+	ListEntries(dirName string, fileType *FileType, sortField *SortType, sortOrder *string) ([]FileHeader, error)
+	ListSorted(dirName string, fileType *FileType, keys []SortKey, dirsFirst bool) ([]FileHeader, error)
+	ListPage(dirName string, query ListQuery) (Page, error)
+
+	Find(root string, query FindQuery) ([]Match, error)
+	Remove(filePath string) error
+	Move(srcPath, dstDir string) error
+	Copy(srcPath, dstDir string) error
+	Glob(pattern string) ([]Match, error)
+	Tree(root string, depth int) (TreeNode, error)
+	Usage(dirPath string, depth int) (DiskUsage, error)
+	SetUsageCache(enabled bool) error
+	SetCompression(dirPath, codec string) error
+	Encrypt(passphrase, mode string) error
+	Unlock(name, passphrase string) error
+	Lock(name string) error
+	RotateKey(passphrase, newPassphrase string) error
+	Search(query string, limit int) ([]SearchResult, error)
+	BeginBatch() error
+	EndBatch() error
+	RenameBatch(dirName string, rule RenameRule, dryRun bool) ([]Rename, error)
+	Verify() ([]Damage, error)
+	RootHash() (string, error)
+	Import(hostPath, vfsPath string, opts ImportOptions) (ImportReport, error)
+	Export(vfsPath, hostPath string, opts ExportOptions) (ExportReport, error)
+	ExportArchive(vfsPath, format string, w io.Writer) error
+	ImportArchive(r io.Reader, vfsPath, format string, opts ImportOptions) (ImportReport, error)
+	Backup(w io.Writer, format string) error
+	Restore(r io.Reader, format string, users []string) (RestoreReport, error)
+
+	Tag(filePath string, tags ...string) error
+	Untag(filePath string, tags ...string) error
+	SetAttr(filePath, key, value string) error
+	GetAttr(filePath, key string) (string, error)
 }
 
 func NewCommandService(root string) ICommandService {
+	return NewCommandServiceWithUsers(root, make(map[string]*User))
+}
+
+// NewCommandServiceWithUsers: services created with the same userMap share
+// cached users, calls of these services must be serialized by caller
+func NewCommandServiceWithUsers(root string, userMap map[string]*User) ICommandService {
 	return &commandService{
 		root:        root,
 		currentUser: nil,
-		userMap:     make(map[string]*User),
+		userMap:     userMap,
 	}
 }
 
@@ -51,6 +103,11 @@ type commandService struct {
 	currentBlock *BlockINode
 
 	userMap map[string]*User
+
+	// batch: depth of BeginBatch, index changed in batch is saved by
+	// EndBatch and kept in dirty until then
+	batch int
+	dirty *User
 }
 
 func (cs *commandService) GetCurrentUser() *User {
@@ -63,23 +120,28 @@ func (cs *commandService) GetCurrentBlock() *BlockINode {
 
 func (cs *commandService) validRegister(name string) error {
 	if _, ok := cs.userMap[name]; ok {
-		return xerrors.Errorf("The [%s] has already existed", name)
+		return errorf(ErrExist, "The [%s] has already existed", name)
 	}
 
 	if strings.Index(name, " ") != -1 {
-		return xerrors.Errorf("The [%s] contain invalid chars", name)
+		return errorf(ErrInvalidName, "The [%s] contain invalid chars", name)
 	}
 
 	if strings.Index(name, "/") != -1 {
-		return xerrors.Errorf("The [%s] contain invalid chars", name)
+		return errorf(ErrInvalidName, "The [%s] contain invalid chars", name)
 	}
 
 	if strings.Index(name, "\\") != -1 {
-		return xerrors.Errorf("The [%s] contain invalid chars", name)
+		return errorf(ErrInvalidName, "The [%s] contain invalid chars", name)
 	}
 
 	if strings.Index(name, "%") != -1 {
-		return xerrors.Errorf("The [%s] contain invalid chars", name)
+		return errorf(ErrInvalidName, "The [%s] contain invalid chars", name)
+	}
+
+	// names of dot are kept for root, like the blob store and the lock
+	if strings.HasPrefix(name, ".") {
+		return errorf(ErrInvalidName, "The [%s] contain invalid chars", name)
 	}
 
 	return nil
@@ -87,7 +149,7 @@ func (cs *commandService) validRegister(name string) error {
 
 func (cs *commandService) travelFolder(path string) (*BlockINode, error) {
 	if cs.currentBlock == nil {
-		return nil, xerrors.New("current block is nil")
+		return nil, NewError(ErrNoUser, "current block is nil")
 	}
 
 	if path == "" {
@@ -95,13 +157,25 @@ func (cs *commandService) travelFolder(path string) (*BlockINode, error) {
 	}
 
 	blockRet := cs.currentBlock
-	directories := strings.Split(strings.TrimSpace(path), "/")
+	path = strings.TrimSpace(path)
+
+	// absolute path start from root block
+	if strings.HasPrefix(path, "/") {
+		b, ok := cs.currentUser.BlockMap[0]
+		if !ok {
+			return nil, NewError(ErrNotExist, "user not has root path")
+		}
+		blockRet = &b
+		path = strings.TrimLeft(path, "/")
+	}
+
+	directories := strings.Split(path, "/")
 
 	for i := 0; i < len(directories); i++ {
 		var nodeid uint64
 
 		switch directories[i] {
-		case ".":
+		case "", ".":
 			nodeid = blockRet.NodeID
 		case "..":
 			nodeid = blockRet.PrevNodeID
@@ -109,12 +183,12 @@ func (cs *commandService) travelFolder(path string) (*BlockINode, error) {
 			if b, ok := blockRet.FileMap[directories[i]]; ok && b.Type == Directory && b.DirNodeID != nil {
 				nodeid = *b.DirNodeID
 			} else {
-				return nil, xerrors.Errorf("path %s not exist", path)
+				return nil, errorf(ErrNotExist, "path %s not exist", path)
 			}
 		}
 
 		if b, ok := cs.currentUser.BlockMap[nodeid]; !ok {
-			return nil, xerrors.Errorf("path %s not exist", path)
+			return nil, errorf(ErrNotExist, "path %s not exist", path)
 		} else {
 			blockRet = &b
 		}
@@ -124,6 +198,12 @@ func (cs *commandService) travelFolder(path string) (*BlockINode, error) {
 }
 
 func (cs *commandService) Register(name string) error {
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	if err := cs.validRegister(name); err != nil {
 		return xerrors.Errorf("validate: %w", err)
 	}
@@ -139,6 +219,16 @@ func (cs *commandService) Register(name string) error {
 }
 
 func (cs *commandService) Use(name string) error {
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if s, ok := keyOf(filepath.Join(cs.root, name)); ok && s == nil {
+		return errorf(ErrLocked, "User [%s] is locked", name)
+	}
+
 	if u, ok := cs.userMap[name]; ok {
 		// hit cached in memory
 		if err := AttemptUser(u.RootPath, u.Name); err != nil {
@@ -147,7 +237,7 @@ func (cs *commandService) Use(name string) error {
 
 		b, ok := u.BlockMap[0]
 		if !ok {
-			return xerrors.Errorf("user not has root path")
+			return NewError(ErrNotExist, "user not has root path")
 		}
 
 		cs.currentUser = u
@@ -157,20 +247,30 @@ func (cs *commandService) Use(name string) error {
 	}
 
 	u, err := GetUser(cs.root, name)
+	if errors.Is(err, ErrLocked) {
+		return errorf(ErrLocked, "User [%s] is locked", name)
+	}
+	if errors.Is(err, ErrNotExist) {
+		return errorf(ErrNotExist, "User [%s] not exist", name)
+	}
 	if err != nil {
-		return xerrors.Errorf("User [%s] not exist", name)
+		return xerrors.Errorf("error in GetUser: %w", err)
 	}
 
 	// get root block
 	b, ok := u.BlockMap[0]
 	if !ok {
-		return xerrors.Errorf("user not has root path")
+		return NewError(ErrNotExist, "user not has root path")
 	}
 
 	cs.userMap[name] = &u
 	cs.currentUser = &u
 	cs.currentBlock = &b
 
+	if err := cs.migrateContents(); err != nil {
+		return xerrors.Errorf("err in migrateContents: %w", err)
+	}
+
 	return nil
 }
 
@@ -181,7 +281,7 @@ func (cs *commandService) ChangeFolder(path string) error {
 	}
 
 	if block == nil {
-		return xerrors.New("block is nil")
+		return NewError(ErrNoUser, "block is nil")
 	}
 
 	cs.currentBlock = block
@@ -190,36 +290,47 @@ func (cs *commandService) ChangeFolder(path string) error {
 }
 
 func (cs *commandService) validateCreateFolder(name string) error {
+	return validateName(name)
+}
+
+// validateName: name of file or folder not contain path or invalid chars
+func validateName(name string) error {
 	if strings.Index(name, " ") != -1 {
-		return xerrors.Errorf("The [%s] contain invalid chars", name)
+		return errorf(ErrInvalidName, "The [%s] contain invalid chars", name)
 	}
 
 	if strings.Index(name, "/") != -1 {
-		return xerrors.Errorf("The [%s] contain invalid chars", name)
+		return errorf(ErrInvalidName, "The [%s] contain invalid chars", name)
 	}
 
 	if strings.Index(name, "\\") != -1 {
-		return xerrors.Errorf("The [%s] contain invalid chars", name)
+		return errorf(ErrInvalidName, "The [%s] contain invalid chars", name)
 	}
 
 	if strings.Index(name, "%") != -1 {
-		return xerrors.Errorf("The [%s] contain invalid chars", name)
+		return errorf(ErrInvalidName, "The [%s] contain invalid chars", name)
 	}
 
 	return nil
 }
 func (cs *commandService) CreateFolder(dirName string) error {
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	if cs.currentBlock == nil {
-		return xerrors.New("current block is nil")
+		return NewError(ErrNoUser, "current block is nil")
 	}
 
 	dirName = strings.TrimSpace(dirName)
 	if strings.Index(dirName, "/") != -1 {
-		return xerrors.New("invalid directory name")
+		return NewError(ErrInvalidName, "invalid directory name")
 	}
 
 	if _, ok := cs.currentBlock.FileMap[dirName]; ok {
-		return xerrors.New("directory already exist")
+		return NewError(ErrExist, "directory already exist")
 	}
 
 	if err := cs.validateCreateFolder(dirName); err != nil {
@@ -236,55 +347,81 @@ func (cs *commandService) CreateFolder(dirName string) error {
 		return xerrors.Errorf("create folder: %w", err)
 	}
 
+	if cs.currentUser.UsageCache {
+		block.Usage = &BlockUsage{}
+	}
+
 	cs.currentUser.BlockMap[block.NodeID] = block
 	cs.currentUser.CurrentNodeID++
-	cs.currentUser.Save()
 
-	return nil
-}
+	if err := cs.adjustUsage(cs.currentBlock.NodeID, BlockUsage{Dirs: 1}); err != nil {
+		return xerrors.Errorf("err in adjustUsage: %w", err)
+	}
 
-func (cs *commandService) DeleteFolder(oldName string) error {
-	header, ok := cs.currentBlock.FileMap[oldName]
-	if !ok {
-		return xerrors.New("directory not exist")
+	if err := cs.currentUser.Save(); err != nil {
+		return xerrors.Errorf("err in currentUser.Save: %w", err)
 	}
 
-	if header.Type != Directory || header.DirNodeID == nil {
-		return xerrors.New("not a directory")
+	if err := cs.reindex(cs.currentBlock, dirName); err != nil {
+		return xerrors.Errorf("err in reindex: %w", err)
 	}
 
-	if err := os.Remove(cs.currentBlock.GetBlockPath() + "/" + header.HashFileName); err != nil {
-		return xerrors.Errorf("err in os.Remove: %w", err)
+	return nil
+}
+
+func (cs *commandService) DeleteFolder(oldName string) error {
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
-	dirBloc := BlockINode{UserPath: cs.currentUser.GetUserPath(), NodeID: *header.DirNodeID}
-	if err := os.RemoveAll(dirBloc.GetBlockPath()); err != nil {
-		return xerrors.Errorf("err in os.Remove: %w", err)
+	if cs.currentBlock == nil {
+		return NewError(ErrNoUser, "current block is nil")
 	}
 
-	delete(cs.currentBlock.FileMap, oldName)
-	delete(cs.currentUser.BlockMap, *header.DirNodeID)
-	cs.currentUser.BlockMap[cs.currentBlock.NodeID] = *cs.currentBlock
+	header, ok := cs.currentBlock.FileMap[oldName]
+	if !ok {
+		return NewError(ErrNotExist, "directory not exist")
+	}
 
-	if err := cs.currentBlock.Save(); err != nil {
-		return xerrors.Errorf("err in currentBlock.Save: %w", err)
+	if header.Type != Directory || header.DirNodeID == nil {
+		return NewError(ErrNotDir, "not a directory")
 	}
 
-	if err := cs.currentUser.Save(); err != nil {
-		return xerrors.Errorf("err in currentUser.Save: %w", err)
+	if err := cs.removeEntry(cs.currentBlock, oldName); err != nil {
+		return xerrors.Errorf("err in removeEntry: %w", err)
 	}
 
 	return nil
 }
 
 func (cs *commandService) RenameFolder(oldName string, newName string) error {
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if cs.currentBlock == nil {
+		return NewError(ErrNoUser, "current block is nil")
+	}
+
 	header, ok := cs.currentBlock.FileMap[oldName]
 	if !ok {
-		return xerrors.New("directory not exist")
+		return NewError(ErrNotExist, "directory not exist")
 	}
 
 	if header.Type != Directory || header.DirNodeID == nil {
-		return xerrors.New("not a directory")
+		return NewError(ErrNotDir, "not a directory")
+	}
+
+	if err := cs.validateCreateFolder(newName); err != nil {
+		return xerrors.Errorf("validate: %w", err)
+	}
+
+	if _, ok := cs.currentBlock.FileMap[newName]; ok && newName != oldName {
+		return errorf(ErrExist, "%s already exist", newName)
 	}
 
 	header.Name = newName
@@ -293,7 +430,6 @@ func (cs *commandService) RenameFolder(oldName string, newName string) error {
 	}
 
 	delete(cs.currentBlock.FileMap, oldName)
-	delete(cs.currentUser.BlockMap, *header.DirNodeID)
 
 	cs.currentBlock.FileMap[newName] = header
 	if err := cs.currentBlock.Save(); err != nil {
@@ -305,16 +441,30 @@ func (cs *commandService) RenameFolder(oldName string, newName string) error {
 		return xerrors.Errorf("err in currentUser.Save: %w", err)
 	}
 
+	if err := cs.reindex(cs.currentBlock, newName); err != nil {
+		return xerrors.Errorf("err in reindex: %w", err)
+	}
+
 	return nil
 }
 
 func (cs *commandService) CreateFile(fileName, desc string) error {
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	if cs.currentBlock == nil {
-		return xerrors.New("block is nil")
+		return NewError(ErrNoUser, "block is nil")
 	}
 
 	if _, ok := cs.currentBlock.FileMap[fileName]; ok {
-		return xerrors.New("file already exist")
+		return NewError(ErrExist, "file already exist")
+	}
+
+	if err := cs.validateCreateFolder(fileName); err != nil {
+		return xerrors.Errorf("validate: %w", err)
 	}
 
 	file, err := CreateFile(cs.currentBlock, fileName, desc)
@@ -324,46 +474,74 @@ func (cs *commandService) CreateFile(fileName, desc string) error {
 
 	cs.currentBlock.FileMap[fileName] = file
 	cs.currentUser.BlockMap[cs.currentBlock.NodeID] = *cs.currentBlock
+	if err := cs.adjustUsage(cs.currentBlock.NodeID, BlockUsage{Files: 1}); err != nil {
+		return xerrors.Errorf("err in adjustUsage: %w", err)
+	}
+
+	if err := cs.currentUser.Save(); err != nil {
+		return xerrors.Errorf("err in currentUser.Save: %w", err)
+	}
+
+	if err := cs.reindex(cs.currentBlock, fileName); err != nil {
+		return xerrors.Errorf("err in reindex: %w", err)
+	}
 
 	return nil
 }
 
 func (cs *commandService) DeleteFile(oldName string) error {
-	header, ok := cs.currentBlock.FileMap[oldName]
-	if !ok {
-		return xerrors.New("file not exist")
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
-	if header.Type != File {
-		return xerrors.New("not a file")
+	if cs.currentBlock == nil {
+		return NewError(ErrNoUser, "current block is nil")
 	}
 
-	if err := os.Remove(cs.currentBlock.GetBlockPath() + "/" + header.HashFileName); err != nil {
-		return xerrors.Errorf("err in os.Remove: %w", err)
+	header, ok := cs.currentBlock.FileMap[oldName]
+	if !ok {
+		return NewError(ErrNotExist, "file not exist")
 	}
 
-	delete(cs.currentBlock.FileMap, oldName)
-	cs.currentUser.BlockMap[cs.currentBlock.NodeID] = *cs.currentBlock
-
-	if err := cs.currentBlock.Save(); err != nil {
-		return xerrors.Errorf("err in currentBlock.Save: %w", err)
+	if header.Type != File {
+		return NewError(ErrIsDir, "not a file")
 	}
 
-	if err := cs.currentUser.Save(); err != nil {
-		return xerrors.Errorf("err in currentUser.Save: %w", err)
+	if err := cs.removeEntry(cs.currentBlock, oldName); err != nil {
+		return xerrors.Errorf("err in removeEntry: %w", err)
 	}
 
 	return nil
 }
 
 func (cs *commandService) RenameFile(oldName string, newName string, newDesc string) error {
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if cs.currentBlock == nil {
+		return NewError(ErrNoUser, "current block is nil")
+	}
+
 	header, ok := cs.currentBlock.FileMap[oldName]
 	if !ok {
-		return xerrors.New("file not exist")
+		return NewError(ErrNotExist, "file not exist")
 	}
 
 	if header.Type != File {
-		return xerrors.New("not a file")
+		return NewError(ErrIsDir, "not a file")
+	}
+
+	if err := cs.validateCreateFolder(newName); err != nil {
+		return xerrors.Errorf("validate: %w", err)
+	}
+
+	if _, ok := cs.currentBlock.FileMap[newName]; ok && newName != oldName {
+		return errorf(ErrExist, "%s already exist", newName)
 	}
 
 	header.Name = newName
@@ -385,17 +563,103 @@ func (cs *commandService) RenameFile(oldName string, newName string, newDesc str
 		return xerrors.Errorf("err in currentUser.Save: %w", err)
 	}
 
+	if err := cs.reindex(cs.currentBlock, newName); err != nil {
+		return xerrors.Errorf("err in reindex: %w", err)
+	}
+
+	return nil
+}
+
+func (cs *commandService) WriteFile(fileName string, r io.Reader) error {
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if cs.currentBlock == nil {
+		return NewError(ErrNoUser, "block is nil")
+	}
+
+	old := fileUsage(cs.currentBlock.FileMap[fileName])
+	header, err := WriteFileContent(cs.currentBlock, fileName, r, cs.compressionOf(cs.currentBlock))
+	if err != nil {
+		return xerrors.Errorf("err in WriteFileContent: %w", err)
+	}
+
+	cs.currentUser.BlockMap[cs.currentBlock.NodeID] = *cs.currentBlock
+	delta := fileUsage(header)
+	delta.add(old.neg())
+	if err := cs.adjustUsage(cs.currentBlock.NodeID, delta); err != nil {
+		return xerrors.Errorf("err in adjustUsage: %w", err)
+	}
+
+	if err := cs.currentUser.Save(); err != nil {
+		return xerrors.Errorf("err in currentUser.Save: %w", err)
+	}
+
+	if err := cs.reindex(cs.currentBlock, fileName); err != nil {
+		return xerrors.Errorf("err in reindex: %w", err)
+	}
+
 	return nil
 }
 
+func (cs *commandService) ReadFile(fileName string) (io.ReadCloser, error) {
+	if cs.currentBlock == nil {
+		return nil, NewError(ErrNoUser, "block is nil")
+	}
+
+	file, err := OpenFileContent(cs.currentBlock, fileName)
+	if err != nil {
+		return nil, xerrors.Errorf("err in OpenFileContent: %w", err)
+	}
+
+	return file, nil
+}
+
+// Stat: header of the entry at filePath, the root folder has no header so a
+// directory header point to it is returned
+func (cs *commandService) Stat(filePath string) (FileHeader, error) {
+	dir, name := path.Split(strings.TrimRight(strings.TrimSpace(filePath), "/"))
+
+	if name == "" || name == "." || name == ".." {
+		block, err := cs.travelFolder(filePath)
+		if err != nil {
+			return FileHeader{}, xerrors.Errorf("err in travelFolder: %w", err)
+		}
+
+		nodeid := block.NodeID
+		return FileHeader{Type: Directory, DirNodeID: &nodeid, Name: name}, nil
+	}
+
+	block, err := cs.travelFolder(dir)
+	if err != nil {
+		return FileHeader{}, xerrors.Errorf("err in travelFolder: %w", err)
+	}
+
+	header, ok := block.FileMap[name]
+	if !ok {
+		return FileHeader{}, errorf(ErrNotExist, "path %s not exist", filePath)
+	}
+
+	return header, nil
+}
+
 func (cs *commandService) List(dirName string, sortField *SortType, sortOrder *string) ([]string, error) {
-	block, err := cs.travelFolder(dirName)
+	var keys []SortKey
+	if sortField != nil && sortOrder != nil {
+		keys = []SortKey{{Field: *sortField, Order: *sortOrder}}
+	}
+
+	files, err := cs.ListSorted(dirName, nil, keys, false)
 	if err != nil {
-		return nil, xerrors.Errorf("err in travelFolder: %w", err)
+		return nil, err
 	}
 
-	ret := make([]string, 0, len(cs.currentBlock.FileMap))
-	for fname, file := range block.FileMap {
+	ret := make([]string, 0, len(files))
+	for _, file := range files {
+		fname := file.Name
 		if file.Type == Directory {
 			fname += "/"
 		}
@@ -403,27 +667,477 @@ func (cs *commandService) List(dirName string, sortField *SortType, sortOrder *s
 		ret = append(ret, fname)
 	}
 
+	return ret, nil
+}
+
+// ListEntries: headers in folder, only fileType entries if it is not nil,
+// sorted by name ascending if sortField or sortOrder is nil
+func (cs *commandService) ListEntries(dirName string, fileType *FileType, sortField *SortType, sortOrder *string) ([]FileHeader, error) {
+	keys := []SortKey{{Field: SortByName, Order: ASC}}
 	if sortField != nil && sortOrder != nil {
-		if *sortField == SortByName && *sortOrder == ASC {
-			sort.Strings(ret)
+		keys = []SortKey{{Field: *sortField, Order: *sortOrder}}
+	}
+
+	return cs.ListSorted(dirName, fileType, keys, false)
+}
+
+// ListSorted: headers in folder sorted by keys, see SortHeaders
+func (cs *commandService) ListSorted(dirName string, fileType *FileType, keys []SortKey, dirsFirst bool) ([]FileHeader, error) {
+	block, err := cs.travelFolder(dirName)
+	if err != nil {
+		return nil, xerrors.Errorf("err in travelFolder: %w", err)
+	}
+
+	ret := make([]FileHeader, 0, len(block.FileMap))
+	for _, file := range block.FileMap {
+		if fileType != nil && file.Type != *fileType {
+			continue
 		}
 
-		if *sortField == SortByName && *sortOrder == DESC {
-			sort.Sort(sort.Reverse(sort.StringSlice(ret)))
+		ret = append(ret, file)
+	}
+
+	SortHeaders(ret, keys, dirsFirst)
+
+	return ret, nil
+}
+
+// ListPage: one page of filtered entries in folder, see Paginate
+func (cs *commandService) ListPage(dirName string, query ListQuery) (Page, error) {
+	block, err := cs.travelFolder(dirName)
+	if err != nil {
+		return Page{}, xerrors.Errorf("err in travelFolder: %w", err)
+	}
+
+	page, err := Paginate(block.FileMap, query)
+	if err != nil {
+		return Page{}, xerrors.Errorf("err in Paginate: %w", err)
+	}
+
+	return page, nil
+}
+
+// Find: entries under folder root matched by query
+func (cs *commandService) Find(root string, query FindQuery) ([]Match, error) {
+	block, err := cs.travelFolder(root)
+	if err != nil {
+		return nil, xerrors.Errorf("err in travelFolder: %w", err)
+	}
+
+	match, err := query.Compile()
+	if err != nil {
+		return nil, xerrors.Errorf("err in Compile: %w", err)
+	}
+
+	matches := []Match{}
+	err = Walk(cs.currentUser, block, root, query.MaxDepth, func(path string, depth int, h FileHeader) error {
+		if depth >= query.MinDepth && match(h) {
+			matches = append(matches, Match{Path: path, Header: h})
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("err in Walk: %w", err)
+	}
 
-		if *sortField == SortByCreatedTime && *sortOrder == ASC {
-			sort.Slice(ret, func(i, j int) bool {
-				return block.FileMap[ret[i]].CreatedTime.Before(block.FileMap[ret[j]].CreatedTime)
-			})
+	return matches, nil
+}
+
+// Remove: remove file or folder with everything under it
+func (cs *commandService) Remove(filePath string) error {
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	block, name, err := cs.travelEntry(filePath)
+	if err != nil {
+		return xerrors.Errorf("err in travelEntry: %w", err)
+	}
+
+	header := block.FileMap[name]
+	if header.Type == Directory && header.DirNodeID != nil && cs.isUnder(cs.currentBlock.NodeID, *header.DirNodeID) {
+		return errorf(ErrPermission, "%s contain current folder", filePath)
+	}
+
+	if err := cs.removeEntry(block, name); err != nil {
+		return xerrors.Errorf("err in removeEntry: %w", err)
+	}
+
+	return nil
+}
+
+// Move: move file or folder into folder dstDir keeping its name
+func (cs *commandService) Move(srcPath, dstDir string) error {
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	src, name, err := cs.travelEntry(srcPath)
+	if err != nil {
+		return xerrors.Errorf("err in travelEntry: %w", err)
+	}
+
+	dst, err := cs.travelFolder(dstDir)
+	if err != nil {
+		return xerrors.Errorf("err in travelFolder: %w", err)
+	}
+
+	if src.NodeID == dst.NodeID {
+		return nil
+	}
+
+	header := src.FileMap[name]
+	if _, ok := dst.FileMap[name]; ok {
+		return errorf(ErrExist, "%s already exist in %s", name, dstDir)
+	}
+
+	if header.Type == Directory && header.DirNodeID != nil && cs.isUnder(dst.NodeID, *header.DirNodeID) {
+		return errorf(ErrInvalidName, "cannot move %s into itself", srcPath)
+	}
+
+	if err := os.Rename(src.GetBlockPath()+"/"+header.HashFileName, dst.GetBlockPath()+"/"+header.HashFileName); err != nil {
+		return xerrors.Errorf("err in os.Rename: %w", err)
+	}
+
+	if err := os.Rename(header.GetContentPath(src.GetBlockPath()), header.GetContentPath(dst.GetBlockPath())); err != nil && !os.IsNotExist(err) {
+		return xerrors.Errorf("err in os.Rename: %w", err)
+	}
+
+	delete(src.FileMap, name)
+	dst.FileMap[name] = header
+
+	blocks := []*BlockINode{src, dst}
+	if header.Type == Directory && header.DirNodeID != nil {
+		child, ok := cs.currentUser.BlockMap[*header.DirNodeID]
+		if !ok {
+			return errorf(ErrNotExist, "block of %s not exist", srcPath)
 		}
 
-		if *sortField == SortByCreatedTime && *sortOrder == DESC {
-			sort.Slice(ret, func(i, j int) bool {
-				return block.FileMap[ret[i]].CreatedTime.After(block.FileMap[ret[j]].CreatedTime)
-			})
+		child.PrevNodeID = dst.NodeID
+		blocks = append(blocks, &child)
+	}
+
+	if err := cs.writeBlocks(blocks...); err != nil {
+		return xerrors.Errorf("err in writeBlocks: %w", err)
+	}
+
+	usage := cs.entryUsage(header)
+	if err := cs.adjustUsage(src.NodeID, usage.neg()); err != nil {
+		return xerrors.Errorf("err in adjustUsage: %w", err)
+	}
+
+	if err := cs.adjustUsage(dst.NodeID, usage); err != nil {
+		return xerrors.Errorf("err in adjustUsage: %w", err)
+	}
+
+	if err := cs.currentUser.Save(); err != nil {
+		return xerrors.Errorf("err in currentUser.Save: %w", err)
+	}
+
+	if err := cs.reindex(dst, name); err != nil {
+		return xerrors.Errorf("err in reindex: %w", err)
+	}
+
+	return nil
+}
+
+// Copy: copy file or folder with everything under it into folder dstDir
+func (cs *commandService) Copy(srcPath, dstDir string) error {
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	src, name, err := cs.travelEntry(srcPath)
+	if err != nil {
+		return xerrors.Errorf("err in travelEntry: %w", err)
+	}
+
+	dst, err := cs.travelFolder(dstDir)
+	if err != nil {
+		return xerrors.Errorf("err in travelFolder: %w", err)
+	}
+
+	if _, ok := dst.FileMap[name]; ok {
+		return errorf(ErrExist, "%s already exist in %s", name, dstDir)
+	}
+
+	header := src.FileMap[name]
+	if header.Type == Directory && header.DirNodeID != nil && cs.isUnder(dst.NodeID, *header.DirNodeID) {
+		return errorf(ErrInvalidName, "cannot copy %s into itself", srcPath)
+	}
+
+	copied := []*BlockINode{}
+	if err := cs.copyEntry(src, name, dst, &copied); err != nil {
+		return xerrors.Errorf("err in copyEntry: %w", err)
+	}
+
+	if err := cs.writeBlocks(append(copied, dst)...); err != nil {
+		return xerrors.Errorf("err in writeBlocks: %w", err)
+	}
+
+	if err := cs.adjustUsage(dst.NodeID, cs.entryUsage(dst.FileMap[name])); err != nil {
+		return xerrors.Errorf("err in adjustUsage: %w", err)
+	}
+
+	if err := cs.currentUser.Save(); err != nil {
+		return xerrors.Errorf("err in currentUser.Save: %w", err)
+	}
+
+	err = cs.updateIndex(func(index *SearchIndex) error {
+		for _, block := range append(copied, dst) {
+			for entry := range block.FileMap {
+				if block == dst && entry != name {
+					continue
+				}
+
+				if err := index.add(block, entry); err != nil {
+					return xerrors.Errorf("err in index.add: %w", err)
+				}
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return xerrors.Errorf("err in updateIndex: %w", err)
 	}
 
-	return ret, nil
+	return nil
+}
+
+// copyEntry: copy entry name of src into dst with new hash name, blocks
+// created for folders are appended to copied
+func (cs *commandService) copyEntry(src *BlockINode, name string, dst *BlockINode, copied *[]*BlockINode) error {
+	header := src.FileMap[name]
+
+	hash, err := randHash()
+	if err != nil {
+		return xerrors.Errorf("err in randHash: %w", err)
+	}
+
+	dup := header
+	dup.HashFileName = hash
+	dup.CreatedTime = time.Now()
+	dup.ModifiedTime = dup.CreatedTime
+
+	if child, ok := childBlock(cs.currentUser, header); ok {
+		block, err := CreateBlock(dst, cs.currentUser.CurrentNodeID+1)
+		if err != nil {
+			return xerrors.Errorf("err in CreateBlock: %w", err)
+		}
+		cs.currentUser.CurrentNodeID++
+
+		if child.Usage != nil {
+			usage := *child.Usage
+			block.Usage = &usage
+		}
+		cs.currentUser.BlockMap[block.NodeID] = block
+		*copied = append(*copied, &block)
+
+		nodeid := block.NodeID
+		dup.DirNodeID = &nodeid
+
+		for entry := range child.FileMap {
+			if err := cs.copyEntry(&child, entry, &block, copied); err != nil {
+				return err
+			}
+		}
+	} else if err := copyContent(src, header, &dup); err != nil {
+		return xerrors.Errorf("err in copyContent: %w", err)
+	}
+
+	if err := dup.Save(dst.GetBlockPath()); err != nil {
+		return xerrors.Errorf("err in header.Save: %w", err)
+	}
+	dst.FileMap[name] = dup
+
+	return nil
+}
+
+// copyContent: dup share the blobs of header, content beside header is put
+// into blob store
+func copyContent(src *BlockINode, header FileHeader, dup *FileHeader) error {
+	if chunks := header.contentChunks(); len(chunks) > 0 {
+		return refChunks(src.blobStore(), chunks)
+	}
+
+	in, err := os.Open(header.GetContentPath(src.GetBlockPath()))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return xerrors.Errorf("err in os.Open: %w", err)
+	}
+	defer in.Close()
+
+	chunks, err := putChunks(src.blobStore(), in, CompressionNone)
+	if err != nil {
+		return xerrors.Errorf("err in putChunks: %w", err)
+	}
+	dup.setContent(chunks)
+
+	return nil
+}
+
+// travelEntry: folder containing entry at path and name of entry
+func (cs *commandService) travelEntry(filePath string) (*BlockINode, string, error) {
+	dir, name := path.Split(strings.TrimRight(strings.TrimSpace(filePath), "/"))
+	if name == "" || name == "." || name == ".." {
+		return nil, "", errorf(ErrInvalidName, "invalid path %s", filePath)
+	}
+
+	block, err := cs.travelFolder(dir)
+	if err != nil {
+		return nil, "", xerrors.Errorf("err in travelFolder: %w", err)
+	}
+
+	if _, ok := block.FileMap[name]; !ok {
+		return nil, "", errorf(ErrNotExist, "path %s not exist", filePath)
+	}
+
+	return block, name, nil
+}
+
+// isUnder: block id is ancestor or the same block of id
+func (cs *commandService) isUnder(id, ancestor uint64) bool {
+	for {
+		if id == ancestor {
+			return true
+		}
+
+		b, ok := cs.currentUser.BlockMap[id]
+		if !ok || id == b.PrevNodeID {
+			return false
+		}
+		id = b.PrevNodeID
+	}
+}
+
+// removeEntry: remove entry in block, blocks of folder are removed
+// recursively
+func (cs *commandService) removeEntry(block *BlockINode, name string) error {
+	header := block.FileMap[name]
+	usage := cs.entryUsage(header)
+
+	if err := cs.unindex(header); err != nil {
+		return xerrors.Errorf("err in unindex: %w", err)
+	}
+
+	if header.Type == Directory && header.DirNodeID != nil {
+		if err := cs.removeBlock(*header.DirNodeID); err != nil {
+			return xerrors.Errorf("err in removeBlock: %w", err)
+		}
+	}
+
+	if err := os.Remove(block.GetBlockPath() + "/" + header.HashFileName); err != nil {
+		return xerrors.Errorf("err in os.Remove: %w", err)
+	}
+
+	if header.Type == File {
+		if err := releaseContent(block, header); err != nil {
+			return xerrors.Errorf("err in releaseContent: %w", err)
+		}
+	}
+
+	delete(block.FileMap, name)
+
+	if err := cs.writeBlocks(block); err != nil {
+		return xerrors.Errorf("err in writeBlocks: %w", err)
+	}
+
+	if err := cs.adjustUsage(block.NodeID, usage.neg()); err != nil {
+		return xerrors.Errorf("err in adjustUsage: %w", err)
+	}
+
+	if err := cs.currentUser.Save(); err != nil {
+		return xerrors.Errorf("err in currentUser.Save: %w", err)
+	}
+
+	return nil
+}
+
+func (cs *commandService) removeBlock(id uint64) error {
+	block, ok := cs.currentUser.BlockMap[id]
+	if ok {
+		for _, header := range block.FileMap {
+			if header.Type == Directory && header.DirNodeID != nil {
+				if err := cs.removeBlock(*header.DirNodeID); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := releaseContent(&block, header); err != nil {
+				return xerrors.Errorf("err in releaseContent: %w", err)
+			}
+		}
+	}
+
+	dirBlock := BlockINode{UserPath: cs.currentUser.GetUserPath(), NodeID: id}
+	if err := os.RemoveAll(dirBlock.GetBlockPath()); err != nil {
+		return xerrors.Errorf("err in os.RemoveAll: %w", err)
+	}
+
+	delete(cs.currentUser.BlockMap, id)
+
+	return nil
+}
+
+// saveBlocks: write changed blocks back to user and disk
+func (cs *commandService) saveBlocks(blocks ...*BlockINode) error {
+	if err := cs.writeBlocks(blocks...); err != nil {
+		return err
+	}
+
+	if err := cs.currentUser.Save(); err != nil {
+		return xerrors.Errorf("err in currentUser.Save: %w", err)
+	}
+
+	return nil
+}
+
+// writeBlocks: blocks are written and kept in memory, the user is saved by
+// caller once every block of a change is written
+func (cs *commandService) writeBlocks(blocks ...*BlockINode) error {
+	for _, block := range blocks {
+		cs.currentUser.BlockMap[block.NodeID] = *block
+		if cs.currentBlock != nil && cs.currentBlock.NodeID == block.NodeID {
+			*cs.currentBlock = *block
+		}
+
+		if err := block.Save(); err != nil {
+			return xerrors.Errorf("err in block.Save: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Tree: folder root with entries under it down to depth, see BuildTree
+func (cs *commandService) Tree(root string, depth int) (TreeNode, error) {
+	header, err := cs.Stat(root)
+	if err != nil {
+		return TreeNode{}, xerrors.Errorf("err in Stat: %w", err)
+	}
+
+	if header.Type != Directory || header.DirNodeID == nil {
+		return TreeNode{}, errorf(ErrNotDir, "%s is not a directory", root)
+	}
+
+	block, ok := cs.currentUser.BlockMap[*header.DirNodeID]
+	if !ok {
+		return TreeNode{}, errorf(ErrNotExist, "path %s not exist", root)
+	}
+
+	children, err := BuildTree(cs.currentUser, &block, depth)
+	if err != nil {
+		return TreeNode{}, xerrors.Errorf("err in BuildTree: %w", err)
+	}
+
+	return TreeNode{Header: header, Children: children}, nil
 }
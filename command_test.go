@@ -1,7 +1,10 @@
 package vfsgo
 
 import (
+	"errors"
+	"io"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -28,6 +31,14 @@ import (
 //
 //     ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 
+// must: stop test at error of a fixture step
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
 func getCmdService() (ICommandService, error) {
 	root, err := getProjRoot()
 	if err != nil {
@@ -68,6 +79,17 @@ func TestRegister(t *testing.T) {
 	}
 }
 
+func TestRegisterDotName(t *testing.T) {
+	cmdService := NewCommandService(t.TempDir())
+
+	for _, name := range []string{BlobDirName, ".hidden"} {
+		if err := cmdService.Register(name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("user %s registered: %v", name, err)
+			return
+		}
+	}
+}
+
 func TestUse(t *testing.T) {
 	root, err := getProjRoot()
 	if err != nil {
@@ -643,3 +665,118 @@ func TestList(t *testing.T) {
 		return
 	}
 }
+
+func TestWriteReadFile(t *testing.T) {
+	cmdService := NewCommandService(t.TempDir())
+
+	if err := cmdService.Register("testWriteFile"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.Use("testWriteFile"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.CreateFile("testWriteFile", "desc"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.WriteFile("testWriteFile", strings.NewReader("content")); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	header, err := cmdService.Stat("/testWriteFile")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if header.Size != int64(len("content")) {
+		t.Error("header.Size != len(content)")
+		return
+	}
+
+	file, err := cmdService.ReadFile("testWriteFile")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer file.Close()
+
+	b, err := io.ReadAll(file)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if string(b) != "content" {
+		t.Error("read content not equal")
+		return
+	}
+}
+
+func TestListEntries(t *testing.T) {
+	cmdService := NewCommandService(t.TempDir())
+
+	if err := cmdService.Register("testListEntries"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.Use("testListEntries"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	for _, name := range []string{"b", "c", "a"} {
+		if err := cmdService.CreateFolder(name); err != nil {
+			t.Error(err.Error())
+			return
+		}
+	}
+
+	if err := cmdService.CreateFile("file", "desc"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	dirType := Directory
+	folders, err := cmdService.ListEntries(".", &dirType, nil, nil)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if len(folders) != 3 || folders[0].Name != "a" || folders[1].Name != "b" || folders[2].Name != "c" {
+		t.Error("folders not sorted by name")
+		return
+	}
+
+	sortField, sortOrder := SortByCreatedTime, DESC
+	folders, err = cmdService.ListEntries(".", &dirType, &sortField, &sortOrder)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if len(folders) != 3 || folders[0].Name != "a" || folders[1].Name != "c" || folders[2].Name != "b" {
+		t.Error("folders not sorted by created time desc")
+		return
+	}
+
+	fileType := File
+	files, err := cmdService.ListEntries(".", &fileType, nil, nil)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if len(files) != 1 || files[0].Name != "file" || files[0].Description != "desc" {
+		t.Error("files not match")
+		return
+	}
+}
@@ -0,0 +1,280 @@
+package vfsgo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+
+	// CompressedBlobSuffix: blob stored in chunked compressed format
+	CompressedBlobSuffix = ".z"
+
+	compressChunkSize = 64 << 10
+	compressMagic     = "VFZ1"
+	// trailer: table offset u64, chunk size u32, chunk count u32, size u64, magic
+	compressTrailerSize = 8 + 4 + 4 + 8 + len(compressMagic)
+)
+
+// Content: opened file content, read in order or at any offset
+type Content interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+}
+
+// validCompression: codec accepted by SetCompression, empty means inherit
+func validCompression(codec string) bool {
+	return codec == "" || codec == CompressionNone || codec == CompressionGzip
+}
+
+// writeChunked: compress r into w by chunks of compressChunkSize, each chunk
+// is a gzip stream of its own so it can be read alone, the table of
+// compressed chunk lengths and the trailer follow the chunks
+func writeChunked(w io.Writer, r io.Reader) error {
+	lengths := []uint32{}
+	buf := make([]byte, compressChunkSize)
+	var out bytes.Buffer
+	var size, offset uint64
+
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			out.Reset()
+			zw := gzip.NewWriter(&out)
+			if _, err := zw.Write(buf[:n]); err != nil {
+				return xerrors.Errorf("error in zw.Write: %w", err)
+			}
+
+			if err := zw.Close(); err != nil {
+				return xerrors.Errorf("error in zw.Close: %w", err)
+			}
+
+			if _, err := w.Write(out.Bytes()); err != nil {
+				return xerrors.Errorf("error in w.Write: %w", err)
+			}
+
+			lengths = append(lengths, uint32(out.Len()))
+			size += uint64(n)
+			offset += uint64(out.Len())
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return xerrors.Errorf("error in io.ReadFull: %w", err)
+		}
+	}
+
+	table := make([]byte, 4*len(lengths)+compressTrailerSize)
+	for i, l := range lengths {
+		binary.BigEndian.PutUint32(table[4*i:], l)
+	}
+
+	trailer := table[4*len(lengths):]
+	binary.BigEndian.PutUint64(trailer, offset)
+	binary.BigEndian.PutUint32(trailer[8:], compressChunkSize)
+	binary.BigEndian.PutUint32(trailer[12:], uint32(len(lengths)))
+	binary.BigEndian.PutUint64(trailer[16:], size)
+	copy(trailer[24:], compressMagic)
+
+	if _, err := w.Write(table); err != nil {
+		return xerrors.Errorf("error in w.Write: %w", err)
+	}
+
+	return nil
+}
+
+// chunkReader: random access to content in chunked format, the last read
+// chunk is kept for reads nearby, mu guards the kept chunk
+type chunkReader struct {
+	file      Content
+	chunkSize int64
+	length    int64
+	offsets   []int64
+
+	mu     sync.Mutex
+	cached int
+	data   []byte
+	pos    int64
+}
+
+func openChunked(file Content, fileSize int64) (*chunkReader, error) {
+	trailer := make([]byte, compressTrailerSize)
+	if fileSize < int64(len(trailer)) {
+		return nil, NewError(ErrCorrupt, "compressed blob too short")
+	}
+
+	if _, err := file.ReadAt(trailer, fileSize-int64(len(trailer))); err != nil {
+		return nil, xerrors.Errorf("error in file.ReadAt: %w", err)
+	}
+
+	if string(trailer[24:]) != compressMagic {
+		return nil, NewError(ErrCorrupt, "compressed blob has bad magic")
+	}
+
+	tableOffset := int64(binary.BigEndian.Uint64(trailer))
+	count := int64(binary.BigEndian.Uint32(trailer[12:]))
+	if tableOffset+4*count+int64(len(trailer)) != fileSize {
+		return nil, NewError(ErrCorrupt, "compressed blob has bad table")
+	}
+
+	table := make([]byte, 4*count)
+	if _, err := file.ReadAt(table, tableOffset); err != nil {
+		return nil, xerrors.Errorf("error in file.ReadAt: %w", err)
+	}
+
+	offsets := make([]int64, count+1)
+	for i := int64(0); i < count; i++ {
+		offsets[i+1] = offsets[i] + int64(binary.BigEndian.Uint32(table[4*i:]))
+	}
+
+	return &chunkReader{
+		file:      file,
+		chunkSize: int64(binary.BigEndian.Uint32(trailer[8:])),
+		length:    int64(binary.BigEndian.Uint64(trailer[16:])),
+		offsets:   offsets,
+		cached:    -1,
+	}, nil
+}
+
+func (c *chunkReader) chunk(i int) ([]byte, error) {
+	c.mu.Lock()
+	cached, data := c.cached, c.data
+	c.mu.Unlock()
+
+	if i == cached {
+		return data, nil
+	}
+
+	if i+1 >= len(c.offsets) {
+		return nil, NewError(ErrCorrupt, "chunk out of compressed blob")
+	}
+
+	section := io.NewSectionReader(c.file, c.offsets[i], c.offsets[i+1]-c.offsets[i])
+	zr, err := gzip.NewReader(section)
+	if err != nil {
+		return nil, errorf(ErrCorrupt, "chunk %d of compressed blob: %s", i, err.Error())
+	}
+	defer zr.Close()
+
+	data, err = io.ReadAll(zr)
+	if err != nil {
+		return nil, errorf(ErrCorrupt, "chunk %d of compressed blob: %s", i, err.Error())
+	}
+
+	c.mu.Lock()
+	c.cached, c.data = i, data
+	c.mu.Unlock()
+
+	return data, nil
+}
+
+func (c *chunkReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		if off >= c.length {
+			return n, io.EOF
+		}
+
+		data, err := c.chunk(int(off / c.chunkSize))
+		if err != nil {
+			return n, err
+		}
+
+		start := off % c.chunkSize
+		if start >= int64(len(data)) {
+			return n, NewError(ErrCorrupt, "chunk shorter than expected")
+		}
+
+		copied := copy(p[n:], data[start:])
+		n += copied
+		off += int64(copied)
+	}
+
+	return n, nil
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	n, err := c.ReadAt(p, c.pos)
+	c.pos += int64(n)
+
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (c *chunkReader) size() int64 {
+	return c.length
+}
+
+func (c *chunkReader) Close() error {
+	return c.file.Close()
+}
+
+// SetCompression: codec of content written under folder at dirPath, empty
+// dirPath set the default of current user. Empty codec inherit from parent
+// folder, existing content is kept in the form it was stored
+func (cs *commandService) SetCompression(dirPath, codec string) error {
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if cs.currentUser == nil {
+		return NewError(ErrNoUser, "current user is nil")
+	}
+
+	if !validCompression(codec) {
+		return errorf(ErrInvalidName, "unknown compression %s", codec)
+	}
+
+	if dirPath == "" {
+		cs.currentUser.Compression = codec
+		if err := cs.currentUser.Save(); err != nil {
+			return xerrors.Errorf("err in currentUser.Save: %w", err)
+		}
+		return nil
+	}
+
+	block, err := cs.travelFolder(dirPath)
+	if err != nil {
+		return xerrors.Errorf("err in travelFolder: %w", err)
+	}
+
+	block.Compression = codec
+	if err := cs.saveBlocks(block); err != nil {
+		return xerrors.Errorf("err in saveBlocks: %w", err)
+	}
+
+	return nil
+}
+
+// compressionOf: codec set on block or the nearest folder above it, else the
+// default of current user
+func (cs *commandService) compressionOf(block *BlockINode) string {
+	for b, ok := *block, true; ok; b, ok = cs.currentUser.BlockMap[b.PrevNodeID] {
+		if b.Compression != "" {
+			return b.Compression
+		}
+		if b.NodeID == b.PrevNodeID {
+			break
+		}
+	}
+
+	if cs.currentUser.Compression != "" {
+		return cs.currentUser.Compression
+	}
+
+	return CompressionNone
+}
@@ -0,0 +1,171 @@
+package vfsgo
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestChunkedBlob(t *testing.T) {
+	store := NewBlobStore(t.TempDir())
+
+	// spans several chunks and compresses well
+	data := bytes.Repeat([]byte("0123456789abcdef"), compressChunkSize/16*3+100)
+
+	blob, err := store.Put(bytes.NewReader(data), CompressionGzip)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if blob.Compression != CompressionGzip || blob.Size != int64(len(data)) || blob.Stored >= blob.Size {
+		t.Errorf("unexpected blob %+v", blob)
+		return
+	}
+
+	content, err := store.Open(blob.Hash)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer content.Close()
+
+	all, err := ioutil.ReadAll(content)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if !bytes.Equal(all, data) {
+		t.Error("content changed by compression")
+		return
+	}
+
+	// read across the boundary of first and second chunk
+	off := int64(compressChunkSize - 10)
+	part := make([]byte, 20)
+	if _, err := content.ReadAt(part, off); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if !bytes.Equal(part, data[off:off+20]) {
+		t.Error("unexpected content read across chunks")
+		return
+	}
+
+	if n, err := content.ReadAt(part, int64(len(data))-5); n != 5 || err != io.EOF {
+		t.Errorf("unexpected read at tail %d %v", n, err)
+		return
+	}
+
+	if err := store.Verify(blob.Hash); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// incompressible content is kept as is
+	raw, err := store.Put(strings.NewReader("x"), CompressionGzip)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if raw.Compression != CompressionNone || raw.Stored != 1 {
+		t.Errorf("unexpected blob %+v", raw)
+		return
+	}
+}
+
+func TestChunkedBlobConcurrentRead(t *testing.T) {
+	store := NewBlobStore(t.TempDir())
+
+	data := make([]byte, compressChunkSize*8)
+	for i := range data {
+		data[i] = byte(i / compressChunkSize)
+	}
+
+	blob, err := store.Put(bytes.NewReader(data), CompressionGzip)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	content, err := store.Open(blob.Hash)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer content.Close()
+
+	readConcurrently(t, content, data)
+}
+
+func TestSetCompression(t *testing.T) {
+	cmdService := newFindTree(t)
+
+	if err := cmdService.SetCompression("", "zip"); err == nil {
+		t.Error("unknown codec should fail")
+		return
+	}
+
+	if err := cmdService.SetCompression("a", CompressionGzip); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.SetCompression("a/b", CompressionNone); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.SetUsageCache(true); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	for dir, want := range map[string]string{"/a": CompressionGzip, "/a/b": CompressionNone, "/c": CompressionNone} {
+		// content differ by folder, the same content would share one blob
+		text := strings.Repeat("compress "+dir+" ", 1000)
+		must(t, cmdService.ChangeFolder(dir))
+		must(t, cmdService.CreateFile("big.txt", ""))
+		must(t, cmdService.WriteFile("big.txt", strings.NewReader(text)))
+
+		header := cmdService.GetCurrentBlock().FileMap["big.txt"]
+		if header.Compression != want || header.Size != int64(len(text)) {
+			t.Errorf("unexpected %s in %s", header.Compression, dir)
+			return
+		}
+
+		r, err := cmdService.ReadFile("big.txt")
+		if err != nil {
+			t.Error(err.Error())
+			return
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Error(err.Error())
+			return
+		}
+
+		if string(got) != text {
+			t.Errorf("unexpected content in %s", dir)
+			return
+		}
+	}
+
+	du, err := cmdService.Usage("/a", 0)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// gzip in a, none in a/b
+	if du.Bytes <= du.Stored || du.Stored <= du.Bytes/2 {
+		t.Errorf("unexpected usage %+v", du.BlockUsage)
+		return
+	}
+}
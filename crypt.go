@@ -0,0 +1,999 @@
+package vfsgo
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/xerrors"
+)
+
+const (
+	UserKeyFileName = ".userKey"
+
+	// EncryptFull: inodes and contents are encrypted
+	EncryptFull = "full"
+	// EncryptNames: names and descriptions in inodes are encrypted and
+	// entries keyed by keyed hash of names, the rest is kept in plain
+	EncryptNames = "names"
+
+	// sealMagic: prefix of encrypted inode file
+	sealMagic = "VFE1"
+	// sealSegmentSize: content is encrypted by segments so it can be read
+	// at any offset
+	sealSegmentSize = 64 << 10
+	dataKeySize     = 32
+
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// sealer: keys of an unlocked user, AES-GCM for inodes and contents and a
+// HMAC key for names on disk, both derived from the data key
+type sealer struct {
+	aead    cipher.AEAD
+	nameKey []byte
+	// namesOnly: user is encrypted in EncryptNames mode
+	namesOnly bool
+}
+
+func newSealer(dataKey []byte) (*sealer, error) {
+	encKey := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, dataKey, nil, []byte("vfsgo content")), encKey); err != nil {
+		return nil, xerrors.Errorf("error in hkdf: %w", err)
+	}
+
+	nameKey := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, dataKey, nil, []byte("vfsgo name")), nameKey); err != nil {
+		return nil, xerrors.Errorf("error in hkdf: %w", err)
+	}
+
+	aead, err := newGCM(encKey)
+	if err != nil {
+		return nil, xerrors.Errorf("error in newGCM: %w", err)
+	}
+
+	return &sealer{aead: aead, nameKey: nameKey}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal: random nonce followed by ciphertext of plain
+func seal(aead cipher.AEAD, plain, ad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, xerrors.Errorf("error in rand.Read: %w", err)
+	}
+
+	return aead.Seal(nonce, nonce, plain, ad), nil
+}
+
+func unseal(aead cipher.AEAD, buf, ad []byte) ([]byte, error) {
+	if len(buf) < aead.NonceSize()+aead.Overhead() {
+		return nil, NewError(ErrCorrupt, "encrypted data too short")
+	}
+
+	return aead.Open(nil, buf[:aead.NonceSize()], buf[aead.NonceSize():], ad)
+}
+
+// name: keyed hash of s, used as name on disk in place of s
+func (s *sealer) name(v string) string {
+	mac := hmac.New(sha256.New, s.nameKey)
+	mac.Write([]byte(v))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// keyring: sealers of unlocked users by user path. A user locked in this
+// process is kept with a nil sealer so nothing of it is written in plain
+var keyring = struct {
+	sync.RWMutex
+	keys map[string]*sealer
+}{keys: make(map[string]*sealer)}
+
+func setKey(userPath string, s *sealer) {
+	keyring.Lock()
+	defer keyring.Unlock()
+
+	keyring.keys[filepath.Clean(userPath)] = s
+}
+
+// dropKey: forget user path, it is neither encrypted nor unlocked here
+func dropKey(userPath string) {
+	keyring.Lock()
+	defer keyring.Unlock()
+
+	delete(keyring.keys, filepath.Clean(userPath))
+}
+
+// keyOf: sealer of the user path is under, ok is false for user never
+// encrypted nor unlocked in this process
+func keyOf(path string) (*sealer, bool) {
+	keyring.RLock()
+	defer keyring.RUnlock()
+
+	if len(keyring.keys) == 0 {
+		return nil, false
+	}
+
+	for p := filepath.Clean(path); ; p = filepath.Dir(p) {
+		if s, ok := keyring.keys[p]; ok {
+			return s, true
+		}
+
+		if p == filepath.Dir(p) {
+			return nil, false
+		}
+	}
+}
+
+// readINode: inode file at path, decrypted when its user is encrypted in
+// full mode
+func readINode(path string) ([]byte, error) {
+	return readFile(path, false)
+}
+
+// readSealed: file at path, decrypted when its user is encrypted in any mode
+func readSealed(path string) ([]byte, error) {
+	return readFile(path, true)
+}
+
+func readFile(path string, always bool) ([]byte, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := fileSealer(path, always)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err = openINode(buf, s)
+	if errors.Is(err, ErrLocked) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errorf(ErrCorrupt, "%s %s", filepath.Base(path), err.Error())
+	}
+
+	return buf, nil
+}
+
+// writeINode: write inode file at path, encrypted when its user is in full
+// mode
+func writeINode(path string, buf []byte) error {
+	return writeFile(path, buf, false)
+}
+
+// writeSealed: write file at path, encrypted when its user is in any mode
+func writeSealed(path string, buf []byte) error {
+	return writeFile(path, buf, true)
+}
+
+func writeFile(path string, buf []byte, always bool) error {
+	s, err := fileSealer(path, always)
+	if err != nil {
+		return err
+	}
+
+	if buf, err = sealINode(buf, s); err != nil {
+		return xerrors.Errorf("error in sealINode: %w", err)
+	}
+
+	// written whole beside path then renamed, a torn write never replaces
+	// an inode
+	return writeTemp(path, func(w io.Writer) error {
+		_, err := w.Write(buf)
+		return err
+	})
+}
+
+// fileSealer: sealer the whole file at path is encrypted by, nil if it is
+// kept in plain
+func fileSealer(path string, always bool) (*sealer, error) {
+	s, known := keyOf(path)
+	if known && s == nil {
+		return nil, NewError(ErrLocked, "user is encrypted, unlock it first")
+	}
+
+	if s != nil && s.namesOnly && !always {
+		return nil, nil
+	}
+
+	return s, nil
+}
+
+// namesSealer: sealer of names in inodes at path, nil unless its user is
+// encrypted in names mode
+func namesSealer(path string) *sealer {
+	if s, _ := keyOf(path); s != nil && s.namesOnly {
+		return s
+	}
+
+	return nil
+}
+
+// hideHeader: header with name and description encrypted
+func (s *sealer) hideHeader(h FileHeader) (FileHeader, error) {
+	name, err := seal(s.aead, []byte(h.Name), []byte("name"))
+	if err != nil {
+		return FileHeader{}, xerrors.Errorf("error in seal: %w", err)
+	}
+
+	desc, err := seal(s.aead, []byte(h.Description), []byte("description"))
+	if err != nil {
+		return FileHeader{}, xerrors.Errorf("error in seal: %w", err)
+	}
+
+	h.Name = base64.RawURLEncoding.EncodeToString(name)
+	h.Description = base64.RawURLEncoding.EncodeToString(desc)
+	return h, nil
+}
+
+// showHeader: header hidden by hideHeader in plain
+func (s *sealer) showHeader(h FileHeader) (FileHeader, error) {
+	for _, field := range []struct {
+		v  *string
+		ad string
+	}{{&h.Name, "name"}, {&h.Description, "description"}} {
+		buf, err := base64.RawURLEncoding.DecodeString(*field.v)
+		if err != nil {
+			return FileHeader{}, errorf(ErrCorrupt, "%s of entry is not encrypted", field.ad)
+		}
+
+		plain, err := unseal(s.aead, buf, []byte(field.ad))
+		if err != nil {
+			return FileHeader{}, errorf(ErrCorrupt, "%s of entry can not be decrypted", field.ad)
+		}
+		*field.v = string(plain)
+	}
+
+	return h, nil
+}
+
+// hideNames: fileMap keyed by keyed hash of names with headers hidden
+func (s *sealer) hideNames(fileMap map[string]FileHeader) (map[string]FileHeader, error) {
+	hidden := make(map[string]FileHeader, len(fileMap))
+	for name, header := range fileMap {
+		h, err := s.hideHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		hidden[s.name(name)] = h
+	}
+
+	return hidden, nil
+}
+
+// showNames: fileMap hidden by hideNames keyed by names again, an entry not
+// under the keyed hash of its name is corrupted
+func (s *sealer) showNames(fileMap map[string]FileHeader) (map[string]FileHeader, error) {
+	shown := make(map[string]FileHeader, len(fileMap))
+	for key, header := range fileMap {
+		h, err := s.showHeader(header)
+		if err != nil {
+			return nil, err
+		}
+
+		if s.name(h.Name) != key {
+			return nil, NewError(ErrCorrupt, "entry is not under its name")
+		}
+		shown[h.Name] = h
+	}
+
+	return shown, nil
+}
+
+// openINode: plain inode of buf, encrypted one is opened by s
+func openINode(buf []byte, s *sealer) ([]byte, error) {
+	sealed := bytes.HasPrefix(buf, []byte(sealMagic))
+
+	switch {
+	case s != nil && sealed:
+		plain, err := unseal(s.aead, buf[len(sealMagic):], []byte(sealMagic))
+		if err != nil {
+			return nil, xerrors.New("can not be decrypted")
+		}
+		return plain, nil
+	case s != nil:
+		return nil, xerrors.New("is not encrypted")
+	case sealed:
+		return nil, NewError(ErrLocked, "user is encrypted, unlock it first")
+	}
+
+	return buf, nil
+}
+
+// sealINode: buf encrypted by s, as is if s is nil
+func sealINode(buf []byte, s *sealer) ([]byte, error) {
+	if s == nil {
+		return buf, nil
+	}
+
+	sealed, err := seal(s.aead, buf, []byte(sealMagic))
+	if err != nil {
+		return nil, xerrors.Errorf("error in seal: %w", err)
+	}
+
+	return append([]byte(sealMagic), sealed...), nil
+}
+
+// writeSegments: encrypt r into w by segments, each bound to its index and
+// whether it is the last one so segments can not be reordered or cut
+func writeSegments(w io.Writer, r io.Reader, aead cipher.AEAD) error {
+	buf := make([]byte, sealSegmentSize)
+	next := make([]byte, sealSegmentSize)
+
+	n, err := io.ReadFull(r, buf)
+	for index := uint64(0); ; index++ {
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return xerrors.Errorf("error in io.ReadFull: %w", err)
+		}
+
+		m, nextErr := 0, err
+		if err == nil {
+			m, nextErr = io.ReadFull(r, next)
+		}
+		last := m == 0 && nextErr != nil
+
+		sealed, err := seal(aead, buf[:n], segmentAD(index, last))
+		if err != nil {
+			return xerrors.Errorf("error in seal: %w", err)
+		}
+
+		if _, err := w.Write(sealed); err != nil {
+			return xerrors.Errorf("error in w.Write: %w", err)
+		}
+
+		if last {
+			if nextErr != io.EOF && nextErr != io.ErrUnexpectedEOF {
+				return xerrors.Errorf("error in io.ReadFull: %w", nextErr)
+			}
+			return nil
+		}
+
+		buf, next, n, err = next, buf, m, nextErr
+	}
+}
+
+func segmentAD(index uint64, last bool) []byte {
+	ad := make([]byte, 9)
+	binary.BigEndian.PutUint64(ad, index)
+	if last {
+		ad[8] = 1
+	}
+
+	return ad
+}
+
+// sealedReader: random access to content encrypted by writeSegments, the
+// last read segment is kept for reads nearby, mu guards the kept segment
+type sealedReader struct {
+	file     *os.File
+	aead     cipher.AEAD
+	length   int64
+	segments int64
+
+	mu     sync.Mutex
+	cached int64
+	data   []byte
+	pos    int64
+}
+
+func openSealed(file *os.File, aead cipher.AEAD) (*sealedReader, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, xerrors.Errorf("error in file.Stat: %w", err)
+	}
+
+	overhead := int64(aead.NonceSize() + aead.Overhead())
+	full := sealSegmentSize + overhead
+	segments := (info.Size() + full - 1) / full
+	if segments == 0 || info.Size()-(segments-1)*full < overhead {
+		return nil, NewError(ErrCorrupt, "encrypted blob has bad size")
+	}
+
+	return &sealedReader{
+		file:     file,
+		aead:     aead,
+		length:   info.Size() - segments*overhead,
+		segments: segments,
+		cached:   -1,
+	}, nil
+}
+
+func (r *sealedReader) segment(i int64) ([]byte, error) {
+	r.mu.Lock()
+	cached, data := r.cached, r.data
+	r.mu.Unlock()
+
+	if i == cached {
+		return data, nil
+	}
+
+	overhead := int64(r.aead.NonceSize() + r.aead.Overhead())
+	full := sealSegmentSize + overhead
+	length := full
+	if i == r.segments-1 {
+		length = r.length - i*sealSegmentSize + overhead
+	}
+
+	buf := make([]byte, length)
+	if _, err := r.file.ReadAt(buf, i*full); err != nil {
+		return nil, xerrors.Errorf("error in file.ReadAt: %w", err)
+	}
+
+	data, err := unseal(r.aead, buf, segmentAD(uint64(i), i == r.segments-1))
+	if err != nil {
+		return nil, errorf(ErrCorrupt, "segment %d of encrypted blob can not be decrypted", i)
+	}
+
+	r.mu.Lock()
+	r.cached, r.data = i, data
+	r.mu.Unlock()
+
+	return data, nil
+}
+
+func (r *sealedReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		if off >= r.length {
+			return n, io.EOF
+		}
+
+		data, err := r.segment(off / sealSegmentSize)
+		if err != nil {
+			return n, err
+		}
+
+		copied := copy(p[n:], data[off%sealSegmentSize:])
+		n += copied
+		off += int64(copied)
+	}
+
+	return n, nil
+}
+
+func (r *sealedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *sealedReader) size() int64 {
+	return r.length
+}
+
+func (r *sealedReader) Close() error {
+	return r.file.Close()
+}
+
+// userKey: data key of user wrapped by a key derived from its passphrase,
+// kept beside the user inode
+type userKey struct {
+	Mode string `json:"mode"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt []byte `json:"salt"`
+	Key  []byte `json:"key"`
+}
+
+func newUserKey(passphrase string, dataKey []byte, mode string) (userKey, error) {
+	key := userKey{Mode: mode, N: scryptN, R: scryptR, P: scryptP, Salt: make([]byte, 16)}
+	if _, err := rand.Read(key.Salt); err != nil {
+		return userKey{}, xerrors.Errorf("error in rand.Read: %w", err)
+	}
+
+	aead, err := key.kek(passphrase)
+	if err != nil {
+		return userKey{}, xerrors.Errorf("error in kek: %w", err)
+	}
+
+	key.Key, err = seal(aead, dataKey, nil)
+	if err != nil {
+		return userKey{}, xerrors.Errorf("error in seal: %w", err)
+	}
+
+	return key, nil
+}
+
+// kek: cipher of the key derived from passphrase
+func (k *userKey) kek(passphrase string) (cipher.AEAD, error) {
+	kek, err := scrypt.Key([]byte(passphrase), k.Salt, k.N, k.R, k.P, 32)
+	if err != nil {
+		return nil, xerrors.Errorf("error in scrypt.Key: %w", err)
+	}
+
+	return newGCM(kek)
+}
+
+// unwrap: sealer of data key, ErrWrongKey if passphrase is not the one it
+// is wrapped by
+func (k *userKey) unwrap(passphrase string) (*sealer, error) {
+	aead, err := k.kek(passphrase)
+	if err != nil {
+		return nil, xerrors.Errorf("error in kek: %w", err)
+	}
+
+	dataKey, err := unseal(aead, k.Key, nil)
+	if err != nil || len(dataKey) != dataKeySize {
+		return nil, NewError(ErrWrongKey, "wrong passphrase")
+	}
+
+	s, err := newSealer(dataKey)
+	if err != nil {
+		return nil, xerrors.Errorf("error in newSealer: %w", err)
+	}
+	s.namesOnly = k.Mode == EncryptNames
+
+	return s, nil
+}
+
+func (k *userKey) save(userPath string) error {
+	buf, err := json.Marshal(k)
+	if err != nil {
+		return xerrors.Errorf("error in json.Marshal: %w", err)
+	}
+
+	err = writeTemp(filepath.Join(userPath, UserKeyFileName), func(w io.Writer) error {
+		_, err := w.Write(buf)
+		return err
+	})
+	if err != nil {
+		return xerrors.Errorf("error in writeTemp: %w", err)
+	}
+
+	return nil
+}
+
+// loadUserKey: key of user at userPath, ErrNotExist if user is not encrypted
+func loadUserKey(userPath string) (userKey, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(userPath, UserKeyFileName))
+	if os.IsNotExist(err) {
+		return userKey{}, NewError(ErrNotExist, "user is not encrypted")
+	}
+	if err != nil {
+		return userKey{}, xerrors.Errorf("error in ioutil.ReadFile: %w", err)
+	}
+
+	key := userKey{Mode: EncryptFull}
+	if err := json.Unmarshal(buf, &key); err != nil {
+		return userKey{}, errorf(ErrCorrupt, "user key: %s", err.Error())
+	}
+
+	return key, nil
+}
+
+func newDataKey() ([]byte, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, xerrors.Errorf("error in rand.Read: %w", err)
+	}
+
+	return key, nil
+}
+
+// Encrypt: encrypt current user with a new data key wrapped by passphrase,
+// in full mode contents leave the store shared by users
+func (cs *commandService) Encrypt(passphrase, mode string) error {
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if cs.currentUser == nil {
+		return NewError(ErrNoUser, "current user is nil")
+	}
+
+	if passphrase == "" {
+		return NewError(ErrInvalidName, "empty passphrase")
+	}
+
+	if mode != EncryptFull && mode != EncryptNames {
+		return errorf(ErrInvalidName, "unknown encryption mode %s", mode)
+	}
+
+	userPath := cs.currentUser.GetUserPath()
+	if _, err := loadUserKey(userPath); err == nil {
+		return NewError(ErrExist, "user already encrypted")
+	} else if !errors.Is(err, ErrNotExist) {
+		return xerrors.Errorf("err in loadUserKey: %w", err)
+	}
+
+	if err := cs.rekey(passphrase, mode); err != nil {
+		return xerrors.Errorf("err in rekey: %w", err)
+	}
+
+	return nil
+}
+
+// RotateKey: reencrypt current user with a new data key wrapped by
+// newPassphrase, passphrase must unwrap the current one
+func (cs *commandService) RotateKey(passphrase, newPassphrase string) error {
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if cs.currentUser == nil {
+		return NewError(ErrNoUser, "current user is nil")
+	}
+
+	if newPassphrase == "" {
+		return NewError(ErrInvalidName, "empty passphrase")
+	}
+
+	key, err := loadUserKey(cs.currentUser.GetUserPath())
+	if err != nil {
+		return xerrors.Errorf("err in loadUserKey: %w", err)
+	}
+
+	if _, err := key.unwrap(passphrase); err != nil {
+		return xerrors.Errorf("err in unwrap: %w", err)
+	}
+
+	if err := cs.rekey(newPassphrase, key.Mode); err != nil {
+		return xerrors.Errorf("err in rekey: %w", err)
+	}
+
+	return nil
+}
+
+// rekeyNewPrefix, rekeyOldPrefix: dirs in root beside user name while it is
+// saved under a new key. The new user is complete once its key is saved,
+// then it replaces the user and the old one is dropped
+const (
+	rekeyNewPrefix = ".rekey-new."
+	rekeyOldPrefix = ".rekey-old."
+)
+
+// rekey: seal current user by a new data key wrapped by passphrase. The
+// user is saved again with the new key into a new dir, the user in use is
+// kept as it is until the new one is complete, see recoverRekey
+func (cs *commandService) rekey(passphrase, mode string) error {
+	root, name := cs.currentUser.RootPath, cs.currentUser.Name
+	if err := recoverRekey(root, name); err != nil {
+		return xerrors.Errorf("err in recoverRekey: %w", err)
+	}
+
+	dataKey, err := newDataKey()
+	if err != nil {
+		return xerrors.Errorf("err in newDataKey: %w", err)
+	}
+
+	key, err := newUserKey(passphrase, dataKey, mode)
+	if err != nil {
+		return xerrors.Errorf("err in newUserKey: %w", err)
+	}
+
+	s, err := newSealer(dataKey)
+	if err != nil {
+		return xerrors.Errorf("err in newSealer: %w", err)
+	}
+	s.namesOnly = mode == EncryptNames
+
+	index := cs.currentUser.index
+	userPath := cs.currentUser.GetUserPath()
+	if _, err := os.Stat(filepath.Join(userPath, SearchIndexFileName)); index == nil && err == nil {
+		if index, err = LoadSearchIndex(cs.currentUser); err != nil {
+			return xerrors.Errorf("err in LoadSearchIndex: %w", err)
+		}
+	}
+
+	next := *cs.currentUser
+	next.Name = rekeyNewPrefix + name
+	next.index = nil
+	newPath := next.GetUserPath()
+
+	if err := os.Mkdir(newPath, 0755); err != nil {
+		return xerrors.Errorf("err in os.Mkdir: %w", err)
+	}
+	setKey(newPath, s)
+	defer dropKey(newPath)
+
+	if err := cs.saveUserAs(&next, mode == EncryptFull, index); err != nil {
+		os.RemoveAll(newPath)
+		return err
+	}
+
+	// new user is complete with its key, from here it replaces the user
+	if err := key.save(newPath); err != nil {
+		os.RemoveAll(newPath)
+		return xerrors.Errorf("err in key.save: %w", err)
+	}
+
+	setKey(userPath, s)
+	if err := recoverRekey(root, name); err != nil {
+		return xerrors.Errorf("err in recoverRekey: %w", err)
+	}
+
+	// cached user is shared by services, it is updated in place
+	user, err := GetUser(root, name)
+	if err != nil {
+		return xerrors.Errorf("err in GetUser: %w", err)
+	}
+	*cs.currentUser = user
+
+	b := user.BlockMap[cs.currentBlock.NodeID]
+	cs.currentBlock = &b
+
+	return nil
+}
+
+// saveUserAs: save every header, block and index of current user as user
+// next, contents are put into the store of next when moved
+func (cs *commandService) saveUserAs(next *User, moved bool, index *SearchIndex) error {
+	next.BlockMap = make(map[uint64]BlockINode, len(cs.currentUser.BlockMap))
+
+	for id := range cs.currentUser.BlockMap {
+		old := cs.currentUser.BlockMap[id]
+
+		block := old
+		block.UserPath = next.GetUserPath()
+		block.FileMap = make(map[string]FileHeader, len(old.FileMap))
+
+		if err := os.Mkdir(block.GetBlockPath(), 0755); err != nil {
+			return xerrors.Errorf("err in os.Mkdir: %w", err)
+		}
+
+		for name, header := range old.FileMap {
+			if header.Type == File {
+				var err error
+				if header, err = copyContentTo(&old, &block, name, moved); err != nil {
+					return err
+				}
+			}
+
+			if err := header.Save(block.GetBlockPath()); err != nil {
+				return xerrors.Errorf("err in header.Save: %w", err)
+			}
+			block.FileMap[name] = header
+		}
+
+		if err := block.Save(); err != nil {
+			return xerrors.Errorf("err in block.Save: %w", err)
+		}
+		next.BlockMap[id] = block
+	}
+
+	if err := next.Save(); err != nil {
+		return xerrors.Errorf("err in next.Save: %w", err)
+	}
+
+	if index == nil {
+		return nil
+	}
+
+	saved := *index
+	saved.path = next.GetUserPath() + "/" + SearchIndexFileName
+	if err := saved.Save(); err != nil {
+		return xerrors.Errorf("err in index.Save: %w", err)
+	}
+
+	return nil
+}
+
+// copyContentTo: header of file name of block from with its content put
+// into store of block to when moved, else content beside header is copied
+// and blobs are shared
+func copyContentTo(from, to *BlockINode, name string, moved bool) (FileHeader, error) {
+	header := from.FileMap[name]
+	legacy := header.GetContentPath(from.GetBlockPath())
+
+	if len(header.contentChunks()) == 0 {
+		if _, err := os.Stat(legacy); os.IsNotExist(err) {
+			return header, nil
+		}
+	}
+
+	if !moved {
+		if len(header.contentChunks()) > 0 {
+			return header, nil
+		}
+
+		err := writeTemp(header.GetContentPath(to.GetBlockPath()), func(w io.Writer) error {
+			in, err := os.Open(legacy)
+			if err != nil {
+				return xerrors.Errorf("error in os.Open: %w", err)
+			}
+			defer in.Close()
+
+			_, err = io.Copy(w, in)
+			return err
+		})
+		if err != nil {
+			return FileHeader{}, xerrors.Errorf("error in writeTemp: %w", err)
+		}
+		return header, nil
+	}
+
+	in, err := OpenFileContent(from, name)
+	if err != nil {
+		return FileHeader{}, xerrors.Errorf("error in OpenFileContent: %w", err)
+	}
+	defer in.Close()
+
+	codec := header.Compression
+	if codec == "" {
+		codec = CompressionNone
+	}
+
+	chunks, err := putChunks(to.blobStore(), in, codec)
+	if err != nil {
+		return FileHeader{}, xerrors.Errorf("error in putChunks: %w", err)
+	}
+
+	header.setContent(chunks)
+	return header, nil
+}
+
+// recoverRekey: finish or drop a rekey of user name in root. A new user
+// complete with its key replaces the user, an incomplete one is removed.
+// The replaced user is removed, refs it took of the shared store are
+// released when the new user keeps contents in its own store
+func recoverRekey(root, name string) error {
+	userPath := filepath.Join(root, name)
+	newPath := filepath.Join(root, rekeyNewPrefix+name)
+	oldPath := filepath.Join(root, rekeyOldPrefix+name)
+
+	if _, err := os.Stat(newPath); err == nil {
+		if _, err := os.Stat(filepath.Join(newPath, UserKeyFileName)); err != nil {
+			if err := os.RemoveAll(newPath); err != nil {
+				return xerrors.Errorf("error in os.RemoveAll: %w", err)
+			}
+			return nil
+		}
+
+		if _, err := os.Stat(userPath); err == nil {
+			if err := os.Rename(userPath, oldPath); err != nil {
+				return xerrors.Errorf("error in os.Rename: %w", err)
+			}
+		}
+
+		if err := os.Rename(newPath, userPath); err != nil {
+			return xerrors.Errorf("error in os.Rename: %w", err)
+		}
+	}
+
+	if _, err := os.Stat(oldPath); err != nil {
+		return nil
+	}
+
+	key, err := loadUserKey(userPath)
+	if err != nil {
+		return xerrors.Errorf("error in loadUserKey: %w", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(oldPath, UserKeyFileName)); os.IsNotExist(err) && key.Mode == EncryptFull {
+		if err := releaseUserBlobs(NewBlobStore(root), oldPath); err != nil {
+			return xerrors.Errorf("error in releaseUserBlobs: %w", err)
+		}
+	}
+
+	if err := os.RemoveAll(oldPath); err != nil {
+		return xerrors.Errorf("error in os.RemoveAll: %w", err)
+	}
+
+	return nil
+}
+
+// releaseUserBlobs: release refs of every file of plain user at userPath in
+// store. A block inode is removed before its refs are released, so a
+// release interrupted is not done twice
+func releaseUserBlobs(store *BlobStore, userPath string) error {
+	entries, err := os.ReadDir(userPath)
+	if err != nil {
+		return xerrors.Errorf("error in os.ReadDir: %w", err)
+	}
+
+	for _, e := range entries {
+		if _, err := strconv.ParseUint(e.Name(), 10, 64); err != nil || !e.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(userPath, e.Name(), BlockINodeFileName)
+		block, ok, err := readPlainBlock(path)
+		if err != nil || !ok {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			return xerrors.Errorf("error in os.Remove: %w", err)
+		}
+
+		for _, header := range block.FileMap {
+			if header.Type != File {
+				continue
+			}
+
+			if err := releaseChunks(store, header.contentChunks()); err != nil {
+				return xerrors.Errorf("error in releaseChunks: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Unlock: unwrap data key of user name by passphrase, the user can be used
+// by every service of this process until Lock
+func (cs *commandService) Unlock(name, passphrase string) error {
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	userPath := filepath.Join(cs.root, name)
+	if err := recoverRekey(cs.root, name); err != nil {
+		return xerrors.Errorf("err in recoverRekey: %w", err)
+	}
+
+	if err := AttemptUser(cs.root, name); errors.Is(err, ErrNotExist) {
+		return errorf(ErrNotExist, "User [%s] not exist", name)
+	} else if err != nil {
+		return xerrors.Errorf("err in AttemptUser: %w", err)
+	}
+
+	key, err := loadUserKey(userPath)
+	if err != nil {
+		return xerrors.Errorf("err in loadUserKey: %w", err)
+	}
+
+	s, err := key.unwrap(passphrase)
+	if err != nil {
+		return xerrors.Errorf("err in unwrap: %w", err)
+	}
+	setKey(userPath, s)
+
+	return nil
+}
+
+// Lock: forget data key of encrypted user name, cached user is dropped
+func (cs *commandService) Lock(name string) error {
+	userPath := filepath.Join(cs.root, name)
+	if _, err := loadUserKey(userPath); err != nil {
+		return xerrors.Errorf("err in loadUserKey: %w", err)
+	}
+	setKey(userPath, nil)
+
+	delete(cs.userMap, name)
+	if cs.currentUser != nil && cs.currentUser.Name == name {
+		cs.currentUser = nil
+		cs.currentBlock = nil
+	}
+
+	return nil
+}
@@ -0,0 +1,479 @@
+package vfsgo
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readAll(t *testing.T, cmdService ICommandService, name string) string {
+	r, err := cmdService.ReadFile(name)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer r.Close()
+
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return string(buf)
+}
+
+func TestEncrypt(t *testing.T) {
+	root := t.TempDir()
+	cmdService := NewCommandService(root)
+	big := strings.Repeat("secret payload ", 20000)
+
+	must(t, cmdService.Register("testCrypt"))
+	must(t, cmdService.Use("testCrypt"))
+	must(t, cmdService.CreateFile("plain.txt", "hidden description"))
+	must(t, cmdService.WriteFile("plain.txt", strings.NewReader("top secret")))
+	must(t, cmdService.CreateFolder("z"))
+	must(t, cmdService.SetCompression("z", CompressionGzip))
+	must(t, cmdService.ChangeFolder("z"))
+	must(t, cmdService.CreateFile("big.txt", ""))
+	must(t, cmdService.WriteFile("big.txt", strings.NewReader(big)))
+	must(t, cmdService.ChangeFolder("/"))
+	must(t, cmdService.Copy("plain.txt", "z"))
+	if _, err := cmdService.Search("secret", 0); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.Encrypt("", EncryptFull); err == nil {
+		t.Error("empty passphrase should fail")
+		return
+	}
+
+	if err := cmdService.Encrypt("pass", EncryptFull); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.Encrypt("pass", EncryptFull); !errors.Is(err, ErrExist) {
+		t.Error("encrypt twice should fail")
+		return
+	}
+
+	// nothing is left in plain text nor in the shared store
+	userPath := filepath.Join(root, "testCrypt")
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || info.Name() == LockFileName {
+			return err
+		}
+
+		if !strings.HasPrefix(path, userPath) {
+			t.Errorf("%s left outside of user", path)
+			return nil
+		}
+
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		for _, plain := range []string{"secret", "hidden", "plain.txt", "payload"} {
+			if bytes.Contains(buf, []byte(plain)) {
+				t.Errorf("%s found in %s", plain, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if got := readAll(t, cmdService, "plain.txt"); got != "top secret" {
+		t.Errorf("unexpected content %q", got)
+		return
+	}
+
+	if err := cmdService.Lock("testCrypt"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	other := NewCommandService(root)
+	if err := other.Use("testCrypt"); !errors.Is(err, ErrLocked) {
+		t.Errorf("locked user should not be used %v", err)
+		return
+	}
+
+	if err := other.Unlock("testCrypt", "wrong"); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("wrong passphrase should fail %v", err)
+		return
+	}
+
+	if err := other.Unlock("testCrypt", "pass"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := other.Use("testCrypt"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := other.RotateKey("wrong", "next"); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("rotate by wrong passphrase should fail %v", err)
+		return
+	}
+
+	if err := other.RotateKey("pass", "next"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := other.ChangeFolder("z"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	content, err := other.ReadFile("big.txt")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer content.Close()
+
+	// read across segments of compressed and encrypted content
+	part := make([]byte, 30)
+	off := int64(len(big) - 100)
+	if _, err := content.(Content).ReadAt(part, off); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if string(part) != big[off:off+30] {
+		t.Error("unexpected content read at offset")
+		return
+	}
+
+	if got := readAll(t, other, "plain.txt"); got != "top secret" {
+		t.Errorf("unexpected content %q of copy", got)
+		return
+	}
+
+	if matches, err := other.Search("secret", 0); err != nil || len(matches) != 3 {
+		t.Errorf("unexpected search after rotate %v %v", matches, err)
+		return
+	}
+
+	if got := damageList(t, other); got != "" {
+		t.Errorf("unexpected damages after rotate %s", got)
+		return
+	}
+
+	if err := other.Lock("testCrypt"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := other.Unlock("testCrypt", "pass"); !errors.Is(err, ErrWrongKey) {
+		t.Error("old passphrase should fail after rotate")
+		return
+	}
+
+	if err := other.Unlock("testCrypt", "next"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+}
+
+func TestSealedTamper(t *testing.T) {
+	cmdService := NewCommandService(t.TempDir())
+
+	must(t, cmdService.Register("testTamper"))
+	must(t, cmdService.Use("testTamper"))
+	must(t, cmdService.Encrypt("pass", EncryptFull))
+	must(t, cmdService.CreateFile("file", ""))
+	must(t, cmdService.WriteFile("file", strings.NewReader("content")))
+
+	header := cmdService.GetCurrentBlock().FileMap["file"]
+	path := cmdService.GetCurrentBlock().blobStore().Path(header.ContentHash)
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	buf[len(buf)-1] ^= 1
+
+	if err := ioutil.WriteFile(path, buf, 0666); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	r, err := cmdService.ReadFile("file")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer r.Close()
+
+	if _, err := ioutil.ReadAll(r); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("changed content should be corrupted %v", err)
+		return
+	}
+}
+
+func TestSealedConcurrentRead(t *testing.T) {
+	cmdService := NewCommandService(t.TempDir())
+
+	must(t, cmdService.Register("testSealedRead"))
+	must(t, cmdService.Use("testSealedRead"))
+	must(t, cmdService.Encrypt("pass", EncryptFull))
+
+	data := make([]byte, sealSegmentSize*8)
+	for i := range data {
+		data[i] = byte(i / sealSegmentSize)
+	}
+
+	store := cmdService.GetCurrentBlock().blobStore()
+	blob, err := store.Put(bytes.NewReader(data), CompressionNone)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	content, err := store.Open(blob.Hash)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer content.Close()
+
+	if _, ok := content.(*sealedReader); !ok {
+		t.Errorf("unexpected reader %T of encrypted blob", content)
+		return
+	}
+
+	readConcurrently(t, content, data)
+}
+
+func TestEncryptNames(t *testing.T) {
+	root := t.TempDir()
+	cmdService := NewCommandService(root)
+
+	must(t, cmdService.Register("testNames"))
+	must(t, cmdService.Use("testNames"))
+	must(t, cmdService.CreateFolder("projects"))
+	must(t, cmdService.ChangeFolder("projects"))
+	must(t, cmdService.CreateFile("salary.txt", "confidential"))
+	must(t, cmdService.WriteFile("salary.txt", strings.NewReader("42")))
+	if _, err := cmdService.Search("salary", 0); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	must(t, cmdService.Encrypt("pass", EncryptNames))
+
+	userPath := filepath.Join(root, "testNames")
+	err := filepath.Walk(userPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		for _, plain := range []string{"projects", "salary", "confidential"} {
+			if bytes.Contains(buf, []byte(plain)) {
+				t.Errorf("%s found in %s", plain, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// entries on disk are keyed by keyed hash of names
+	s, _ := keyOf(userPath)
+	buf, err := ioutil.ReadFile(filepath.Join(userPath, "0", BlockINodeFileName))
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if !bytes.Contains(buf, []byte(s.name("projects"))) {
+		t.Error("entry should be keyed by keyed hash of its name")
+		return
+	}
+
+	if err := cmdService.Lock("testNames"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	other := NewCommandService(root)
+	if err := other.Use("testNames"); !errors.Is(err, ErrLocked) {
+		t.Errorf("locked user should not be used %v", err)
+		return
+	}
+
+	must(t, other.Unlock("testNames", "pass"))
+	must(t, other.Use("testNames"))
+	must(t, other.ChangeFolder("/projects"))
+
+	header, err := GetFile(other.GetCurrentBlock(), "salary.txt")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if header.Description != "confidential" {
+		t.Errorf("unexpected description %q", header.Description)
+		return
+	}
+
+	if got := readAll(t, other, "salary.txt"); got != "42" {
+		t.Errorf("unexpected content %q", got)
+		return
+	}
+
+	if got := searchPaths(t, other, "salary"); got != "/projects/salary.txt" {
+		t.Errorf("unexpected search %s", got)
+		return
+	}
+
+	if got := damageList(t, other); got != "" {
+		t.Errorf("unexpected damages %s", got)
+		return
+	}
+}
+
+// copyTree: copy of dir src at dst
+func copyTree(t *testing.T, src, dst string) {
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dst, rel), buf, info.Mode())
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestRekeyInterrupted(t *testing.T) {
+	root := t.TempDir()
+	cmdService := NewCommandService(root)
+	userPath := filepath.Join(root, "testRekey")
+
+	if err := cmdService.Register("testRekey"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.Use("testRekey"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.CreateFile("plain.txt", ""); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.WriteFile("plain.txt", strings.NewReader("top secret")); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// incomplete new user of a rekey stopped before its key is dropped
+	if err := os.MkdirAll(filepath.Join(root, rekeyNewPrefix+"testRekey", "0"), 0755); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	other := NewCommandService(root)
+	if err := other.Use("testRekey"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if got := readAll(t, other, "plain.txt"); got != "top secret" {
+		t.Errorf("unexpected content %q", got)
+		return
+	}
+
+	if _, err := os.Stat(filepath.Join(root, rekeyNewPrefix+"testRekey")); !os.IsNotExist(err) {
+		t.Errorf("incomplete new user kept: %v", err)
+		return
+	}
+
+	// complete new user of a rekey stopped before it replaced the user
+	plain := t.TempDir()
+	copyTree(t, root, plain)
+
+	if err := cmdService.Encrypt("pass", EncryptFull); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := os.Rename(userPath, filepath.Join(root, rekeyNewPrefix+"testRekey")); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	copyTree(t, filepath.Join(plain, "testRekey"), userPath)
+	copyTree(t, filepath.Join(plain, BlobDirName), filepath.Join(root, BlobDirName))
+	dropKey(userPath)
+
+	other = NewCommandService(root)
+	if err := other.Unlock("testRekey", "pass"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := other.Use("testRekey"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if got := readAll(t, other, "plain.txt"); got != "top secret" {
+		t.Errorf("unexpected content %q", got)
+		return
+	}
+
+	if refs, err := NewBlobStore(root).Refs(contentHash("top secret")); err != nil || refs != 0 {
+		t.Errorf("refs %d of replaced user kept in shared store", refs)
+		return
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".rekey") {
+			t.Errorf("%s left in root", e.Name())
+			return
+		}
+	}
+}
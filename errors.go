@@ -0,0 +1,44 @@
+package vfsgo
+
+import (
+	"fmt"
+	"io/fs"
+)
+
+// Error: error with a message of its own which wrap a kind, kinds are the
+// Err values below and each of them wrap the io/fs equivalent, so both
+// errors.Is(err, ErrNotExist) and errors.Is(err, fs.ErrNotExist) match
+type Error struct {
+	msg  string
+	kind error
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.kind
+}
+
+var (
+	ErrExist       = &Error{msg: "already exist", kind: fs.ErrExist}
+	ErrNotExist    = &Error{msg: "not exist", kind: fs.ErrNotExist}
+	ErrNotDir      = &Error{msg: "not a directory", kind: fs.ErrInvalid}
+	ErrIsDir       = &Error{msg: "is a directory", kind: fs.ErrInvalid}
+	ErrInvalidName = &Error{msg: "invalid name", kind: fs.ErrInvalid}
+	ErrNoUser      = &Error{msg: "no user in use", kind: fs.ErrPermission}
+	ErrPermission  = &Error{msg: "permission denied", kind: fs.ErrPermission}
+	ErrCorrupt     = &Error{msg: "content corrupted", kind: fs.ErrInvalid}
+	ErrLocked      = &Error{msg: "user is locked", kind: fs.ErrPermission}
+	ErrWrongKey    = &Error{msg: "wrong key", kind: fs.ErrPermission}
+)
+
+// NewError: error with message msg of kind, kind is one of the Err values
+func NewError(kind error, msg string) error {
+	return &Error{msg: msg, kind: kind}
+}
+
+func errorf(kind error, format string, a ...interface{}) error {
+	return &Error{msg: fmt.Sprintf(format, a...), kind: kind}
+}
@@ -0,0 +1,66 @@
+package vfsgo
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+func TestErrorKind(t *testing.T) {
+	cmdService := NewCommandService(t.TempDir())
+
+	if err := cmdService.CreateFolder("testErrorKind"); !errors.Is(err, ErrNoUser) {
+		t.Error("create folder without user should be ErrNoUser")
+		return
+	}
+
+	if err := cmdService.Register("test/ErrorKind"); !errors.Is(err, ErrInvalidName) || !errors.Is(err, fs.ErrInvalid) {
+		t.Error("register invalid name should be ErrInvalidName")
+		return
+	}
+
+	if err := cmdService.Use("testErrorKind"); !errors.Is(err, ErrNotExist) || !errors.Is(err, fs.ErrNotExist) {
+		t.Error("use unknown user should be ErrNotExist")
+		return
+	}
+
+	if err := cmdService.Register("testErrorKind"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.Register("testErrorKind"); !errors.Is(err, ErrExist) || !errors.Is(err, fs.ErrExist) {
+		t.Error("register twice should be ErrExist")
+		return
+	}
+
+	if err := cmdService.Use("testErrorKind"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.CreateFolder("testFolder"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.CreateFile("testFile", "desc"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.DeleteFolder("testFile"); !errors.Is(err, ErrNotDir) {
+		t.Error("delete folder on file should be ErrNotDir")
+		return
+	}
+
+	if err := cmdService.DeleteFile("testFolder"); !errors.Is(err, ErrIsDir) {
+		t.Error("delete file on folder should be ErrIsDir")
+		return
+	}
+
+	if err := cmdService.ChangeFolder("notExist"); !errors.Is(err, ErrNotExist) {
+		t.Error("cd to unknown folder should be ErrNotExist")
+		return
+	}
+}
@@ -0,0 +1,309 @@
+package vfsgo
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// ManifestFileName: sidecar of exported dir keeping what host files can
+	// not, read back by Import
+	ManifestFileName = ".vfsgo-manifest.json"
+)
+
+const (
+	// ExportConflict, ExportOverwrite, ExportSkip: policy of host file
+	// existing at path of exported file. On conflict nothing is exported
+	// while any exists, it is the default
+	ExportConflict  = "conflict"
+	ExportOverwrite = "overwrite"
+	ExportSkip      = "skip"
+)
+
+// ManifestEntry: metadata of exported entry, keyed by path relative to
+// exported dir in manifest
+type ManifestEntry struct {
+	Description string            `json:"description,omitempty"`
+	CreatedTime time.Time         `json:"created_time"`
+	Tags        []string          `json:"tags,omitempty"`
+	Attrs       map[string]string `json:"attrs,omitempty"`
+}
+
+// ExportOptions: Policy is one of ExportConflict, ExportOverwrite and
+// ExportSkip, Progress is called after every entry handled
+type ExportOptions struct {
+	Policy   string
+	Progress func(report ExportReport)
+}
+
+// ExportReport: what Export did so far, Path is the last entry handled.
+// Conflicts are entries not exported as host has an entry of other type at
+// their path, or every existing file with ExportConflict
+type ExportReport struct {
+	Path      string
+	Dirs      int
+	Files     int
+	Bytes     int64
+	Skipped   int
+	Conflicts []string
+}
+
+// readManifest: manifest of host dir, empty if it has none
+func readManifest(dir string) (map[string]ManifestEntry, error) {
+	manifest := make(map[string]ManifestEntry)
+
+	buf, err := os.ReadFile(filepath.Join(dir, ManifestFileName))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("error in os.ReadFile: %w", err)
+	}
+
+	if err := json.Unmarshal(buf, &manifest); err != nil {
+		return nil, errorf(ErrCorrupt, "manifest of %s: %v", dir, err)
+	}
+
+	return manifest, nil
+}
+
+func writeManifest(dir string, manifest map[string]ManifestEntry) error {
+	buf, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return xerrors.Errorf("error in json.MarshalIndent: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, ManifestFileName), buf, 0644); err != nil {
+		return xerrors.Errorf("error in os.WriteFile: %w", err)
+	}
+
+	return nil
+}
+
+func manifestEntry(header FileHeader) ManifestEntry {
+	return ManifestEntry{
+		Description: header.Description,
+		CreatedTime: header.CreatedTime,
+		Tags:        header.Tags,
+		Attrs:       header.Attrs,
+	}
+}
+
+// Export: write entries of folder vfsPath into host dir hostPath by their
+// names, times are kept as modified time of host and the rest of header in
+// manifest of hostPath
+func (cs *commandService) Export(vfsPath, hostPath string, opts ExportOptions) (ExportReport, error) {
+	report := ExportReport{Conflicts: []string{}}
+
+	if cs.currentBlock == nil {
+		return report, NewError(ErrNoUser, "current block is nil")
+	}
+
+	switch opts.Policy {
+	case "":
+		opts.Policy = ExportConflict
+	case ExportConflict, ExportOverwrite, ExportSkip:
+	default:
+		return report, errorf(ErrInvalidName, "unknown policy %s", opts.Policy)
+	}
+
+	block, err := cs.travelFolder(vfsPath)
+	if err != nil {
+		return report, xerrors.Errorf("err in travelFolder: %w", err)
+	}
+
+	if info, err := os.Stat(hostPath); err == nil && !info.IsDir() {
+		return report, errorf(ErrNotDir, "host path %s not a directory", hostPath)
+	}
+
+	manifest, err := readManifest(hostPath)
+	if err != nil {
+		return report, xerrors.Errorf("err in readManifest: %w", err)
+	}
+
+	e := exporter{user: cs.currentUser, opts: opts, report: &report, manifest: manifest}
+
+	if opts.Policy == ExportConflict {
+		e.conflicts(block, "", hostPath)
+		if len(report.Conflicts) > 0 {
+			return report, errorf(ErrExist, "%d entries exist on host", len(report.Conflicts))
+		}
+	}
+
+	if err := os.MkdirAll(hostPath, 0755); err != nil {
+		return report, xerrors.Errorf("err in os.MkdirAll: %w", err)
+	}
+
+	err = e.dir(block, "", hostPath)
+
+	// manifest of entries exported before an error is still kept
+	if err := writeManifest(hostPath, manifest); err != nil {
+		return report, xerrors.Errorf("err in writeManifest: %w", err)
+	}
+
+	return report, err
+}
+
+type exporter struct {
+	user     *User
+	opts     ExportOptions
+	report   *ExportReport
+	manifest map[string]ManifestEntry
+}
+
+func sortedNames(block *BlockINode) []string {
+	names := make([]string, 0, len(block.FileMap))
+	for name := range block.FileMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// conflicts: host entries in the way of entries under block
+func (e *exporter) conflicts(block *BlockINode, rel, host string) {
+	for _, name := range sortedNames(block) {
+		header := block.FileMap[name]
+		r, p := path.Join(rel, name), filepath.Join(host, name)
+
+		info, err := os.Lstat(p)
+		if err != nil {
+			continue
+		}
+
+		child, ok := childBlock(e.user, header)
+		if !ok || !info.IsDir() {
+			e.report.Conflicts = append(e.report.Conflicts, r)
+			continue
+		}
+
+		e.conflicts(&child, r, p)
+	}
+}
+
+func (e *exporter) dir(block *BlockINode, rel, host string) error {
+	for _, name := range sortedNames(block) {
+		r := path.Join(rel, name)
+		e.report.Path = r
+
+		if err := e.entry(block, name, r, filepath.Join(host, name)); err != nil {
+			return err
+		}
+
+		if e.opts.Progress != nil {
+			e.opts.Progress(*e.report)
+		}
+	}
+
+	return nil
+}
+
+func (e *exporter) entry(block *BlockINode, name, rel, host string) error {
+	header := block.FileMap[name]
+	info, err := os.Lstat(host)
+	exist := err == nil
+
+	if header.Type == Directory {
+		child, ok := childBlock(e.user, header)
+		if !ok {
+			return errorf(ErrNotExist, "block of %s not exist", rel)
+		}
+
+		if exist && !info.IsDir() {
+			e.report.Conflicts = append(e.report.Conflicts, rel)
+			return nil
+		}
+
+		if !exist {
+			if err := os.Mkdir(host, 0755); err != nil {
+				return xerrors.Errorf("err in os.Mkdir: %w", err)
+			}
+		}
+
+		if err := e.dir(&child, rel, host); err != nil {
+			return err
+		}
+
+		e.report.Dirs++
+		e.manifest[rel] = manifestEntry(header)
+		return chtimes(host, header.ModifiedTime)
+	}
+
+	if exist {
+		switch {
+		case info.IsDir() || e.opts.Policy == ExportConflict:
+			// appeared after conflicts were checked
+			e.report.Conflicts = append(e.report.Conflicts, rel)
+			return nil
+		case e.opts.Policy == ExportSkip:
+			e.report.Skipped++
+			return nil
+		}
+	}
+
+	n, err := exportFile(block, name, host)
+	if err != nil {
+		return err
+	}
+
+	e.report.Files++
+	e.report.Bytes += n
+	e.manifest[rel] = manifestEntry(header)
+	return chtimes(host, header.ModifiedTime)
+}
+
+// exportFile: content of file written to a temp beside host, then renamed
+// over it so an existing file is replaced whole
+func exportFile(block *BlockINode, name, host string) (int64, error) {
+	content, err := OpenFileContent(block, name)
+	if err != nil {
+		return 0, xerrors.Errorf("err in OpenFileContent: %w", err)
+	}
+	defer content.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(host), ".vfsgo-export-*")
+	if err != nil {
+		return 0, xerrors.Errorf("err in os.CreateTemp: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, content)
+	if err != nil {
+		tmp.Close()
+		return 0, xerrors.Errorf("err in io.Copy: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return 0, xerrors.Errorf("err in Close: %w", err)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return 0, xerrors.Errorf("err in os.Chmod: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), host); err != nil {
+		return 0, xerrors.Errorf("err in os.Rename: %w", err)
+	}
+
+	return n, nil
+}
+
+func chtimes(host string, t time.Time) error {
+	if t.IsZero() {
+		return nil
+	}
+
+	if err := os.Chtimes(host, t, t); err != nil {
+		return xerrors.Errorf("err in os.Chtimes: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package vfsgo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	cmdService := newFindTree(t)
+	host := t.TempDir()
+
+	must(t, cmdService.Tag("/a/note.txt", "red"))
+	must(t, cmdService.SetAttr("/a/note.txt", "owner", "me"))
+
+	note, err := cmdService.Stat("/a/note.txt")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	report, err := cmdService.Export("/", host, ExportOptions{})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if report.Dirs != 3 || report.Files != 3 || report.Bytes != 5 {
+		t.Errorf("unexpected report %+v", report)
+		return
+	}
+
+	buf, err := os.ReadFile(filepath.Join(host, "a", "note.txt"))
+	if err != nil || string(buf) != "hello" {
+		t.Errorf("unexpected content %q %v", buf, err)
+		return
+	}
+
+	info, err := os.Stat(filepath.Join(host, "a", "note.txt"))
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if !info.ModTime().Equal(note.ModifiedTime) {
+		t.Errorf("unexpected mtime %v", info.ModTime())
+		return
+	}
+
+	manifest, err := readManifest(host)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if entry := manifest["a/b/deep.txt"]; entry.Description != "deep note" {
+		t.Errorf("unexpected manifest entry %+v", entry)
+		return
+	}
+
+	// conflict by default, nothing is written
+	if err := os.WriteFile(filepath.Join(host, "top.log"), []byte("host"), 0644); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	report, err = cmdService.Export("/", host, ExportOptions{})
+	if !errors.Is(err, ErrExist) || len(report.Conflicts) != 3 || report.Files != 0 {
+		t.Errorf("unexpected report of conflict %+v %v", report, err)
+		return
+	}
+
+	report, err = cmdService.Export("/", host, ExportOptions{Policy: ExportSkip})
+	if err != nil || report.Skipped != 3 || report.Files != 0 {
+		t.Errorf("unexpected report of skip %+v %v", report, err)
+		return
+	}
+
+	if buf, _ := os.ReadFile(filepath.Join(host, "top.log")); string(buf) != "host" {
+		t.Error("skipped file should be kept")
+		return
+	}
+
+	report, err = cmdService.Export("/", host, ExportOptions{Policy: ExportOverwrite})
+	if err != nil || report.Files != 3 {
+		t.Errorf("unexpected report of overwrite %+v %v", report, err)
+		return
+	}
+
+	if buf, _ := os.ReadFile(filepath.Join(host, "top.log")); string(buf) != "" {
+		t.Error("overwritten file should be replaced")
+		return
+	}
+
+	if _, err := cmdService.Export("/", host, ExportOptions{Policy: "merge"}); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("unknown policy should fail %v", err)
+		return
+	}
+
+	// import of export is the same tree
+	must(t, cmdService.CreateFolder("back"))
+	if _, err := cmdService.Import(host, "/back", ImportOptions{}); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	back, err := cmdService.Stat("/back/a/note.txt")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if back.Description != note.Description || !back.CreatedTime.Equal(note.CreatedTime) ||
+		!back.ModifiedTime.Equal(note.ModifiedTime) || strings.Join(back.Tags, ",") != "red" || back.Attrs["owner"] != "me" {
+		t.Errorf("unexpected header after round trip %+v", back)
+		return
+	}
+
+	if got := searchPaths(t, cmdService, "deep"); !strings.Contains(got, "/back/a/b/deep.txt") {
+		t.Errorf("unexpected search %s", got)
+		return
+	}
+
+}
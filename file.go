@@ -5,7 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
-	"io/ioutil"
+	"io"
 	"os"
 	"time"
 
@@ -19,30 +19,69 @@ const (
 	File
 )
 
+const (
+	ContentFileSuffix = ".content"
+)
+
 type FileHeader struct {
 	HashFileName string
 	Type         FileType
 	DirNodeID    *uint64
 	Name         string
 	Description  string
+	Size         int64
+	// ContentHash: blob of content in BlobStore, empty if never written or
+	// content is split into Chunks
+	ContentHash string
+	// Chunks: manifest of content longer than one chunk, see SplitChunks
+	Chunks []Blob
+	// Compression, StoredSize: form of blob and bytes it take on disk
+	Compression string
+	StoredSize  int64
+
 	CreatedTime  time.Time
 	ModifiedTime time.Time
+
+	// Tags: sorted without duplicate
+	Tags  []string
+	Attrs map[string]string
 }
 
 func (f *FileHeader) Save(path string) error {
-	file, err := os.OpenFile(path+"/"+f.HashFileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
-	if err != nil {
-		return xerrors.Errorf("error in os.OpenFile: %w", err)
+	header := *f
+	if s := namesSealer(path); s != nil {
+		var err error
+		if header, err = s.hideHeader(header); err != nil {
+			return xerrors.Errorf("error in hideHeader: %w", err)
+		}
 	}
-	defer file.Close()
 
-	buf, err := json.Marshal(f)
+	buf, err := json.Marshal(header)
 	if err != nil {
 		return xerrors.Errorf("error in json.Marshal: %w", err)
 	}
 
-	if _, err := file.Write(buf); err != nil {
-		return xerrors.Errorf("error in file.Write: %w", err)
+	if err := writeINode(path+"/"+f.HashFileName, buf); err != nil {
+		return xerrors.Errorf("error in writeINode: %w", err)
+	}
+
+	return nil
+}
+
+// GetContentPath: content of file written before blob store is kept
+// beside its header
+func (f *FileHeader) GetContentPath(path string) string {
+	return path + "/" + f.HashFileName + ContentFileSuffix
+}
+
+// releaseContent: drop blob refs or content beside header of file
+func releaseContent(block *BlockINode, header FileHeader) error {
+	if err := releaseChunks(block.blobStore(), header.contentChunks()); err != nil {
+		return xerrors.Errorf("error in releaseChunks: %w", err)
+	}
+
+	if err := os.Remove(header.GetContentPath(block.GetBlockPath())); err != nil && !os.IsNotExist(err) {
+		return xerrors.Errorf("error in os.Remove: %w", err)
 	}
 
 	return nil
@@ -60,7 +99,7 @@ func randHash() (string, error) {
 
 func CreateFolder(block *BlockINode, nodeid uint64, foldername, desc string) (FileHeader, error) {
 	if _, ok := block.FileMap[foldername]; ok {
-		return FileHeader{}, xerrors.New("file already exist")
+		return FileHeader{}, NewError(ErrExist, "file already exist")
 	}
 
 	filenameInFS, err := randHash()
@@ -69,7 +108,7 @@ func CreateFolder(block *BlockINode, nodeid uint64, foldername, desc string) (Fi
 	}
 
 	if _, err := os.Stat(block.GetBlockPath()); err != nil {
-		return FileHeader{}, xerrors.New("block path not exis")
+		return FileHeader{}, NewError(ErrNotExist, "block path not exis")
 	}
 
 	file, err := os.Create(block.GetBlockPath() + "/" + filenameInFS)
@@ -103,7 +142,7 @@ func CreateFolder(block *BlockINode, nodeid uint64, foldername, desc string) (Fi
 
 func CreateFile(block *BlockINode, filename, filedescription string) (FileHeader, error) {
 	if _, ok := block.FileMap[filename]; ok {
-		return FileHeader{}, xerrors.New("file already exist")
+		return FileHeader{}, NewError(ErrExist, "file already exist")
 	}
 
 	filenameInFS, err := randHash()
@@ -112,7 +151,7 @@ func CreateFile(block *BlockINode, filename, filedescription string) (FileHeader
 	}
 
 	if _, err := os.Stat(block.GetBlockPath()); err != nil {
-		return FileHeader{}, xerrors.New("block path not exis")
+		return FileHeader{}, NewError(ErrNotExist, "block path not exis")
 	}
 
 	file, err := os.Create(block.GetBlockPath() + "/" + filenameInFS)
@@ -147,18 +186,12 @@ func CreateFile(block *BlockINode, filename, filedescription string) (FileHeader
 func GetFile(block *BlockINode, filename string) (FileHeader, error) {
 	fileheader, ok := block.FileMap[filename]
 	if !ok {
-		return FileHeader{}, xerrors.New("file not found")
-	}
-
-	file, err := os.Open(block.GetBlockPath() + "/" + fileheader.HashFileName)
-	if err != nil {
-		return FileHeader{}, xerrors.Errorf("error in os.Open: %w", err)
+		return FileHeader{}, NewError(ErrNotExist, "file not found")
 	}
-	defer file.Close()
 
-	b, err := ioutil.ReadAll(file)
+	b, err := readINode(block.GetBlockPath() + "/" + fileheader.HashFileName)
 	if err != nil {
-		return FileHeader{}, xerrors.Errorf("error in ioutil.ReadAll: %w", err)
+		return FileHeader{}, xerrors.Errorf("error in readINode: %w", err)
 	}
 
 	var data FileHeader
@@ -166,13 +199,19 @@ func GetFile(block *BlockINode, filename string) (FileHeader, error) {
 		return FileHeader{}, xerrors.Errorf("error in json.Unmarshal: %w", err)
 	}
 
+	if s := namesSealer(block.UserPath); s != nil {
+		if data, err = s.showHeader(data); err != nil {
+			return FileHeader{}, xerrors.Errorf("error in showHeader: %w", err)
+		}
+	}
+
 	return data, nil
 }
 
 func UpdateFile(block *BlockINode, filename, filedescription string) error {
 	header, ok := block.FileMap[filename]
 	if !ok {
-		return xerrors.New("file not found")
+		return NewError(ErrNotExist, "file not found")
 	}
 
 	header.Description = filedescription
@@ -187,13 +226,17 @@ func UpdateFile(block *BlockINode, filename, filedescription string) error {
 func DeleteFile(block *BlockINode, filename string) error {
 	header, ok := block.FileMap[filename]
 	if !ok {
-		return xerrors.New("file not found")
+		return NewError(ErrNotExist, "file not found")
 	}
 
 	if err := os.Remove(block.GetBlockPath() + "/" + header.HashFileName); err != nil {
 		return xerrors.Errorf("error in os.Remove: %w", err)
 	}
 
+	if err := releaseContent(block, header); err != nil {
+		return xerrors.Errorf("error in releaseContent: %w", err)
+	}
+
 	delete(block.FileMap, filename)
 	if err := block.Save(); err != nil {
 		return xerrors.Errorf("error in block.Save: %w", err)
@@ -201,3 +244,72 @@ func DeleteFile(block *BlockINode, filename string) error {
 
 	return nil
 }
+
+// WriteFileContent: replace file content with everything read from r
+func WriteFileContent(block *BlockINode, filename string, r io.Reader, codec string) (FileHeader, error) {
+	header, ok := block.FileMap[filename]
+	if !ok {
+		return FileHeader{}, NewError(ErrNotExist, "file not found")
+	}
+
+	if header.Type != File {
+		return FileHeader{}, NewError(ErrIsDir, "not a file")
+	}
+
+	// old content is kept until the new one is stored
+	chunks, err := putChunks(block.blobStore(), r, codec)
+	if err != nil {
+		return FileHeader{}, xerrors.Errorf("error in putChunks: %w", err)
+	}
+
+	if err := releaseContent(block, header); err != nil {
+		return FileHeader{}, xerrors.Errorf("error in releaseContent: %w", err)
+	}
+
+	header.setContent(chunks)
+	header.ModifiedTime = time.Now()
+	if err := header.Save(block.GetBlockPath()); err != nil {
+		return FileHeader{}, xerrors.Errorf("error in header.Save: %w", err)
+	}
+
+	block.FileMap[filename] = header
+	if err := block.Save(); err != nil {
+		return FileHeader{}, xerrors.Errorf("error in block.Save: %w", err)
+	}
+
+	return header, nil
+}
+
+// OpenFileContent: open file content for read, file never written is empty
+func OpenFileContent(block *BlockINode, filename string) (Content, error) {
+	header, ok := block.FileMap[filename]
+	if !ok {
+		return nil, NewError(ErrNotExist, "file not found")
+	}
+
+	if header.Type != File {
+		return nil, NewError(ErrIsDir, "not a file")
+	}
+
+	if len(header.Chunks) > 0 {
+		return openManifest(block.blobStore(), header.Chunks), nil
+	}
+
+	if header.ContentHash != "" {
+		file, err := block.blobStore().Open(header.ContentHash)
+		if err != nil {
+			return nil, xerrors.Errorf("error in Open: %w", err)
+		}
+		return file, nil
+	}
+
+	file, err := os.Open(header.GetContentPath(block.GetBlockPath()))
+	if os.IsNotExist(err) {
+		file, err = os.Open(os.DevNull)
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("error in os.Open: %w", err)
+	}
+
+	return file, nil
+}
@@ -0,0 +1,95 @@
+package vfsgo
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// Predicate: decide whether entry at path is matched by Find
+type Predicate func(path string, h FileHeader) bool
+
+// Match: entry found by Find, Path is joined from the root given to Find
+type Match struct {
+	Path   string
+	Header FileHeader
+}
+
+// FindQuery: filter of entries under root, entries directly in root have
+// depth 1, MaxDepth not positive means unlimited
+type FindQuery struct {
+	Filter
+
+	MinDepth int
+	MaxDepth int
+}
+
+// WalkFunc: called for every entry visited by Walk
+type WalkFunc func(path string, depth int, h FileHeader) error
+
+// Walk: visit entries under block depth first in name order, folder is
+// visited before its entries
+func Walk(user *User, block *BlockINode, root string, maxDepth int, fn WalkFunc) error {
+	return walk(user, block, root, 1, maxDepth, fn)
+}
+
+func walk(user *User, block *BlockINode, dir string, depth, maxDepth int, fn WalkFunc) error {
+	names := make([]string, 0, len(block.FileMap))
+	for name := range block.FileMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		header := block.FileMap[name]
+		p := joinPath(dir, name)
+
+		if err := fn(p, depth, header); err != nil {
+			return err
+		}
+
+		if header.Type != Directory || header.DirNodeID == nil {
+			continue
+		}
+
+		if maxDepth > 0 && depth >= maxDepth {
+			continue
+		}
+
+		child, ok := user.BlockMap[*header.DirNodeID]
+		if !ok {
+			return errorf(ErrNotExist, "block of %s not exist", p)
+		}
+
+		if err := walk(user, &child, p, depth+1, maxDepth, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Find: entries under block matched by pred
+func Find(user *User, block *BlockINode, root string, maxDepth int, pred Predicate) ([]Match, error) {
+	matches := []Match{}
+	err := Walk(user, block, root, maxDepth, func(path string, depth int, h FileHeader) error {
+		if pred(path, h) {
+			matches = append(matches, Match{Path: path, Header: h})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("err in Walk: %w", err)
+	}
+
+	return matches, nil
+}
+
+func joinPath(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+
+	return strings.TrimRight(dir, "/") + "/" + name
+}
@@ -0,0 +1,161 @@
+package vfsgo
+
+import (
+	"strings"
+	"testing"
+)
+
+func newFindTree(t *testing.T) ICommandService {
+	cmdService := NewCommandService(t.TempDir())
+
+	if err := cmdService.Register("testFind"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := cmdService.Use("testFind"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// a/{b/{deep.txt}, note.txt}, c/, top.log
+	must(t, cmdService.CreateFolder("a"))
+	must(t, cmdService.CreateFolder("c"))
+	must(t, cmdService.CreateFile("top.log", "log"))
+	must(t, cmdService.ChangeFolder("a"))
+	must(t, cmdService.CreateFolder("b"))
+	must(t, cmdService.CreateFile("note.txt", "note"))
+	must(t, cmdService.WriteFile("note.txt", strings.NewReader("hello")))
+	must(t, cmdService.ChangeFolder("b"))
+	must(t, cmdService.CreateFile("deep.txt", "deep note"))
+	must(t, cmdService.ChangeFolder("/"))
+
+	return cmdService
+}
+
+func matchPaths(matches []Match) string {
+	paths := make([]string, 0, len(matches))
+	for _, m := range matches {
+		paths = append(paths, m.Path)
+	}
+
+	return strings.Join(paths, ",")
+}
+
+func TestFind(t *testing.T) {
+	cmdService := newFindTree(t)
+
+	matches, err := cmdService.Find(".", FindQuery{})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if got := matchPaths(matches); got != "./a,./a/b,./a/b/deep.txt,./a/note.txt,./c,./top.log" {
+		t.Errorf("unexpected walk %s", got)
+		return
+	}
+
+	fileType := File
+	matches, err = cmdService.Find("/a", FindQuery{Filter: Filter{NameRegex: `\.txt$`, Type: &fileType}, MaxDepth: 1})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if got := matchPaths(matches); got != "/a/note.txt" {
+		t.Errorf("unexpected maxdepth match %s", got)
+		return
+	}
+
+	size := int64(1)
+	matches, err = cmdService.Find("", FindQuery{Filter: Filter{MinSize: &size, DescriptionContains: "note"}})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if got := matchPaths(matches); got != "a/note.txt" {
+		t.Errorf("unexpected size match %s", got)
+		return
+	}
+
+	user := cmdService.GetCurrentUser()
+	root := user.BlockMap[0]
+	matches, err = Find(user, &root, "", 0, func(path string, h FileHeader) bool {
+		return strings.HasPrefix(path, "a/b")
+	})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if got := matchPaths(matches); got != "a/b,a/b/deep.txt" {
+		t.Errorf("unexpected predicate match %s", got)
+		return
+	}
+}
+
+func TestRemoveMove(t *testing.T) {
+	cmdService := newFindTree(t)
+
+	if err := cmdService.Move("a/b", "/a"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.Move("a", "a/b"); err == nil {
+		t.Error("move folder into itself should fail")
+		return
+	}
+
+	if err := cmdService.Move("top.log", "c"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.Move("a/b", "c"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.ChangeFolder("c/b"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.ChangeFolder(".."); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if block := cmdService.GetCurrentBlock(); block.FileMap["top.log"].Type != File {
+		t.Error("moved file not in folder")
+		return
+	}
+
+	if err := cmdService.ChangeFolder("/"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	blocks := len(cmdService.GetCurrentUser().BlockMap)
+	if err := cmdService.Remove("c"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if got := len(cmdService.GetCurrentUser().BlockMap); got != blocks-2 {
+		t.Errorf("blocks of removed tree left, %d of %d", got, blocks)
+		return
+	}
+
+	matches, err := cmdService.Find("/", FindQuery{})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if got := matchPaths(matches); got != "/a,/a/note.txt" {
+		t.Errorf("unexpected tree after remove %s", got)
+		return
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package vfsgo
+
+import "os"
+
+// flockFile: no flock on this platform, only services of one process are
+// kept apart
+func flockFile(file *os.File, exclusive bool) error {
+	return nil
+}
+
+func funlockFile(file *os.File) error {
+	return nil
+}
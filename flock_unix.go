@@ -0,0 +1,27 @@
+//go:build unix
+
+package vfsgo
+
+import (
+	"os"
+	"syscall"
+)
+
+// flockFile: flock of file, blocks until taken
+func flockFile(file *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	for {
+		err := syscall.Flock(int(file.Fd()), how)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+func funlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
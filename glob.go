@@ -0,0 +1,148 @@
+package vfsgo
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// HasMeta: p contain any of the glob chars
+func HasMeta(p string) bool {
+	return strings.ContainsAny(p, `*?[\`)
+}
+
+// GlobBlock: entries under block matching slash separated pattern, every
+// segment is a path.Match pattern and ** match any number of folders.
+// Paths are joined from dir, matches are sorted by path
+func GlobBlock(user *User, block *BlockINode, dir string, pattern string) ([]Match, error) {
+	matches := []Match{}
+	segments := strings.Split(pattern, "/")
+	if err := globSegments(user, block, dir, segments, &matches); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Path < matches[j].Path
+	})
+
+	return matches, nil
+}
+
+func globSegments(user *User, block *BlockINode, dir string, segments []string, matches *[]Match) error {
+	// skip empty segment of double slash
+	for len(segments) > 0 && segments[0] == "" {
+		segments = segments[1:]
+	}
+
+	if len(segments) == 0 {
+		return nil
+	}
+	seg, rest := segments[0], segments[1:]
+
+	switch seg {
+	case ".", "..":
+		id := block.NodeID
+		if seg == ".." {
+			id = block.PrevNodeID
+		}
+
+		next, ok := user.BlockMap[id]
+		if !ok {
+			return errorf(ErrNotExist, "block %d not exist", id)
+		}
+		return globSegments(user, &next, joinPath(dir, seg), rest, matches)
+	case "**":
+		// zero folder, then one more folder for each folder in block
+		if len(rest) > 0 {
+			if err := globSegments(user, block, dir, rest, matches); err != nil {
+				return err
+			}
+		}
+
+		return eachEntry(block, func(name string, header FileHeader) error {
+			if len(rest) == 0 {
+				*matches = append(*matches, Match{Path: joinPath(dir, name), Header: header})
+			}
+
+			child, ok := childBlock(user, header)
+			if !ok {
+				return nil
+			}
+			return globSegments(user, &child, joinPath(dir, name), segments, matches)
+		})
+	}
+
+	if _, err := path.Match(seg, ""); err != nil {
+		return errorf(ErrInvalidName, "invalid pattern %s", seg)
+	}
+
+	return eachEntry(block, func(name string, header FileHeader) error {
+		if ok, _ := path.Match(seg, name); !ok {
+			return nil
+		}
+
+		if len(rest) == 0 {
+			*matches = append(*matches, Match{Path: joinPath(dir, name), Header: header})
+			return nil
+		}
+
+		child, ok := childBlock(user, header)
+		if !ok {
+			return nil
+		}
+		return globSegments(user, &child, joinPath(dir, name), rest, matches)
+	})
+}
+
+// eachEntry: call fn on entries of block in name order
+func eachEntry(block *BlockINode, fn func(name string, header FileHeader) error) error {
+	names := make([]string, 0, len(block.FileMap))
+	for name := range block.FileMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := fn(name, block.FileMap[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func childBlock(user *User, header FileHeader) (BlockINode, bool) {
+	if header.Type != Directory || header.DirNodeID == nil {
+		return BlockINode{}, false
+	}
+
+	child, ok := user.BlockMap[*header.DirNodeID]
+	return child, ok
+}
+
+// Glob: entries matching pattern, relative to current folder or absolute
+func (cs *commandService) Glob(pattern string) ([]Match, error) {
+	block, err := cs.travelFolder(".")
+	if err != nil {
+		return nil, xerrors.Errorf("err in travelFolder: %w", err)
+	}
+
+	dir := ""
+	pattern = strings.TrimSpace(pattern)
+	if strings.HasPrefix(pattern, "/") {
+		root, ok := cs.currentUser.BlockMap[0]
+		if !ok {
+			return nil, NewError(ErrNotExist, "user not has root path")
+		}
+		block, dir = &root, "/"
+	}
+
+	matches, err := GlobBlock(cs.currentUser, block, dir, pattern)
+	if err != nil {
+		return nil, xerrors.Errorf("err in GlobBlock: %w", err)
+	}
+
+	return matches, nil
+}
@@ -0,0 +1,112 @@
+package vfsgo
+
+import (
+	"io"
+	"testing"
+)
+
+func TestGlob(t *testing.T) {
+	cmdService := newFindTree(t)
+
+	cases := map[string]string{
+		"*":          "a,c,top.log",
+		"a/*.txt":    "a/note.txt",
+		"/a/?":       "/a/b",
+		"[ab]/*":     "a/b,a/note.txt",
+		"**/*.txt":   "a/b/deep.txt,a/note.txt",
+		"a/**":       "a/b,a/b/deep.txt,a/note.txt",
+		"a/b/../*.t": "",
+		"nothing*":   "",
+	}
+	for pattern, want := range cases {
+		matches, err := cmdService.Glob(pattern)
+		if err != nil {
+			t.Error(err.Error())
+			return
+		}
+
+		if got := matchPaths(matches); got != want {
+			t.Errorf("glob %s got %s want %s", pattern, got, want)
+			return
+		}
+	}
+
+	if _, err := cmdService.Glob("[a"); err == nil {
+		t.Error("bad pattern should fail")
+		return
+	}
+}
+
+func TestCopy(t *testing.T) {
+	cmdService := newFindTree(t)
+
+	if err := cmdService.SetUsageCache(true); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.Copy("a", "c"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.Copy("a", "a/b"); err == nil {
+		t.Error("copy folder into itself should fail")
+		return
+	}
+
+	if err := cmdService.Copy("top.log", "/"); err == nil {
+		t.Error("copy onto existing name should fail")
+		return
+	}
+
+	matches, err := cmdService.Glob("c/**")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if got := matchPaths(matches); got != "c/a,c/a/b,c/a/b/deep.txt,c/a/note.txt" {
+		t.Errorf("unexpected copy %s", got)
+		return
+	}
+
+	// copy is independent of source
+	if err := cmdService.Remove("a"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.ChangeFolder("c/a"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	r, err := cmdService.ReadFile("note.txt")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer r.Close()
+
+	if b, _ := io.ReadAll(r); string(b) != "hello" {
+		t.Errorf("unexpected copied content %s", b)
+		return
+	}
+
+	du, err := cmdService.Usage("/", 0)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if du.BlockUsage != (BlockUsage{Bytes: 5, Stored: 5, Files: 3, Dirs: 3}) {
+		t.Errorf("unexpected usage after copy %+v", du.BlockUsage)
+		return
+	}
+
+	if got := searchPaths(t, cmdService, "deep"); got != "/c/a/b/deep.txt" {
+		t.Errorf("unexpected search after copy %s", got)
+		return
+	}
+}
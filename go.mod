@@ -2,4 +2,15 @@ module github.com/lemotw/vfsgo
 
 go 1.20
 
-require golang.org/x/xerrors v0.0.0-20220907171357-04be3eba64a2
+require (
+	github.com/hugelgupf/p9 v0.3.0
+	github.com/pkg/sftp v1.13.6
+	golang.org/x/crypto v0.17.0
+	golang.org/x/xerrors v0.0.0-20220907171357-04be3eba64a2
+)
+
+require (
+	github.com/kr/fs v0.1.0 // indirect
+	github.com/u-root/uio v0.0.0-20230305220412-3e8cd9d6bf63 // indirect
+	golang.org/x/sys v0.15.0 // indirect
+)
@@ -0,0 +1,272 @@
+package vfsgo
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// ImportOptions: Exclude are path.Match patterns, a pattern with slash is
+// matched against path relative to the imported dir and others against
+// name only. Progress is called after every entry handled
+type ImportOptions struct {
+	Exclude  []string
+	Progress func(report ImportReport)
+}
+
+// ImportReport: what Import did so far, Path is the last entry handled
+// relative to the imported dir. Unchanged files were imported before with
+// same size and time and are kept, so an interrupted import is resumed by
+// running it again. Rejected entries are not imported, their names are not
+// allowed or they are not regular files
+type ImportReport struct {
+	Path      string
+	Dirs      int
+	Files     int
+	Bytes     int64
+	Unchanged int
+	Excluded  int
+	Rejected  []string
+}
+
+// excluded: rel matched by any of patterns, see ImportOptions
+func excluded(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		name := path.Base(rel)
+		if strings.Contains(p, "/") {
+			name = rel
+		}
+
+		if ok, _ := path.Match(strings.TrimPrefix(p, "/"), name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Import: copy host dir hostPath into folder vfsPath, folders and files are
+// created as needed and take modified time of host as their times. The
+// rest of headers is taken from manifest of hostPath if Export wrote one
+func (cs *commandService) Import(hostPath, vfsPath string, opts ImportOptions) (ImportReport, error) {
+	unlock, err := cs.lock()
+	if err != nil {
+		return ImportReport{}, err
+	}
+	defer unlock()
+
+	report := ImportReport{Rejected: []string{}}
+
+	if cs.currentBlock == nil {
+		return report, NewError(ErrNoUser, "current block is nil")
+	}
+
+	for _, p := range opts.Exclude {
+		if _, err := path.Match(p, ""); err != nil {
+			return report, errorf(ErrInvalidName, "invalid pattern %s", p)
+		}
+	}
+
+	info, err := os.Stat(hostPath)
+	if err != nil {
+		return report, errorf(ErrNotExist, "host path %s not exist", hostPath)
+	}
+
+	if !info.IsDir() {
+		return report, errorf(ErrNotDir, "host path %s not a directory", hostPath)
+	}
+
+	block, err := cs.travelFolder(vfsPath)
+	if err != nil {
+		return report, xerrors.Errorf("err in travelFolder: %w", err)
+	}
+
+	manifest, err := readManifest(hostPath)
+	if err != nil {
+		return report, xerrors.Errorf("err in readManifest: %w", err)
+	}
+
+	// entries are created through current folder, it is back after import
+	cwd := cs.currentBlock.NodeID
+	defer func() {
+		b := cs.currentUser.BlockMap[cwd]
+		cs.currentBlock = &b
+	}()
+
+	// index is saved once after every entry is imported
+	cs.batch++
+	im := importer{cs: cs, opts: opts, report: &report, manifest: manifest}
+	err = im.dir(hostPath, "", block.NodeID)
+	if endErr := cs.endBatch(); err == nil && endErr != nil {
+		err = xerrors.Errorf("err in endBatch: %w", endErr)
+	}
+
+	return report, err
+}
+
+type importer struct {
+	cs       *commandService
+	opts     ImportOptions
+	report   *ImportReport
+	manifest map[string]ManifestEntry
+}
+
+func (im *importer) dir(host, rel string, id uint64) error {
+	entries, err := os.ReadDir(host)
+	if err != nil {
+		return xerrors.Errorf("err in os.ReadDir: %w", err)
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		r := path.Join(rel, name)
+		if r == ManifestFileName {
+			continue
+		}
+		im.report.Path = r
+
+		if err := im.entry(filepath.Join(host, name), r, id, e); err != nil {
+			return err
+		}
+
+		if im.opts.Progress != nil {
+			im.opts.Progress(*im.report)
+		}
+	}
+
+	return nil
+}
+
+func (im *importer) entry(host, rel string, id uint64, e os.DirEntry) error {
+	if excluded(im.opts.Exclude, rel) {
+		im.report.Excluded++
+		return nil
+	}
+
+	name := e.Name()
+	if validateName(name) != nil || !(e.IsDir() || e.Type().IsRegular()) {
+		im.report.Rejected = append(im.report.Rejected, rel)
+		return nil
+	}
+
+	info, err := e.Info()
+	if err != nil {
+		return xerrors.Errorf("err in Info: %w", err)
+	}
+
+	if e.IsDir() {
+		child, ok, err := im.folder(id, name, rel)
+		if err != nil || !ok {
+			return err
+		}
+
+		if err := im.dir(host, rel, child); err != nil {
+			return err
+		}
+
+		return im.setHeader(id, name, rel, info.ModTime())
+	}
+
+	file, err := os.Open(host)
+	if err != nil {
+		return xerrors.Errorf("err in os.Open: %w", err)
+	}
+	defer file.Close()
+
+	return im.file(id, name, rel, info.Size(), info.ModTime(), file)
+}
+
+// folder: block of folder name in block id, created if missing. Not ok if
+// a file has the name, it is kept
+func (im *importer) folder(id uint64, name, rel string) (uint64, bool, error) {
+	cs := im.cs
+	block := cs.currentUser.BlockMap[id]
+	cs.currentBlock = &block
+
+	header, exist := block.FileMap[name]
+	if exist && (header.Type != Directory || header.DirNodeID == nil) {
+		im.report.Rejected = append(im.report.Rejected, rel)
+		return 0, false, nil
+	}
+
+	if !exist {
+		if err := cs.CreateFolder(name); err != nil {
+			return 0, false, xerrors.Errorf("err in CreateFolder: %w", err)
+		}
+		im.report.Dirs++
+		header = cs.currentBlock.FileMap[name]
+	}
+
+	return *header.DirNodeID, true, nil
+}
+
+// file: content of file name in block id from r of size bytes modified at
+// t, a folder of the name is kept
+func (im *importer) file(id uint64, name, rel string, size int64, t time.Time, r io.Reader) error {
+	cs := im.cs
+	block := cs.currentUser.BlockMap[id]
+	cs.currentBlock = &block
+
+	header, exist := block.FileMap[name]
+	if exist && header.Type != File {
+		im.report.Rejected = append(im.report.Rejected, rel)
+		return nil
+	}
+
+	// time is set last, so a file interrupted before it is written again
+	if exist && header.Size == size && header.ModifiedTime.Equal(t) {
+		im.report.Unchanged++
+		return nil
+	}
+
+	if !exist {
+		if err := cs.CreateFile(name, ""); err != nil {
+			return xerrors.Errorf("err in CreateFile: %w", err)
+		}
+	}
+
+	if err := cs.WriteFile(name, r); err != nil {
+		return xerrors.Errorf("err in WriteFile: %w", err)
+	}
+
+	if err := im.setHeader(id, name, rel, t); err != nil {
+		return err
+	}
+
+	im.report.Files++
+	im.report.Bytes += size
+	return nil
+}
+
+// setHeader: times of entry name in block id from host, the rest from its
+// entry in manifest
+func (im *importer) setHeader(id uint64, name, rel string, t time.Time) error {
+	block := im.cs.currentUser.BlockMap[id]
+	im.cs.currentBlock = &block
+	entry, ok := im.manifest[rel]
+
+	err := im.cs.updateHeader(name, func(header *FileHeader) error {
+		header.CreatedTime, header.ModifiedTime = t, t
+		if !ok {
+			return nil
+		}
+
+		header.Description = entry.Description
+		header.Tags, header.Attrs = entry.Tags, entry.Attrs
+		if !entry.CreatedTime.IsZero() {
+			header.CreatedTime = entry.CreatedTime
+		}
+		return nil
+	})
+	if err != nil || !ok {
+		return err
+	}
+
+	// description is searched
+	return im.cs.reindex(im.cs.currentBlock, name)
+}
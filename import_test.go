@@ -0,0 +1,137 @@
+package vfsgo
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeHostFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	host := t.TempDir()
+	writeHostFiles(t, host, map[string]string{
+		"a/b.txt":           "bee",
+		"a/sub/c.txt":       "sea",
+		"a/sub/ignored.txt": "no",
+		"top.log":           "log",
+		"has space.txt":     "space",
+		"cache/x.js":        "js",
+	})
+	if err := os.Symlink("a/b.txt", filepath.Join(host, "link")); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	if err := os.Chtimes(filepath.Join(host, "a/b.txt"), mtime, mtime); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	cmdService := NewCommandService(t.TempDir())
+	must(t, cmdService.Register("testImport"))
+	must(t, cmdService.Use("testImport"))
+	must(t, cmdService.CreateFolder("seed"))
+
+	progress := 0
+	opts := ImportOptions{
+		Exclude:  []string{"*.log", "a/sub/ign*", "cache"},
+		Progress: func(ImportReport) { progress++ },
+	}
+
+	report, err := cmdService.Import(host, "/seed", opts)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if report.Dirs != 2 || report.Files != 2 || report.Bytes != 6 || report.Excluded != 3 || progress != 9 {
+		t.Errorf("unexpected report %+v progress %d", report, progress)
+		return
+	}
+
+	if !reflect.DeepEqual(report.Rejected, []string{"has space.txt", "link"}) {
+		t.Errorf("unexpected rejected %v", report.Rejected)
+		return
+	}
+
+	// current folder is kept
+	if cmdService.GetCurrentBlock().NodeID != 0 {
+		t.Error("current folder changed by import")
+		return
+	}
+
+	header, err := cmdService.Stat("/seed/a/b.txt")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if !header.ModifiedTime.Equal(mtime) || !header.CreatedTime.Equal(mtime) {
+		t.Errorf("unexpected times %v %v", header.CreatedTime, header.ModifiedTime)
+		return
+	}
+
+	if err := cmdService.ChangeFolder("/seed/a/sub"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if got := readAll(t, cmdService, "c.txt"); got != "sea" {
+		t.Errorf("unexpected content %q", got)
+		return
+	}
+
+	// resume: c.txt lost by interrupt, b.txt changed on host
+	if err := cmdService.DeleteFile("c.txt"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	writeHostFiles(t, host, map[string]string{"a/b.txt": "bumble"})
+
+	report, err = cmdService.Import(host, "/seed", opts)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if report.Dirs != 0 || report.Files != 2 || report.Unchanged != 0 {
+		t.Errorf("unexpected report of resume %+v", report)
+		return
+	}
+
+	report, err = cmdService.Import(host, "/seed", opts)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if report.Files != 0 || report.Unchanged != 2 {
+		t.Errorf("unexpected report of import again %+v", report)
+		return
+	}
+
+	if got := damageList(t, cmdService); got != "" {
+		t.Errorf("unexpected damages %s", got)
+		return
+	}
+
+	if _, err := cmdService.Import(filepath.Join(host, "top.log"), "/seed", ImportOptions{}); err == nil {
+		t.Error("import of host file should fail")
+		return
+	}
+}
@@ -0,0 +1,262 @@
+package vfsgo
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultListLimit = 100
+)
+
+// Filter: predicate on header, zero value fields do not filter anything
+type Filter struct {
+	// NameGlob: path.Match pattern of name
+	NameGlob string
+	// NameRegex: regexp syntax, unanchored
+	NameRegex string
+	Type      *FileType
+
+	// size range is inclusive
+	MinSize *int64
+	MaxSize *int64
+
+	// time ranges are exclusive
+	CreatedAfter   time.Time
+	CreatedBefore  time.Time
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+
+	DescriptionContains string
+
+	// Tags: entry must have every tag
+	Tags []string
+	// Attrs: entry must have every attribute, empty value match any value
+	Attrs map[string]string
+}
+
+// Compile: check patterns of filter and build the match func
+func (f *Filter) Compile() (func(h FileHeader) bool, error) {
+	if _, err := path.Match(f.NameGlob, ""); err != nil {
+		return nil, errorf(ErrInvalidName, "invalid name pattern %s", f.NameGlob)
+	}
+
+	var re *regexp.Regexp
+	if f.NameRegex != "" {
+		var err error
+		if re, err = regexp.Compile(f.NameRegex); err != nil {
+			return nil, errorf(ErrInvalidName, "invalid name regex %s", f.NameRegex)
+		}
+	}
+
+	filter := *f
+	return func(h FileHeader) bool {
+		return filter.match(h, re)
+	}, nil
+}
+
+func (f *Filter) match(h FileHeader, re *regexp.Regexp) bool {
+	if f.NameGlob != "" {
+		if ok, _ := path.Match(f.NameGlob, h.Name); !ok {
+			return false
+		}
+	}
+
+	if re != nil && !re.MatchString(h.Name) {
+		return false
+	}
+
+	if f.Type != nil && h.Type != *f.Type {
+		return false
+	}
+
+	if f.MinSize != nil && h.Size < *f.MinSize {
+		return false
+	}
+
+	if f.MaxSize != nil && h.Size > *f.MaxSize {
+		return false
+	}
+
+	if !f.CreatedAfter.IsZero() && !h.CreatedTime.After(f.CreatedAfter) {
+		return false
+	}
+
+	if !f.CreatedBefore.IsZero() && !h.CreatedTime.Before(f.CreatedBefore) {
+		return false
+	}
+
+	if !f.ModifiedAfter.IsZero() && !h.ModifiedTime.After(f.ModifiedAfter) {
+		return false
+	}
+
+	if !f.ModifiedBefore.IsZero() && !h.ModifiedTime.Before(f.ModifiedBefore) {
+		return false
+	}
+
+	if f.DescriptionContains != "" && !strings.Contains(h.Description, f.DescriptionContains) {
+		return false
+	}
+
+	for _, tag := range f.Tags {
+		if !h.HasTag(tag) {
+			return false
+		}
+	}
+
+	for key, value := range f.Attrs {
+		if v, ok := h.Attrs[key]; !ok || (value != "" && v != value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ListQuery: filter, order and page of ListPage
+type ListQuery struct {
+	Filter
+
+	Keys      []SortKey
+	DirsFirst bool
+
+	// Cursor: NextCursor of previous page, empty for the first page
+	Cursor string
+	// Limit: max entries in page, DefaultListLimit if not positive
+	Limit int
+}
+
+// Page: one page of entries, NextCursor is empty on the last page
+type Page struct {
+	Entries    []FileHeader
+	NextCursor string
+}
+
+// cursor: sort values of the last entry of page with the order it was made
+// for, next page start after it even if the entry is removed meanwhile
+type cursor struct {
+	Keys      []SortKey
+	DirsFirst bool
+	Last      FileHeader
+}
+
+func encodeCursor(q *ListQuery, last FileHeader) string {
+	// only values compared by sort are kept
+	last = FileHeader{
+		Type:         last.Type,
+		Name:         last.Name,
+		Description:  last.Description,
+		Size:         last.Size,
+		CreatedTime:  last.CreatedTime,
+		ModifiedTime: last.ModifiedTime,
+	}
+
+	b, _ := json.Marshal(cursor{Keys: q.Keys, DirsFirst: q.DirsFirst, Last: last})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(q *ListQuery) (*FileHeader, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, NewError(ErrInvalidName, "invalid cursor")
+	}
+
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, NewError(ErrInvalidName, "invalid cursor")
+	}
+
+	if c.DirsFirst != q.DirsFirst || len(c.Keys) != len(q.Keys) {
+		return nil, NewError(ErrInvalidName, "cursor not match order of query")
+	}
+
+	for i := range c.Keys {
+		if c.Keys[i] != q.Keys[i] {
+			return nil, NewError(ErrInvalidName, "cursor not match order of query")
+		}
+	}
+
+	return &c.Last, nil
+}
+
+// pageHeap: max heap by sort order, keep the smallest limit entries
+type pageHeap struct {
+	entries []FileHeader
+	less    func(a, b FileHeader) bool
+}
+
+func (h *pageHeap) Len() int           { return len(h.entries) }
+func (h *pageHeap) Less(i, j int) bool { return h.less(h.entries[j], h.entries[i]) }
+func (h *pageHeap) Swap(i, j int)      { h.entries[i], h.entries[j] = h.entries[j], h.entries[i] }
+func (h *pageHeap) Push(x interface{}) { h.entries = append(h.entries, x.(FileHeader)) }
+func (h *pageHeap) Pop() interface{} {
+	last := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	return last
+}
+
+// Paginate: page of entries in fileMap for query, only limit entries are
+// kept while scanning so a large folder is not sorted entirely
+func Paginate(fileMap map[string]FileHeader, q ListQuery) (Page, error) {
+	match, err := q.Compile()
+	if err != nil {
+		return Page{}, err
+	}
+
+	after, err := decodeCursor(&q)
+	if err != nil {
+		return Page{}, err
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+
+	less := func(a, b FileHeader) bool {
+		return lessHeader(a, b, q.Keys, q.DirsFirst)
+	}
+
+	h := &pageHeap{entries: make([]FileHeader, 0, limit+1), less: less}
+	more := false
+	for _, file := range fileMap {
+		if after != nil && !less(*after, file) {
+			continue
+		}
+
+		if !match(file) {
+			continue
+		}
+
+		if h.Len() < limit {
+			heap.Push(h, file)
+			continue
+		}
+
+		// one more entry than limit, so there is next page
+		more = true
+		if less(file, h.entries[0]) {
+			h.entries[0] = file
+			heap.Fix(h, 0)
+		}
+	}
+
+	entries := h.entries
+	SortHeaders(entries, q.Keys, q.DirsFirst)
+
+	page := Page{Entries: entries}
+	if more {
+		page.NextCursor = encodeCursor(&q, entries[len(entries)-1])
+	}
+
+	return page, nil
+}
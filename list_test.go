@@ -0,0 +1,130 @@
+package vfsgo
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPaginate(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fileMap := map[string]FileHeader{}
+	for i := 0; i < 25; i++ {
+		name := fmt.Sprintf("file%d", i)
+		desc := ""
+		if i%2 == 0 {
+			desc = "even"
+		}
+		fileMap[name] = FileHeader{Type: File, Name: name, Description: desc, CreatedTime: base.Add(time.Duration(i) * time.Hour)}
+	}
+	fileMap["dir"] = FileHeader{Type: Directory, Name: "dir", CreatedTime: base}
+
+	names := []string{}
+	q := ListQuery{Limit: 10, DirsFirst: true}
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Error("too many pages")
+			return
+		}
+
+		page, err := Paginate(fileMap, q)
+		if err != nil {
+			t.Error(err.Error())
+			return
+		}
+
+		for _, e := range page.Entries {
+			names = append(names, e.Name)
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+
+	if len(names) != 26 || names[0] != "dir" || names[1] != "file0" || names[3] != "file2" || names[25] != "file24" {
+		t.Errorf("unexpected order %v", names)
+		return
+	}
+
+	fileType := File
+	page, err := Paginate(fileMap, ListQuery{
+		Filter: Filter{
+			NameGlob:            "file1*",
+			Type:                &fileType,
+			DescriptionContains: "even",
+			CreatedAfter:        base.Add(10 * time.Hour),
+		},
+		Keys: []SortKey{{Field: SortByCreatedTime, Order: DESC}},
+	})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if len(page.Entries) != 4 || page.Entries[0].Name != "file18" || page.Entries[3].Name != "file12" || page.NextCursor != "" {
+		t.Errorf("unexpected filtered page %v", page.Entries)
+		return
+	}
+
+	first, _ := Paginate(fileMap, ListQuery{Limit: 1})
+	if _, err := Paginate(fileMap, ListQuery{Limit: 1, Cursor: first.NextCursor, DirsFirst: true}); err == nil {
+		t.Error("cursor of other order should fail")
+		return
+	}
+
+	if _, err := Paginate(fileMap, ListQuery{Filter: Filter{NameGlob: "["}}); err == nil {
+		t.Error("bad pattern should fail")
+		return
+	}
+}
+
+func TestListPage(t *testing.T) {
+	cmdService := NewCommandService(t.TempDir())
+
+	if err := cmdService.Register("testListPage"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.Use("testListPage"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	for _, name := range []string{"c", "a", "b"} {
+		if err := cmdService.CreateFile(name, ""); err != nil {
+			t.Error(err.Error())
+			return
+		}
+	}
+
+	page, err := cmdService.ListPage(".", ListQuery{Limit: 2})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if len(page.Entries) != 2 || page.Entries[0].Name != "a" || page.NextCursor == "" {
+		t.Error("unexpected first page")
+		return
+	}
+
+	// removed entry does not break the cursor
+	if err := cmdService.DeleteFile("b"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	page, err = cmdService.ListPage(".", ListQuery{Limit: 2, Cursor: page.NextCursor})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if len(page.Entries) != 1 || page.Entries[0].Name != "c" || page.NextCursor != "" {
+		t.Error("unexpected last page")
+		return
+	}
+}
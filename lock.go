@@ -0,0 +1,141 @@
+package vfsgo
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// LockFileName: file in root locked by every process using the root,
+	// shared by commands and exclusive by Backup
+	LockFileName = ".lock"
+)
+
+// rootLock: lock of root shared by services of this process. The process
+// holds the flock of LockFileName shared while any command runs. Commands
+// only wait for a running backup, so a command calling another never blocks
+type rootLock struct {
+	path string
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	file     *os.File
+	commands int
+	backup   bool
+}
+
+var rootLocks = struct {
+	sync.Mutex
+	locks map[string]*rootLock
+}{locks: make(map[string]*rootLock)}
+
+func lockOf(root string) *rootLock {
+	rootLocks.Lock()
+	defer rootLocks.Unlock()
+
+	path := filepath.Join(filepath.Clean(root), LockFileName)
+	l, ok := rootLocks.locks[path]
+	if !ok {
+		l = &rootLock{path: path}
+		l.cond = sync.NewCond(&l.mu)
+		rootLocks.locks[path] = l
+	}
+
+	return l
+}
+
+// flock: take flock of lock file, a root not existing has nothing to lock
+func (l *rootLock) flock(exclusive bool) error {
+	if l.file == nil {
+		file, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0644)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return xerrors.Errorf("error in os.OpenFile: %w", err)
+		}
+		l.file = file
+	}
+
+	if err := flockFile(l.file, exclusive); err != nil {
+		return xerrors.Errorf("error in flockFile: %w", err)
+	}
+
+	return nil
+}
+
+func (l *rootLock) funlock() {
+	if l.file != nil {
+		funlockFile(l.file)
+	}
+}
+
+// lockCommand: lock root for a command, unlock when it ends
+func lockCommand(root string) (func(), error) {
+	l := lockOf(root)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.backup {
+		l.cond.Wait()
+	}
+
+	if l.commands == 0 {
+		if err := l.flock(false); err != nil {
+			return nil, err
+		}
+	}
+	l.commands++
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		l.commands--
+		if l.commands == 0 {
+			l.funlock()
+			l.cond.Broadcast()
+		}
+	}, nil
+}
+
+// lockBackup: lock root once no command of any process runs, commands wait
+// until unlock
+func lockBackup(root string) (func(), error) {
+	l := lockOf(root)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.backup || l.commands > 0 {
+		l.cond.Wait()
+	}
+
+	if err := l.flock(true); err != nil {
+		return nil, err
+	}
+	l.backup = true
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		l.funlock()
+		l.backup = false
+		l.cond.Broadcast()
+	}, nil
+}
+
+// lock: lock root of service for a command, see lockCommand
+func (cs *commandService) lock() (func(), error) {
+	unlock, err := lockCommand(cs.root)
+	if err != nil {
+		return nil, xerrors.Errorf("err in lockCommand: %w", err)
+	}
+
+	return unlock, nil
+}
@@ -0,0 +1,277 @@
+package vfsgo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"golang.org/x/xerrors"
+)
+
+// Damage: problem found by Verify at Path
+type Damage struct {
+	Path   string
+	Reason string
+}
+
+// headerHash: sha256 of header in plain, content is covered by the hashes
+// of its blobs in the header
+func headerHash(header FileHeader) (string, error) {
+	buf, err := json.Marshal(header)
+	if err != nil {
+		return "", xerrors.Errorf("error in json.Marshal: %w", err)
+	}
+
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// blockHash: sha256 over entries of block in name order, every entry is its
+// name, hash of its header and hash of its folder block from children
+func blockHash(block *BlockINode, children map[uint64]string) (string, error) {
+	names := make([]string, 0, len(block.FileMap))
+	for name := range block.FileMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		header := block.FileMap[name]
+		hash, err := headerHash(header)
+		if err != nil {
+			return "", err
+		}
+
+		child := ""
+		if header.Type == Directory && header.DirNodeID != nil {
+			child = children[*header.DirNodeID]
+		}
+
+		fmt.Fprintf(h, "%s\x00%s\x00%s\n", name, hash, child)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// merkleHashes: hash of every block reachable from root block of user,
+// folders are hashed before the block they are in
+func merkleHashes(user *User) (map[uint64]string, error) {
+	hashes := make(map[uint64]string)
+
+	var visit func(id uint64) error
+	visit = func(id uint64) error {
+		block, ok := user.BlockMap[id]
+		if !ok {
+			return nil
+		}
+		// guard against a loop of broken folders
+		hashes[id] = ""
+
+		for _, header := range block.FileMap {
+			if header.Type != Directory || header.DirNodeID == nil {
+				continue
+			}
+
+			if _, ok := hashes[*header.DirNodeID]; ok {
+				continue
+			}
+
+			if err := visit(*header.DirNodeID); err != nil {
+				return err
+			}
+		}
+
+		hash, err := blockHash(&block, hashes)
+		if err != nil {
+			return err
+		}
+
+		hashes[id] = hash
+		return nil
+	}
+
+	if err := visit(0); err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+// VerifyTree: check blocks, headers and contents of user on disk against
+// each other and the merkle hashes recorded by User.Save. Damage of a block
+// is where its own entries changed, the root hash covers the whole tree
+func VerifyTree(user *User) []Damage {
+	v := verifier{user: user, checked: make(map[string]bool)}
+
+	root := v.block(0, "/")
+	if user.RootHash != "" && root != user.RootHash {
+		v.damage("/", "root hash changed: %s, recorded %s", root, user.RootHash)
+	}
+
+	return v.damages
+}
+
+type verifier struct {
+	user    *User
+	damages []Damage
+
+	// checked: blobs verified already, a blob is shared by many files
+	checked map[string]bool
+}
+
+func (v *verifier) damage(path, format string, args ...interface{}) {
+	v.damages = append(v.damages, Damage{Path: path, Reason: fmt.Sprintf(format, args...)})
+}
+
+// block: hash of block id at dir computed from disk, entries of block are
+// checked on the way
+func (v *verifier) block(id uint64, dir string) string {
+	block, err := GetBlock(v.user, id)
+	if err != nil {
+		v.damage(dir, "block unreadable: %v", err)
+		return ""
+	}
+
+	names := make([]string, 0, len(block.FileMap))
+	for name := range block.FileMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	computed := make(map[uint64]string)
+	for _, name := range names {
+		header := block.FileMap[name]
+		p := joinPath(dir, name)
+
+		v.header(&block, name, p)
+
+		if header.Type != Directory || header.DirNodeID == nil {
+			continue
+		}
+
+		if _, ok := v.user.BlockMap[*header.DirNodeID]; !ok {
+			v.damage(p, "block %d not exist", *header.DirNodeID)
+			continue
+		}
+		computed[*header.DirNodeID] = v.block(*header.DirNodeID, p)
+	}
+
+	// own entries are compared with recorded hashes of folders, so a
+	// change is reported at the block it is made in only
+	if recorded, ok := v.user.BlockHashes[id]; ok {
+		own, err := blockHash(&block, v.user.BlockHashes)
+		if err != nil {
+			v.damage(dir, "block not hashed: %v", err)
+			return ""
+		}
+
+		if own != recorded {
+			v.damage(dir, "block changed")
+		}
+	}
+
+	hash, err := blockHash(&block, computed)
+	if err != nil {
+		v.damage(dir, "block not hashed: %v", err)
+		return ""
+	}
+
+	return hash
+}
+
+// header: header file of entry match its entry in block, and its content
+// match the hashes of its blobs
+func (v *verifier) header(block *BlockINode, name, p string) {
+	entry := block.FileMap[name]
+
+	header, err := GetFile(block, name)
+	if err != nil {
+		v.damage(p, "header unreadable: %v", err)
+		return
+	}
+
+	want, err := headerHash(entry)
+	if err != nil {
+		v.damage(p, "header not hashed: %v", err)
+		return
+	}
+
+	if got, err := headerHash(header); err != nil || got != want {
+		v.damage(p, "header changed")
+	}
+
+	if entry.Type != File {
+		return
+	}
+
+	chunks := entry.contentChunks()
+	if len(chunks) == 0 {
+		// content beside header has no hash to check
+		if _, err := os.Stat(entry.GetContentPath(block.GetBlockPath())); err != nil && !os.IsNotExist(err) {
+			v.damage(p, "content unreadable: %v", err)
+		}
+		return
+	}
+
+	store := block.blobStore()
+	size := int64(0)
+	for _, c := range chunks {
+		size += c.Size
+		if v.checked[c.Hash] {
+			continue
+		}
+		v.checked[c.Hash] = true
+
+		if err := store.Verify(c.Hash); err != nil {
+			v.damage(p, "content corrupted: %v", err)
+			return
+		}
+	}
+
+	if size != entry.Size {
+		v.damage(p, "size %d not match content %d", entry.Size, size)
+	}
+}
+
+// Verify: check the whole tree of current user as stored on disk, see
+// VerifyTree
+func (cs *commandService) Verify() ([]Damage, error) {
+	if cs.currentUser == nil {
+		return nil, NewError(ErrNoUser, "current user is nil")
+	}
+
+	// recorded hashes are read again, the ones in memory may be newer than
+	// a damaged user inode
+	user, err := GetUser(cs.currentUser.RootPath, cs.currentUser.Name)
+	if err != nil {
+		return []Damage{{Path: "/", Reason: fmt.Sprintf("user unreadable: %v", err)}}, nil
+	}
+
+	return VerifyTree(&user), nil
+}
+
+// RootHash: merkle hash of the whole tree of current user, two replicas
+// hold the same tree when their root hashes are equal
+func (cs *commandService) RootHash() (string, error) {
+	unlock, err := cs.lock()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	if cs.currentUser == nil {
+		return "", NewError(ErrNoUser, "current user is nil")
+	}
+
+	if cs.currentUser.RootHash == "" {
+		if err := cs.currentUser.Save(); err != nil {
+			return "", xerrors.Errorf("err in currentUser.Save: %w", err)
+		}
+	}
+
+	return cs.currentUser.RootHash, nil
+}
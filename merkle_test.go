@@ -0,0 +1,199 @@
+package vfsgo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func damageList(t *testing.T, cmdService ICommandService) string {
+	damages, err := cmdService.Verify()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	list := make([]string, 0, len(damages))
+	for _, d := range damages {
+		list = append(list, d.Path+" "+strings.SplitN(d.Reason, ":", 2)[0])
+	}
+
+	return strings.Join(list, ",")
+}
+
+func TestRootHash(t *testing.T) {
+	cmdService := newFindTree(t)
+
+	hash, err := cmdService.RootHash()
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if len(hash) != 64 {
+		t.Errorf("unexpected root hash %s", hash)
+		return
+	}
+
+	if got := damageList(t, cmdService); got != "" {
+		t.Errorf("unexpected damages %s", got)
+		return
+	}
+
+	// same tree loaded again has the same hash
+	other := NewCommandService(cmdService.GetCurrentUser().RootPath)
+	if err := other.Use("testFind"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if got, _ := other.RootHash(); got != hash {
+		t.Errorf("unexpected root hash %s after load", got)
+		return
+	}
+
+	// change deep in tree changes root
+	must(t, cmdService.ChangeFolder("/a/b"))
+	must(t, cmdService.WriteFile("deep.txt", strings.NewReader("changed")))
+
+	if got, _ := cmdService.RootHash(); got == hash {
+		t.Error("root hash should change by write")
+		return
+	}
+
+	if got := damageList(t, cmdService); got != "" {
+		t.Errorf("unexpected damages %s", got)
+		return
+	}
+}
+
+func TestVerifyDamage(t *testing.T) {
+	cmdService := newFindTree(t)
+	user := cmdService.GetCurrentUser()
+
+	// content of /a/note.txt rot
+	a := user.BlockMap[*user.BlockMap[0].FileMap["a"].DirNodeID]
+	note := a.FileMap["note.txt"]
+	path := a.blobStore().Path(note.ContentHash)
+	if err := ioutil.WriteFile(path, []byte("hellO"), 0666); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if got := damageList(t, cmdService); got != "/a/note.txt content corrupted" {
+		t.Errorf("unexpected damages %s", got)
+		return
+	}
+
+	if err := ioutil.WriteFile(path, []byte("hello"), 0666); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// header file of /top.log changed
+	root := user.BlockMap[0]
+	top := root.FileMap["top.log"]
+	top.Description = "changed"
+	buf, _ := json.Marshal(top)
+	if err := ioutil.WriteFile(root.GetBlockPath()+"/"+top.HashFileName, buf, 0666); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if got := damageList(t, cmdService); got != "/top.log header changed" {
+		t.Errorf("unexpected damages %s", got)
+		return
+	}
+
+	// entry in block of /a/b changed along with its header, only the
+	// recorded hashes tell
+	b := user.BlockMap[*a.FileMap["b"].DirNodeID]
+	deep := b.FileMap["deep.txt"]
+	deep.Description = "changed"
+	b.FileMap["deep.txt"] = deep
+
+	buf, _ = json.Marshal(deep)
+	if err := ioutil.WriteFile(b.GetBlockPath()+"/"+deep.HashFileName, buf, 0666); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	buf, _ = json.Marshal(b)
+	if err := ioutil.WriteFile(b.GetBlockINodePath(), buf, 0666); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	want := "/a/b block changed,/top.log header changed,/ root hash changed"
+	if got := damageList(t, cmdService); got != want {
+		t.Errorf("unexpected damages %s", got)
+		return
+	}
+}
+
+func TestVerifyLegacyContent(t *testing.T) {
+	root := t.TempDir()
+	cmdService := NewCommandService(root)
+	if err := cmdService.Register("legacy"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.Use("legacy"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.CreateFile("old.txt", "old"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// content written beside header before the blob store
+	block := cmdService.GetCurrentBlock()
+	old := block.FileMap["old.txt"]
+	legacy := old.GetContentPath(block.GetBlockPath())
+	if err := ioutil.WriteFile(legacy, []byte("old content"), 0644); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// content is put into store when user is loaded
+	cmdService = NewCommandService(root)
+	if err := cmdService.Use("legacy"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	header := cmdService.GetCurrentBlock().FileMap["old.txt"]
+	if header.ContentHash != contentHash("old content") || header.Size != int64(len("old content")) {
+		t.Errorf("legacy content not migrated %+v", header)
+		return
+	}
+
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("legacy content left: %v", err)
+		return
+	}
+
+	if got := readAll(t, cmdService, "old.txt"); got != "old content" {
+		t.Errorf("unexpected content %q", got)
+		return
+	}
+
+	if got := damageList(t, cmdService); got != "" {
+		t.Errorf("unexpected damages %s", got)
+		return
+	}
+
+	if err := ioutil.WriteFile(NewBlobStore(root).Path(header.ContentHash), []byte("rotten"), 0644); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if got := damageList(t, cmdService); got != "/old.txt content corrupted" {
+		t.Errorf("unexpected damages %s", got)
+		return
+	}
+}
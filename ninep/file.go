@@ -0,0 +1,587 @@
+package ninep
+
+import (
+	"errors"
+	"hash/fnv"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/hugelgupf/p9/fsimpl/templatefs"
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+	"github.com/lemotw/vfsgo"
+	"golang.org/x/xerrors"
+)
+
+const (
+	iounit = 64 * 1024
+
+	// unlinkat(2) flag to remove directory
+	atRemoveDir = 0x200
+)
+
+// file: fid of the attached tree, s is nil for the directory of users,
+// otherwise path is the absolute path of header inside user s
+type file struct {
+	templatefs.NotImplementedFile
+
+	a      *attacher
+	s      *session
+	path   string
+	header vfsgo.FileHeader
+
+	// content is set once opened for read, upload once opened for write
+	content vfsgo.Content
+	upload  *os.File
+	dirty   bool
+}
+
+var _ p9.File = &file{}
+
+func (f *file) isUsers() bool {
+	return f.s == nil
+}
+
+func (f *file) isDir() bool {
+	return f.isUsers() || f.header.Type == vfsgo.Directory
+}
+
+// qid: directories use block id, files use the hash file name of header,
+// both hashed with the user name
+func (f *file) qid() p9.QID {
+	if f.isUsers() {
+		return p9.QID{Type: p9.TypeDir}
+	}
+
+	if f.isDir() {
+		h := fnv.New64a()
+		h.Write([]byte(f.s.name))
+		h.Write([]byte(strconv.FormatUint(*f.header.DirNodeID, 10)))
+		return p9.QID{Type: p9.TypeDir, Path: h.Sum64() | 1<<63}
+	}
+
+	// hash file name is not trusted to be hex of any length
+	h := fnv.New64a()
+	h.Write([]byte(f.s.name))
+	h.Write([]byte(f.header.HashFileName))
+
+	return p9.QID{
+		Type:    p9.TypeRegular,
+		Version: uint32(f.header.ModifiedTime.UnixNano()),
+		Path:    h.Sum64() &^ (1 << 63),
+	}
+}
+
+// stat: header of the absolute path p in session s
+func stat(s *session, p string) (vfsgo.FileHeader, error) {
+	header, err := s.cs.Stat(p)
+	if err != nil {
+		return vfsgo.FileHeader{}, errno(err)
+	}
+
+	return header, nil
+}
+
+// chdir: change current folder of session to dir of p and return base name
+func chdir(s *session, p string) (string, error) {
+	dir, name := path.Split(p)
+	if err := s.cs.ChangeFolder(dir); err != nil {
+		return "", errno(err)
+	}
+
+	return name, nil
+}
+
+func (f *file) Walk(names []string) ([]p9.QID, p9.File, error) {
+	if len(names) == 0 {
+		return nil, &file{a: f.a, s: f.s, path: f.path, header: f.header}, nil
+	}
+
+	if !f.isDir() {
+		return nil, nil, linux.ENOTDIR
+	}
+
+	qids := make([]p9.QID, 0, len(names))
+	cur := &file{a: f.a, s: f.s, path: f.path, header: f.header}
+
+	for _, name := range names {
+		if !cur.isDir() {
+			return nil, nil, linux.ENOTDIR
+		}
+
+		next, err := cur.walkOne(name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		qids = append(qids, next.qid())
+		cur = next
+	}
+
+	return qids, cur, nil
+}
+
+func (f *file) walkOne(name string) (*file, error) {
+	if f.isUsers() {
+		if name == ".." {
+			return f, nil
+		}
+
+		s, err := f.a.session(name)
+		if err != nil {
+			return nil, linux.ENOENT
+		}
+
+		return f.a.userRoot(s)
+	}
+
+	// .. of the user root go back to directory of users
+	if name == ".." && f.path == "/" {
+		return &file{a: f.a}, nil
+	}
+
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+
+	p := path.Clean(path.Join(f.path, name))
+	header, err := stat(f.s, p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{a: f.a, s: f.s, path: p, header: header}, nil
+}
+
+// userRoot: root folder of user in session s
+func (a *attacher) userRoot(s *session) (*file, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	header, err := stat(s, "/")
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{a: a, s: s, path: "/", header: header}, nil
+}
+
+// name: entry name in parent directory, user root is named by user
+func (f *file) name() string {
+	if f.path == "/" {
+		return f.s.name
+	}
+
+	return path.Base(f.path)
+}
+
+func (f *file) StatFS() (p9.FSStat, error) {
+	return p9.FSStat{
+		Type:       0x01021997, /* V9FS_MAGIC */
+		BlockSize:  4096,
+		NameLength: 255,
+	}, nil
+}
+
+func (f *file) GetAttr(req p9.AttrMask) (p9.QID, p9.AttrMask, p9.Attr, error) {
+	attr := p9.Attr{
+		Mode:             p9.ModeRegular | 0644,
+		NLink:            1,
+		Size:             uint64(f.header.Size),
+		BlockSize:        4096,
+		Blocks:           uint64(f.header.Size+511) / 512,
+		MTimeSeconds:     uint64(f.header.ModifiedTime.Unix()),
+		MTimeNanoSeconds: uint64(f.header.ModifiedTime.Nanosecond()),
+		CTimeSeconds:     uint64(f.header.ModifiedTime.Unix()),
+		CTimeNanoSeconds: uint64(f.header.ModifiedTime.Nanosecond()),
+		BTimeSeconds:     uint64(f.header.CreatedTime.Unix()),
+		BTimeNanoSeconds: uint64(f.header.CreatedTime.Nanosecond()),
+	}
+
+	if f.isDir() {
+		attr.Mode = p9.ModeDirectory | 0755
+		attr.NLink = 2
+		attr.Size = 0
+		attr.Blocks = 0
+	}
+
+	valid := p9.AttrMask{
+		Mode:   true,
+		NLink:  true,
+		Size:   true,
+		Blocks: true,
+		MTime:  true,
+		CTime:  true,
+		BTime:  true,
+	}
+
+	return f.qid(), valid, attr, nil
+}
+
+func (f *file) SetAttr(valid p9.SetAttrMask, attr p9.SetAttr) error {
+	if !valid.Size {
+		// mode, owner and times are not kept
+		return nil
+	}
+
+	if f.isDir() {
+		return linux.EISDIR
+	}
+
+	if f.upload != nil {
+		if err := f.upload.Truncate(int64(attr.Size)); err != nil {
+			return xerrors.Errorf("error in upload.Truncate: %w", err)
+		}
+		f.dirty = true
+		return nil
+	}
+
+	if attr.Size != 0 {
+		return linux.EINVAL
+	}
+
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+
+	name, err := chdir(f.s, f.path)
+	if err != nil {
+		return err
+	}
+
+	if err := f.s.cs.WriteFile(name, strings.NewReader("")); err != nil {
+		return xerrors.Errorf("error in cs.WriteFile: %w", err)
+	}
+
+	return f.refresh()
+}
+
+// refresh: reload header after change, caller hold the session lock
+func (f *file) refresh() error {
+	header, err := stat(f.s, f.path)
+	if err != nil {
+		return err
+	}
+	f.header = header
+
+	return nil
+}
+
+func (f *file) Open(mode p9.OpenFlags) (p9.QID, uint32, error) {
+	if f.isDir() {
+		if mode.Mode() != p9.ReadOnly {
+			return p9.QID{}, 0, linux.EISDIR
+		}
+		return f.qid(), 0, nil
+	}
+
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+
+	name, err := chdir(f.s, f.path)
+	if err != nil {
+		return p9.QID{}, 0, err
+	}
+
+	content, err := f.s.cs.ReadFile(name)
+	if err != nil {
+		return p9.QID{}, 0, errno(err)
+	}
+
+	if mode.Mode() == p9.ReadOnly {
+		file, ok := content.(vfsgo.Content)
+		if !ok {
+			content.Close()
+			return p9.QID{}, 0, linux.EIO
+		}
+		f.content = file
+		return f.qid(), iounit, nil
+	}
+	defer content.Close()
+
+	// write at random offset to a local copy, flush on fsync and close
+	upload, err := os.CreateTemp("", "vfsgo-9p-*")
+	if err != nil {
+		return p9.QID{}, 0, xerrors.Errorf("error in os.CreateTemp: %w", err)
+	}
+
+	if _, err := io.Copy(upload, content); err != nil {
+		upload.Close()
+		os.Remove(upload.Name())
+		return p9.QID{}, 0, xerrors.Errorf("error in io.Copy: %w", err)
+	}
+	f.upload = upload
+
+	return f.qid(), iounit, nil
+}
+
+func (f *file) ReadAt(p []byte, offset int64) (int, error) {
+	if f.upload != nil {
+		return f.upload.ReadAt(p, offset)
+	}
+
+	if f.content == nil {
+		return 0, linux.EBADF
+	}
+
+	return f.content.ReadAt(p, offset)
+}
+
+func (f *file) WriteAt(p []byte, offset int64) (int, error) {
+	if f.upload == nil {
+		return 0, linux.EBADF
+	}
+
+	f.dirty = true
+	return f.upload.WriteAt(p, offset)
+}
+
+// flush: write local copy back to vfsgo
+func (f *file) flush() error {
+	if f.upload == nil || !f.dirty {
+		return nil
+	}
+
+	if _, err := f.upload.Seek(0, io.SeekStart); err != nil {
+		return xerrors.Errorf("error in upload.Seek: %w", err)
+	}
+
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+
+	name, err := chdir(f.s, f.path)
+	if err != nil {
+		return err
+	}
+
+	if err := f.s.cs.WriteFile(name, f.upload); err != nil {
+		return xerrors.Errorf("error in cs.WriteFile: %w", err)
+	}
+	f.dirty = false
+
+	return f.refresh()
+}
+
+func (f *file) FSync() error {
+	return f.flush()
+}
+
+func (f *file) Close() error {
+	err := f.flush()
+
+	if f.content != nil {
+		f.content.Close()
+		f.content = nil
+	}
+
+	if f.upload != nil {
+		f.upload.Close()
+		os.Remove(f.upload.Name())
+		f.upload = nil
+	}
+
+	return err
+}
+
+func (f *file) Readdir(offset uint64, count uint32) (p9.Dirents, error) {
+	if !f.isDir() {
+		return nil, linux.ENOTDIR
+	}
+
+	children, err := f.children()
+	if err != nil {
+		return nil, err
+	}
+
+	if offset >= uint64(len(children)) {
+		return nil, nil
+	}
+
+	dirents := make(p9.Dirents, 0, len(children)-int(offset))
+	for i, child := range children[offset:] {
+		qid := child.qid()
+		dirents = append(dirents, p9.Dirent{
+			QID:    qid,
+			Type:   qid.Type,
+			Offset: offset + uint64(i) + 1,
+			Name:   child.name(),
+		})
+	}
+
+	return dirents, nil
+}
+
+// children: entries of the directory sorted by name, so offset is stable
+func (f *file) children() ([]*file, error) {
+	if f.isUsers() {
+		names, err := f.a.users()
+		if err != nil {
+			return nil, err
+		}
+
+		children := make([]*file, 0, len(names))
+		for _, name := range names {
+			s, err := f.a.session(name)
+			if err != nil {
+				continue
+			}
+
+			root, err := f.a.userRoot(s)
+			if err != nil {
+				continue
+			}
+			children = append(children, root)
+		}
+
+		return children, nil
+	}
+
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+
+	sortField, sortOrder := vfsgo.SortByName, vfsgo.ASC
+	names, err := f.s.cs.List(f.path, &sortField, &sortOrder)
+	if err != nil {
+		return nil, errno(err)
+	}
+
+	children := make([]*file, 0, len(names))
+	for _, name := range names {
+		p := path.Join(f.path, strings.TrimSuffix(name, "/"))
+		header, err := stat(f.s, p)
+		if err != nil {
+			return nil, err
+		}
+
+		children = append(children, &file{a: f.a, s: f.s, path: p, header: header})
+	}
+
+	return children, nil
+}
+
+func (f *file) Create(name string, mode p9.OpenFlags, permissions p9.FileMode, _ p9.UID, _ p9.GID) (p9.File, p9.QID, uint32, error) {
+	if f.isUsers() {
+		return nil, p9.QID{}, 0, linux.EPERM
+	}
+
+	if err := f.mutate(func() error { return f.s.cs.CreateFile(name, "") }); err != nil {
+		return nil, p9.QID{}, 0, err
+	}
+
+	_, newFile, err := f.Walk([]string{name})
+	if err != nil {
+		return nil, p9.QID{}, 0, err
+	}
+
+	qid, unit, err := newFile.Open(mode)
+	if err != nil {
+		return nil, p9.QID{}, 0, err
+	}
+
+	return newFile, qid, unit, nil
+}
+
+func (f *file) Mkdir(name string, permissions p9.FileMode, _ p9.UID, _ p9.GID) (p9.QID, error) {
+	if f.isUsers() {
+		return p9.QID{}, linux.EPERM
+	}
+
+	if err := f.mutate(func() error { return f.s.cs.CreateFolder(name) }); err != nil {
+		return p9.QID{}, err
+	}
+
+	qids, _, err := f.Walk([]string{name})
+	if err != nil {
+		return p9.QID{}, err
+	}
+
+	return qids[0], nil
+}
+
+func (f *file) UnlinkAt(name string, flags uint32) error {
+	if f.isUsers() {
+		return linux.EPERM
+	}
+
+	return f.mutate(func() error {
+		header, err := stat(f.s, path.Join(f.path, name))
+		if err != nil {
+			return err
+		}
+
+		if header.Type == vfsgo.Directory {
+			if flags&atRemoveDir == 0 {
+				return linux.EISDIR
+			}
+			return f.s.cs.DeleteFolder(name)
+		}
+
+		if flags&atRemoveDir != 0 {
+			return linux.ENOTDIR
+		}
+		return f.s.cs.DeleteFile(name)
+	})
+}
+
+func (f *file) RenameAt(oldName string, newDir p9.File, newName string) error {
+	dir, ok := newDir.(*file)
+	if !ok || f.isUsers() || dir.s != f.s || dir.path != f.path {
+		// moving between folders is not supported by command service
+		return linux.EXDEV
+	}
+
+	return f.mutate(func() error {
+		header, err := stat(f.s, path.Join(f.path, oldName))
+		if err != nil {
+			return err
+		}
+
+		if header.Type == vfsgo.Directory {
+			return f.s.cs.RenameFolder(oldName, newName)
+		}
+		return f.s.cs.RenameFile(oldName, newName, header.Description)
+	})
+}
+
+func (f *file) Rename(newDir p9.File, newName string) error {
+	if f.isUsers() || f.path == "/" {
+		return linux.EPERM
+	}
+
+	parent := &file{a: f.a, s: f.s, path: path.Dir(f.path), header: vfsgo.FileHeader{Type: vfsgo.Directory}}
+	return parent.RenameAt(path.Base(f.path), newDir, newName)
+}
+
+func (f *file) Renamed(newDir p9.File, newName string) {
+	if dir, ok := newDir.(*file); ok {
+		f.path = path.Join(dir.path, newName)
+		f.header.Name = newName
+	}
+}
+
+// mutate: run fn with current folder of session set to this directory
+func (f *file) mutate(fn func() error) error {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+
+	if err := f.s.cs.ChangeFolder(f.path); err != nil {
+		return errno(err)
+	}
+
+	return errno(fn())
+}
+
+// errno: linux errno of vfsgo error kind, io/fs kinds are mapped by p9
+func errno(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, vfsgo.ErrNotDir):
+		return linux.ENOTDIR
+	case errors.Is(err, vfsgo.ErrIsDir):
+		return linux.EISDIR
+	}
+
+	return linux.ExtractErrno(err)
+}
@@ -0,0 +1,94 @@
+package ninep
+
+import (
+	"net"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/hugelgupf/p9/p9"
+	"github.com/lemotw/vfsgo"
+	"golang.org/x/xerrors"
+)
+
+// Serve: export every user under root over 9P2000.L, l can be tcp or unix
+// socket listener
+func Serve(l net.Listener, root string) error {
+	if err := p9.NewServer(NewAttacher(root)).Serve(l); err != nil {
+		return xerrors.Errorf("error in p9.Serve: %w", err)
+	}
+
+	return nil
+}
+
+// NewAttacher: the attached root is a directory of users, walk into a user
+// enter the root block of that user
+func NewAttacher(root string) p9.Attacher {
+	return &attacher{
+		root:     root,
+		sessions: make(map[string]*session),
+	}
+}
+
+type attacher struct {
+	root string
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// session: command service of a user, all fids of the user share one
+// command service so current folder change is serialized by mu
+type session struct {
+	mu   sync.Mutex
+	name string
+	cs   vfsgo.ICommandService
+}
+
+func (a *attacher) Attach() (p9.File, error) {
+	return &file{a: a}, nil
+}
+
+func (a *attacher) session(name string) (*session, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if s, ok := a.sessions[name]; ok {
+		return s, nil
+	}
+
+	cs := vfsgo.NewCommandService(a.root)
+	if err := cs.Use(name); err != nil {
+		return nil, xerrors.Errorf("error in cs.Use: %w", err)
+	}
+
+	s := &session{name: name, cs: cs}
+	a.sessions[name] = s
+
+	return s, nil
+}
+
+// users: name of users under root
+func (a *attacher) users() ([]string, error) {
+	entries, err := os.ReadDir(a.root)
+	if err != nil {
+		return nil, xerrors.Errorf("error in os.ReadDir: %w", err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		user := vfsgo.User{RootPath: a.root, Name: entry.Name()}
+		if _, err := os.Stat(user.GetUserINodePath()); err != nil {
+			continue
+		}
+
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
@@ -0,0 +1,198 @@
+package ninep
+
+import (
+	"net"
+	"testing"
+
+	"github.com/hugelgupf/p9/p9"
+	"github.com/lemotw/vfsgo"
+)
+
+func attach(t *testing.T, root string) p9.File {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go Serve(l, root)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	client, err := p9.NewClient(conn)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { client.Close() })
+
+	rootFile, err := client.Attach("")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return rootFile
+}
+
+func TestNineP(t *testing.T) {
+	root := t.TempDir()
+	if err := vfsgo.NewCommandService(root).Register("alice"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	rootFile := attach(t, root)
+
+	_, userRoot, err := rootFile.Walk([]string{"alice"})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	dirQID, err := userRoot.Mkdir("docs", 0755, p9.NoUID, p9.NoGID)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if dirQID.Type != p9.TypeDir {
+		t.Error("mkdir qid is not directory")
+		return
+	}
+
+	qids, docs, err := userRoot.Walk([]string{"docs"})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if qids[0].Path != dirQID.Path {
+		t.Error("walk qid not equal mkdir qid")
+		return
+	}
+
+	// create turn the fid into the new file, so create from a clone
+	_, note, err := docs.Walk(nil)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	note, _, _, err = note.Create("note", p9.WriteOnly, 0644, p9.NoUID, p9.NoGID)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if _, err := note.WriteAt([]byte("hello 9p"), 0); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if err := note.Close(); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	_, note, err = rootFile.Walk([]string{"alice", "docs", "note"})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if _, _, err := note.Open(p9.ReadOnly); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	buf := make([]byte, 64)
+	n, err := note.ReadAt(buf, 0)
+	if n == 0 && err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if string(buf[:n]) != "hello 9p" {
+		t.Errorf("content %q not match", buf[:n])
+		return
+	}
+	note.Close()
+
+	// opened fid can only read, list from a clone
+	_, list, err := docs.Walk(nil)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if _, _, err := list.Open(p9.ReadOnly); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	dirents, err := list.Readdir(0, 4096)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if len(dirents) != 1 || dirents[0].Name != "note" || dirents[0].Type != p9.TypeRegular {
+		t.Error("readdir not match")
+		return
+	}
+
+	if err := docs.RenameAt("note", docs, "memo"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if _, _, err := rootFile.Walk([]string{"alice", "docs", "memo"}); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := docs.UnlinkAt("memo", 0); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if err := userRoot.UnlinkAt("docs", atRemoveDir); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if _, _, err := rootFile.Walk([]string{"alice", "docs"}); err == nil {
+		t.Error("removed folder still exist")
+		return
+	}
+}
+
+func TestNinePUsers(t *testing.T) {
+	root := t.TempDir()
+	cs := vfsgo.NewCommandService(root)
+	for _, name := range []string{"bob", "alice"} {
+		if err := cs.Register(name); err != nil {
+			t.Error(err.Error())
+			return
+		}
+	}
+
+	rootFile := attach(t, root)
+	if _, _, err := rootFile.Open(p9.ReadOnly); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	dirents, err := rootFile.Readdir(0, 4096)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if len(dirents) != 2 || dirents[0].Name != "alice" || dirents[1].Name != "bob" {
+		t.Error("users not listed")
+		return
+	}
+
+	if _, _, err := rootFile.Walk([]string{"nobody"}); err == nil {
+		t.Error("walk to unknown user should fail")
+		return
+	}
+}
+
+func TestQIDShortName(t *testing.T) {
+	f := &file{s: &session{name: "alice"}, path: "/alice/a", header: vfsgo.FileHeader{Type: vfsgo.File, HashFileName: "ab"}}
+
+	if qid := f.qid(); qid.Type != p9.TypeRegular || qid.Path&(1<<63) != 0 {
+		t.Errorf("unexpected qid %+v", qid)
+		return
+	}
+}
@@ -0,0 +1,578 @@
+package remote
+
+import (
+	"io"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sync"
+	"time"
+
+	"github.com/lemotw/vfsgo"
+	"golang.org/x/xerrors"
+)
+
+const (
+	DefaultRetries = 3
+	retryBackoff   = 100 * time.Millisecond
+)
+
+type requester interface {
+	request() *Request
+}
+
+// Client: ICommandService of a remote server, a broken connection is dialed
+// again and the call retried, server answer retried call with saved result
+type Client struct {
+	network string
+	addr    string
+	session string
+	named   bool
+
+	// Retries: times to retry a call after connection error
+	Retries int
+
+	mu   sync.Mutex
+	seq  uint64
+	conn *rpc.Client
+}
+
+var _ vfsgo.ICommandService = &Client{}
+
+// Dial: connect with a new session which is ended by Close
+func Dial(network, addr string) (*Client, error) {
+	c, err := DialSession(network, addr, "")
+	if err != nil {
+		return nil, err
+	}
+	c.named = false
+
+	return c, nil
+}
+
+// DialSession: connect to a named session, the session and its current user
+// and folder are kept on server after Close for next client until it is idle
+// for the IdleTimeout of server
+func DialSession(network, addr, session string) (*Client, error) {
+	c := &Client{
+		network: network,
+		addr:    addr,
+		named:   true,
+		Retries: DefaultRetries,
+		// seq must not repeat the one of previous client of same session
+		seq: uint64(time.Now().UnixNano()),
+	}
+
+	if err := c.dial(); err != nil {
+		return nil, err
+	}
+
+	// id of session is given by server
+	if err := c.call("OpenSession", &NameArgs{Name: session}, &c.session); err != nil {
+		c.Close()
+		return nil, xerrors.Errorf("error in OpenSession: %w", err)
+	}
+
+	return c, nil
+}
+
+func (c *Client) dial() error {
+	conn, err := net.Dial(c.network, c.addr)
+	if err != nil {
+		return xerrors.Errorf("error in net.Dial: %w", err)
+	}
+
+	c.conn = jsonrpc.NewClient(conn)
+
+	return nil
+}
+
+// Close: close connection, session not named is ended on server
+func (c *Client) Close() error {
+	if !c.named {
+		c.call("EndSession", &Request{}, &Empty{})
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+
+	err := c.conn.Close()
+	c.conn = nil
+
+	return err
+}
+
+func (c *Client) call(method string, args requester, reply interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	*args.request() = Request{Session: c.session, Seq: c.seq}
+
+	var err error
+	for i := 0; i <= c.Retries; i++ {
+		if i > 0 {
+			time.Sleep(retryBackoff * time.Duration(i))
+		}
+
+		if c.conn == nil {
+			if err = c.dial(); err != nil {
+				continue
+			}
+		}
+
+		err = c.conn.Call(ServiceName+"."+method, args, reply)
+		if err == nil {
+			return nil
+		}
+
+		// error returned by command service, same message and kind as local one
+		if serverErr, ok := err.(rpc.ServerError); ok {
+			return decodeError(string(serverErr))
+		}
+
+		c.conn.Close()
+		c.conn = nil
+	}
+
+	return xerrors.Errorf("error in call %s: %w", method, err)
+}
+
+func (c *Client) state() StateReply {
+	var reply StateReply
+	if err := c.call("State", &Request{}, &reply); err != nil {
+		return StateReply{}
+	}
+
+	return reply
+}
+
+func (c *Client) GetCurrentUser() *vfsgo.User {
+	return c.state().User
+}
+
+func (c *Client) GetCurrentBlock() *vfsgo.BlockINode {
+	return c.state().Block
+}
+
+func (c *Client) Register(name string) error {
+	return c.call("Register", &NameArgs{Name: name}, &Empty{})
+}
+
+func (c *Client) Use(name string) error {
+	return c.call("Use", &NameArgs{Name: name}, &Empty{})
+}
+
+func (c *Client) ChangeFolder(path string) error {
+	return c.call("ChangeFolder", &NameArgs{Name: path}, &Empty{})
+}
+
+func (c *Client) CreateFolder(dirName string) error {
+	return c.call("CreateFolder", &NameArgs{Name: dirName}, &Empty{})
+}
+
+func (c *Client) DeleteFolder(oldName string) error {
+	return c.call("DeleteFolder", &NameArgs{Name: oldName}, &Empty{})
+}
+
+func (c *Client) RenameFolder(oldName string, newName string) error {
+	return c.call("RenameFolder", &RenameArgs{OldName: oldName, NewName: newName}, &Empty{})
+}
+
+func (c *Client) CreateFile(fileName, desc string) error {
+	return c.call("CreateFile", &CreateFileArgs{Name: fileName, Desc: desc}, &Empty{})
+}
+
+func (c *Client) DeleteFile(fileName string) error {
+	return c.call("DeleteFile", &NameArgs{Name: fileName}, &Empty{})
+}
+
+func (c *Client) RenameFile(oldName, newName string, newDesc string) error {
+	return c.call("RenameFile", &RenameArgs{OldName: oldName, NewName: newName, NewDesc: newDesc}, &Empty{})
+}
+
+func (c *Client) Stat(filePath string) (vfsgo.FileHeader, error) {
+	var header vfsgo.FileHeader
+	if err := c.call("Stat", &NameArgs{Name: filePath}, &header); err != nil {
+		return vfsgo.FileHeader{}, err
+	}
+
+	return header, nil
+}
+
+func (c *Client) List(dirName string, sortField *vfsgo.SortType, sortOrder *string) ([]string, error) {
+	var files []string
+	if err := c.call("List", &ListArgs{Dir: dirName, SortField: sortField, SortOrder: sortOrder}, &files); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func (c *Client) ListEntries(dirName string, fileType *vfsgo.FileType, sortField *vfsgo.SortType, sortOrder *string) ([]vfsgo.FileHeader, error) {
+	var files []vfsgo.FileHeader
+	args := &ListEntriesArgs{Dir: dirName, FileType: fileType, SortField: sortField, SortOrder: sortOrder}
+	if err := c.call("ListEntries", args, &files); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func (c *Client) ListSorted(dirName string, fileType *vfsgo.FileType, keys []vfsgo.SortKey, dirsFirst bool) ([]vfsgo.FileHeader, error) {
+	var files []vfsgo.FileHeader
+	args := &ListSortedArgs{Dir: dirName, FileType: fileType, Keys: keys, DirsFirst: dirsFirst}
+	if err := c.call("ListSorted", args, &files); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func (c *Client) ListPage(dirName string, query vfsgo.ListQuery) (vfsgo.Page, error) {
+	var page vfsgo.Page
+	if err := c.call("ListPage", &ListPageArgs{Dir: dirName, Query: query}, &page); err != nil {
+		return vfsgo.Page{}, err
+	}
+
+	return page, nil
+}
+
+func (c *Client) Find(root string, query vfsgo.FindQuery) ([]vfsgo.Match, error) {
+	var matches []vfsgo.Match
+	if err := c.call("Find", &FindArgs{Root: root, Query: query}, &matches); err != nil {
+		return nil, err
+	}
+
+	return matches, nil
+}
+
+func (c *Client) Remove(filePath string) error {
+	return c.call("Remove", &NameArgs{Name: filePath}, &Empty{})
+}
+
+func (c *Client) Copy(srcPath, dstDir string) error {
+	return c.call("Copy", &MoveArgs{Src: srcPath, Dst: dstDir}, &Empty{})
+}
+
+func (c *Client) Glob(pattern string) ([]vfsgo.Match, error) {
+	var matches []vfsgo.Match
+	if err := c.call("Glob", &NameArgs{Name: pattern}, &matches); err != nil {
+		return nil, err
+	}
+
+	return matches, nil
+}
+
+func (c *Client) Move(srcPath, dstDir string) error {
+	return c.call("Move", &MoveArgs{Src: srcPath, Dst: dstDir}, &Empty{})
+}
+
+func (c *Client) Tree(root string, depth int) (vfsgo.TreeNode, error) {
+	var tree vfsgo.TreeNode
+	if err := c.call("Tree", &TreeArgs{Root: root, Depth: depth}, &tree); err != nil {
+		return vfsgo.TreeNode{}, err
+	}
+
+	return tree, nil
+}
+
+func (c *Client) Usage(dirPath string, depth int) (vfsgo.DiskUsage, error) {
+	var du vfsgo.DiskUsage
+	if err := c.call("Usage", &UsageArgs{Dir: dirPath, Depth: depth}, &du); err != nil {
+		return vfsgo.DiskUsage{}, err
+	}
+
+	return du, nil
+}
+
+func (c *Client) SetUsageCache(enabled bool) error {
+	return c.call("SetUsageCache", &UsageCacheArgs{Enabled: enabled}, &Empty{})
+}
+
+func (c *Client) SetCompression(dirPath, codec string) error {
+	return c.call("SetCompression", &CompressionArgs{Dir: dirPath, Codec: codec}, &Empty{})
+}
+
+func (c *Client) Encrypt(passphrase, mode string) error {
+	return c.call("Encrypt", &KeyArgs{Passphrase: passphrase, Mode: mode}, &Empty{})
+}
+
+func (c *Client) Unlock(name, passphrase string) error {
+	return c.call("Unlock", &KeyArgs{Name: name, Passphrase: passphrase}, &Empty{})
+}
+
+func (c *Client) Lock(name string) error {
+	return c.call("Lock", &KeyArgs{Name: name}, &Empty{})
+}
+
+func (c *Client) RotateKey(passphrase, newPassphrase string) error {
+	return c.call("RotateKey", &KeyArgs{Passphrase: passphrase, NewPassphrase: newPassphrase}, &Empty{})
+}
+
+func (c *Client) Verify() ([]vfsgo.Damage, error) {
+	var damages []vfsgo.Damage
+	if err := c.call("Verify", &Request{}, &damages); err != nil {
+		return nil, err
+	}
+
+	return damages, nil
+}
+
+func (c *Client) RootHash() (string, error) {
+	var hash string
+	if err := c.call("RootHash", &Request{}, &hash); err != nil {
+		return "", err
+	}
+
+	return hash, nil
+}
+
+func (c *Client) Search(query string, limit int) ([]vfsgo.SearchResult, error) {
+	var results []vfsgo.SearchResult
+	if err := c.call("Search", &SearchArgs{Query: query, Limit: limit}, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (c *Client) BeginBatch() error {
+	return c.call("BeginBatch", &Request{}, &Empty{})
+}
+
+func (c *Client) EndBatch() error {
+	return c.call("EndBatch", &Request{}, &Empty{})
+}
+
+func (c *Client) Tag(filePath string, tags ...string) error {
+	return c.call("Tag", &TagArgs{Path: filePath, Tags: tags}, &Empty{})
+}
+
+func (c *Client) Untag(filePath string, tags ...string) error {
+	return c.call("Untag", &TagArgs{Path: filePath, Tags: tags}, &Empty{})
+}
+
+func (c *Client) SetAttr(filePath, key, value string) error {
+	return c.call("SetAttr", &AttrArgs{Path: filePath, Key: key, Value: value}, &Empty{})
+}
+
+func (c *Client) GetAttr(filePath, key string) (string, error) {
+	var value string
+	if err := c.call("GetAttr", &AttrArgs{Path: filePath, Key: key}, &value); err != nil {
+		return "", err
+	}
+
+	return value, nil
+}
+
+func (c *Client) RenameBatch(dirName string, rule vfsgo.RenameRule, dryRun bool) ([]vfsgo.Rename, error) {
+	var renames []vfsgo.Rename
+	args := &RenameBatchArgs{Dir: dirName, Rule: rule, DryRun: dryRun}
+	if err := c.call("RenameBatch", args, &renames); err != nil {
+		return nil, err
+	}
+
+	return renames, nil
+}
+
+// Import: hostPath is on the server, Progress is called once with the
+// final report
+func (c *Client) Import(hostPath, vfsPath string, opts vfsgo.ImportOptions) (vfsgo.ImportReport, error) {
+	var report vfsgo.ImportReport
+	args := &ImportArgs{Host: hostPath, Dir: vfsPath, Exclude: opts.Exclude}
+	if err := c.call("Import", args, &report); err != nil {
+		return report, err
+	}
+
+	if opts.Progress != nil {
+		opts.Progress(report)
+	}
+
+	return report, nil
+}
+
+// Export: hostPath is on the server, Progress is called once with the
+// final report
+func (c *Client) Export(vfsPath, hostPath string, opts vfsgo.ExportOptions) (vfsgo.ExportReport, error) {
+	var report vfsgo.ExportReport
+	args := &ExportArgs{Dir: vfsPath, Host: hostPath, Policy: opts.Policy}
+	if err := c.call("Export", args, &report); err != nil {
+		return report, err
+	}
+
+	if opts.Progress != nil {
+		opts.Progress(report)
+	}
+
+	return report, nil
+}
+
+// ExportArchive: archive is written on the server, then fetched in chunks
+// into w
+func (c *Client) ExportArchive(vfsPath, format string, w io.Writer) error {
+	var handle HandleReply
+	if err := c.call("OpenExportArchive", &ArchiveArgs{Dir: vfsPath, Format: format}, &handle); err != nil {
+		return err
+	}
+
+	r := &reader{c: c, handle: handle.Handle}
+	defer r.Close()
+
+	if _, err := io.Copy(w, r); err != nil {
+		return xerrors.Errorf("error in io.Copy: %w", err)
+	}
+
+	return nil
+}
+
+// ImportArchive: stream r to server in chunks, imported once every chunk
+// arrived. Progress is called once with the final report
+func (c *Client) ImportArchive(r io.Reader, vfsPath, format string, opts vfsgo.ImportOptions) (vfsgo.ImportReport, error) {
+	var handle HandleReply
+	args := &ArchiveArgs{Dir: vfsPath, Format: format, Exclude: opts.Exclude}
+	if err := c.call("OpenImportArchive", args, &handle); err != nil {
+		return vfsgo.ImportReport{}, err
+	}
+
+	var report vfsgo.ImportReport
+	if err := c.upload(handle.Handle, r, "CommitArchive", &report); err != nil {
+		return report, err
+	}
+
+	if opts.Progress != nil {
+		opts.Progress(report)
+	}
+
+	return report, nil
+}
+
+// Backup: backup is written on the server, then fetched in chunks into w
+func (c *Client) Backup(w io.Writer, format string) error {
+	var handle HandleReply
+	if err := c.call("OpenBackup", &BackupArgs{Format: format}, &handle); err != nil {
+		return err
+	}
+
+	r := &reader{c: c, handle: handle.Handle}
+	defer r.Close()
+
+	if _, err := io.Copy(w, r); err != nil {
+		return xerrors.Errorf("error in io.Copy: %w", err)
+	}
+
+	return nil
+}
+
+// Restore: stream r to server in chunks, restored once every chunk arrived
+func (c *Client) Restore(r io.Reader, format string, users []string) (vfsgo.RestoreReport, error) {
+	var handle HandleReply
+	if err := c.call("OpenRestore", &BackupArgs{Format: format, Users: users}, &handle); err != nil {
+		return vfsgo.RestoreReport{}, err
+	}
+
+	var report vfsgo.RestoreReport
+	err := c.upload(handle.Handle, r, "CommitRestore", &report)
+
+	return report, err
+}
+
+// WriteFile: stream r to server in chunks, content is replaced only after
+// every chunk arrived
+func (c *Client) WriteFile(fileName string, r io.Reader) error {
+	var handle HandleReply
+	if err := c.call("OpenWrite", &NameArgs{Name: fileName}, &handle); err != nil {
+		return err
+	}
+
+	return c.upload(handle.Handle, r, "Commit", &Empty{})
+}
+
+// WriteFileAt: stream r to server in chunks, written at offset once every
+// chunk arrived
+func (c *Client) WriteFileAt(fileName string, offset int64, r io.Reader) error {
+	var handle HandleReply
+	if err := c.call("OpenWriteAt", &WriteAtArgs{Name: fileName, Offset: offset}, &handle); err != nil {
+		return err
+	}
+
+	return c.upload(handle.Handle, r, "Commit", &Empty{})
+}
+
+// upload: send r by Write, then call commit of handle with reply
+func (c *Client) upload(handle string, r io.Reader, commit string, reply interface{}) error {
+	buf := make([]byte, ChunkSize)
+	offset := int64(0)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			args := &WriteArgs{Handle: handle, Offset: offset, Data: buf[:n]}
+			if err := c.call("Write", args, &Empty{}); err != nil {
+				c.call("CloseHandle", &HandleArgs{Handle: handle}, &Empty{})
+				return err
+			}
+			offset += int64(n)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+
+		if err != nil {
+			c.call("CloseHandle", &HandleArgs{Handle: handle}, &Empty{})
+			return xerrors.Errorf("error in io.ReadFull: %w", err)
+		}
+	}
+
+	return c.call(commit, &HandleArgs{Handle: handle}, reply)
+}
+
+// ReadFile: content is fetched in chunks while reading
+func (c *Client) ReadFile(fileName string) (io.ReadCloser, error) {
+	var handle HandleReply
+	if err := c.call("OpenRead", &NameArgs{Name: fileName}, &handle); err != nil {
+		return nil, err
+	}
+
+	return &reader{c: c, handle: handle.Handle}, nil
+}
+
+// reader: io.ReadCloser and io.ReaderAt of remote content
+type reader struct {
+	c      *Client
+	handle string
+	offset int64
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.offset)
+	r.offset += int64(n)
+
+	return n, err
+}
+
+func (r *reader) ReadAt(p []byte, offset int64) (int, error) {
+	total := 0
+	for total < len(p) {
+		var reply ReadReply
+		args := &ReadArgs{Handle: r.handle, Offset: offset + int64(total), Size: len(p) - total}
+		if err := r.c.call("Read", args, &reply); err != nil {
+			return total, err
+		}
+
+		total += copy(p[total:], reply.Data)
+		if reply.EOF {
+			return total, io.EOF
+		}
+	}
+
+	return total, nil
+}
+
+func (r *reader) Close() error {
+	return r.c.call("CloseHandle", &HandleArgs{Handle: r.handle}, &Empty{})
+}
@@ -0,0 +1,435 @@
+package remote
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lemotw/vfsgo"
+)
+
+// trackListener: keep accepted connections so test can break them
+type trackListener struct {
+	net.Listener
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (l *trackListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.mu.Lock()
+		l.conns = append(l.conns, conn)
+		l.mu.Unlock()
+	}
+
+	return conn, err
+}
+
+func (l *trackListener) breakConns() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, conn := range l.conns {
+		conn.Close()
+	}
+	l.conns = nil
+}
+
+// must: stop test at error of a fixture step
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func startServer(t *testing.T, root string) (*trackListener, *Client) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	tl := &trackListener{Listener: l}
+	t.Cleanup(func() { l.Close() })
+
+	go NewServer(root).Serve(tl)
+
+	client, err := Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return tl, client
+}
+
+func TestClient(t *testing.T) {
+	_, client := startServer(t, t.TempDir())
+
+	if err := client.Register("alice"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := client.Use("alice"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if user := client.GetCurrentUser(); user == nil || user.Name != "alice" {
+		t.Error("current user not alice")
+		return
+	}
+
+	if err := client.CreateFolder("docs"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := client.ChangeFolder("docs"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := client.CreateFile("note", "desc"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// content larger than one chunk
+	content := strings.Repeat("0123456789", ChunkSize/4)
+	if err := client.WriteFile("note", strings.NewReader(content)); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	header, err := client.Stat("/docs/note")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if header.Size != int64(len(content)) || header.Description != "desc" {
+		t.Error("stat not match")
+		return
+	}
+
+	file, err := client.ReadFile("note")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	b, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if string(b) != content {
+		t.Error("read content not equal")
+		return
+	}
+
+	// resume upload at size already stored
+	if err := client.WriteFileAt("note", header.Size, strings.NewReader("tail")); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	header, err = client.Stat("/docs/note")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if header.Size != int64(len(content)+4) {
+		t.Error("size not match after write at")
+		return
+	}
+
+	files, err := client.List("/", nil, nil)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if len(files) != 1 || files[0] != "docs/" {
+		t.Error("list not match")
+		return
+	}
+}
+
+func TestClientError(t *testing.T) {
+	root := t.TempDir()
+	_, client := startServer(t, root)
+
+	if err := vfsgo.NewCommandService(root).Register("alice"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// error message is the same as local command service
+	localErr := vfsgo.NewCommandService(root).Register("alice")
+	remoteErr := client.Register("alice")
+	if localErr == nil || remoteErr == nil || localErr.Error() != remoteErr.Error() {
+		t.Error("remote error not equal local error")
+		return
+	}
+
+	if !errors.Is(localErr, vfsgo.ErrExist) || !errors.Is(remoteErr, vfsgo.ErrExist) {
+		t.Error("remote error kind not equal local error")
+		return
+	}
+
+	if err := client.Use("nobody"); !errors.Is(err, vfsgo.ErrNotExist) || !errors.Is(err, fs.ErrNotExist) {
+		t.Error("use unknown user should be ErrNotExist")
+		return
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	l, client := startServer(t, t.TempDir())
+
+	if err := client.Register("alice"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := client.Use("alice"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	l.breakConns()
+
+	// session keep current user after reconnect
+	if err := client.CreateFolder("docs"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if _, err := client.Stat("/docs"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+}
+
+func TestServerRetriedRequest(t *testing.T) {
+	s := NewServer(t.TempDir())
+	sv := &Service{s: s}
+
+	var id string
+	if err := sv.OpenSession(NameArgs{}, &id); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	args := NameArgs{Request: Request{Session: id, Seq: 1}, Name: "alice"}
+	if err := sv.Register(args, &Empty{}); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// same seq is not run again
+	if err := sv.Register(args, &Empty{}); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	args.Seq = 2
+	if err := sv.Register(args, &Empty{}); err == nil {
+		t.Error("register twice should fail")
+		return
+	}
+}
+
+func TestNamedSession(t *testing.T) {
+	dir := t.TempDir()
+	socket := dir + "/vfsgo.sock"
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer l.Close()
+
+	go NewServer(dir).Serve(l)
+
+	client, err := DialSession("unix", socket, "cli")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := client.Register("alice"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := client.Use("alice"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	client.Close()
+
+	// next client of the session continue with current user
+	client, err = DialSession("unix", socket, "cli")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer client.Close()
+
+	if err := client.CreateFolder("docs"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// other session see the cached user
+	other, err := Dial("unix", socket)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer other.Close()
+
+	if err := other.Use("alice"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if _, err := other.Stat("/docs"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+}
+
+func TestClientArchive(t *testing.T) {
+	_, client := startServer(t, t.TempDir())
+
+	must(t, client.Register("alice"))
+	must(t, client.Use("alice"))
+	must(t, client.CreateFolder("src"))
+	must(t, client.CreateFolder("dst"))
+	must(t, client.ChangeFolder("src"))
+	must(t, client.CreateFile("note", "desc"))
+	must(t, client.WriteFile("note", strings.NewReader("content")))
+	must(t, client.ChangeFolder("/"))
+
+	var buf strings.Builder
+	if err := client.ExportArchive("/src", vfsgo.ArchiveZip, &buf); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	report, err := client.ImportArchive(strings.NewReader(buf.String()), "/dst", vfsgo.ArchiveZip, vfsgo.ImportOptions{})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if report.Files != 1 || report.Bytes != 7 {
+		t.Errorf("unexpected report %+v", report)
+		return
+	}
+
+	header, err := client.Stat("/dst/note")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if header.Description != "desc" || header.Size != 7 {
+		t.Errorf("unexpected header %+v", header)
+		return
+	}
+}
+
+func TestClientBackup(t *testing.T) {
+	_, client := startServer(t, t.TempDir())
+
+	must(t, client.Register("alice"))
+	must(t, client.Use("alice"))
+	must(t, client.CreateFile("note", "desc"))
+	must(t, client.WriteFile("note", strings.NewReader("content")))
+
+	var buf strings.Builder
+	if err := client.Backup(&buf, vfsgo.ArchiveTarGz); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	_, other := startServer(t, t.TempDir())
+	report, err := other.Restore(strings.NewReader(buf.String()), vfsgo.ArchiveTarGz, []string{"alice"})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if len(report.Users) != 1 || report.Blobs != 1 {
+		t.Errorf("unexpected report %+v", report)
+		return
+	}
+
+	if err := other.Use("alice"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	header, err := other.Stat("note")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if header.Description != "desc" || header.Size != 7 {
+		t.Errorf("unexpected header %+v", header)
+		return
+	}
+}
+
+func TestSessionExpire(t *testing.T) {
+	s := NewServer(t.TempDir())
+	s.IdleTimeout = 50 * time.Millisecond
+	sv := &Service{s: s}
+
+	// session of id not given by server is not run
+	if err := sv.Register(NameArgs{Request: Request{Session: "guess", Seq: 1}, Name: "alice"}, &Empty{}); !errors.Is(decodeError(err.Error()), vfsgo.ErrNotExist) {
+		t.Errorf("request run in unknown session: %v", err)
+		return
+	}
+
+	var id string
+	if err := sv.OpenSession(NameArgs{}, &id); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	var handle HandleReply
+	if err := sv.OpenWrite(NameArgs{Request: Request{Session: id, Seq: 1}, Name: "a.txt"}, &handle); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	spool := s.sessions[id].uploads[handle.Handle].file.Name()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.expire(stop)
+
+	time.Sleep(200 * time.Millisecond)
+
+	if _, err := os.Stat(spool); !os.IsNotExist(err) {
+		t.Errorf("spooled upload left after session expired: %v", err)
+		return
+	}
+
+	if err := sv.Register(NameArgs{Request: Request{Session: id, Seq: 2}, Name: "alice"}, &Empty{}); err == nil {
+		t.Error("request run in expired session")
+		return
+	}
+}
@@ -0,0 +1,56 @@
+package remote
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/lemotw/vfsgo"
+	"golang.org/x/xerrors"
+)
+
+// errorCodes: status code sent for each kind of vfsgo error, other errors
+// are sent as EIO
+var errorCodes = []struct {
+	code string
+	kind error
+}{
+	{"EEXIST", vfsgo.ErrExist},
+	{"ENOENT", vfsgo.ErrNotExist},
+	{"ENOTDIR", vfsgo.ErrNotDir},
+	{"EISDIR", vfsgo.ErrIsDir},
+	{"EINVAL", vfsgo.ErrInvalidName},
+	{"ENOUSER", vfsgo.ErrNoUser},
+	{"EPERM", vfsgo.ErrPermission},
+	{"ECORRUPT", vfsgo.ErrCorrupt},
+	{"ELOCKED", vfsgo.ErrLocked},
+	{"EWRONGKEY", vfsgo.ErrWrongKey},
+}
+
+const codeIO = "EIO"
+
+// encodeError: "CODE: message"
+func encodeError(err error) string {
+	for _, c := range errorCodes {
+		if errors.Is(err, c.kind) {
+			return c.code + ": " + err.Error()
+		}
+	}
+
+	return codeIO + ": " + err.Error()
+}
+
+// decodeError: error with the message and kind of the server one
+func decodeError(s string) error {
+	code, msg, ok := strings.Cut(s, ": ")
+	if !ok {
+		return xerrors.New(s)
+	}
+
+	for _, c := range errorCodes {
+		if c.code == code {
+			return vfsgo.NewError(c.kind, msg)
+		}
+	}
+
+	return xerrors.New(msg)
+}
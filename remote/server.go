@@ -0,0 +1,275 @@
+package remote
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lemotw/vfsgo"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// ServiceName: rpc methods are called as ServiceName.Method
+	ServiceName = "VFS"
+
+	// ChunkSize: max bytes of content moved by one call
+	ChunkSize = 64 * 1024
+
+	// DefaultIdleTimeout: session without request for this long is ended
+	DefaultIdleTimeout = 30 * time.Minute
+)
+
+// Server: serve command service to remote clients, every client session has
+// its own command service which keep current user and folder across calls.
+// Sessions share cached users and requests are run one at a time. Session
+// ids are given by server, see OpenSession
+type Server struct {
+	root string
+
+	// IdleTimeout: session idle for this long is ended with its handles, no
+	// session is ended if it is not positive
+	IdleTimeout time.Duration
+
+	// run: held while a request is run, users is only touched under it
+	run   sync.Mutex
+	users map[string]*vfsgo.User
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	named    map[string]string
+}
+
+type session struct {
+	cs   vfsgo.ICommandService
+	name string
+
+	// last: time of last request, under Server.mu
+	last time.Time
+
+	// result of the last request, a retried request get it again
+	seq   uint64
+	reply []byte
+	err   string
+
+	reads   map[string]io.ReadCloser
+	uploads map[string]*upload
+}
+
+type upload struct {
+	name string
+	file *os.File
+
+	// at: content is written at offset instead of replaced
+	at     bool
+	offset int64
+
+	// format: content is an archive imported into folder name
+	format  string
+	exclude []string
+
+	// users: content is a backup of root, users of it are restored
+	users []string
+}
+
+// spooled: temp file removed on close
+type spooled struct {
+	*os.File
+}
+
+func (f spooled) Close() error {
+	f.File.Close()
+	return os.Remove(f.Name())
+}
+
+func NewServer(root string) *Server {
+	return &Server{
+		root:        root,
+		IdleTimeout: DefaultIdleTimeout,
+		users:       make(map[string]*vfsgo.User),
+		sessions:    make(map[string]*session),
+		named:       make(map[string]string),
+	}
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	server := rpc.NewServer()
+	if err := server.RegisterName(ServiceName, &Service{s: s}); err != nil {
+		return xerrors.Errorf("error in server.RegisterName: %w", err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.expire(stop)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return xerrors.Errorf("error in l.Accept: %w", err)
+		}
+
+		go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+// openSession: id of session named name, a new session with a random id if
+// it is not named or not open
+func (s *Server) openSession(name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id, ok := s.named[name]; ok && name != "" {
+		s.sessions[id].last = time.Now()
+		return id, nil
+	}
+
+	id, err := newHandle()
+	if err != nil {
+		return "", xerrors.Errorf("error in newHandle: %w", err)
+	}
+
+	s.sessions[id] = &session{
+		cs:      vfsgo.NewCommandServiceWithUsers(s.root, s.users),
+		name:    name,
+		last:    time.Now(),
+		reads:   make(map[string]io.ReadCloser),
+		uploads: make(map[string]*upload),
+	}
+	if name != "" {
+		s.named[name] = id
+	}
+
+	return id, nil
+}
+
+func (s *Server) session(id string) (*session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return nil, vfsgo.NewError(vfsgo.ErrNotExist, "session not exist or expired")
+	}
+	sess.last = time.Now()
+
+	return sess, nil
+}
+
+func (s *Server) endSession(id string) {
+	s.mu.Lock()
+	sess, ok := s.sessions[id]
+	if ok {
+		s.drop(id, sess)
+	}
+	s.mu.Unlock()
+
+	if ok {
+		s.close([]*session{sess})
+	}
+}
+
+// drop: forget session under mu, its handles are closed by close
+func (s *Server) drop(id string, sess *session) {
+	delete(s.sessions, id)
+	if sess.name != "" {
+		delete(s.named, sess.name)
+	}
+}
+
+// close: close handles of sessions ended, spooled files are removed and a
+// batch left open by client is ended so its index changes are saved
+func (s *Server) close(ended []*session) {
+	s.run.Lock()
+	defer s.run.Unlock()
+
+	for _, sess := range ended {
+		sess.cs.EndBatch()
+
+		for _, r := range sess.reads {
+			r.Close()
+		}
+
+		for _, u := range sess.uploads {
+			u.Close()
+		}
+	}
+}
+
+// expire: end sessions idle for IdleTimeout until stop
+func (s *Server) expire(stop <-chan struct{}) {
+	if s.IdleTimeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.IdleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			ended := []*session{}
+			for id, sess := range s.sessions {
+				if now.Sub(sess.last) >= s.IdleTimeout {
+					s.drop(id, sess)
+					ended = append(ended, sess)
+				}
+			}
+			s.mu.Unlock()
+
+			s.close(ended)
+		}
+	}
+}
+
+// do: run fn in session of req, a request with the same seq as the last one
+// is a retry and is answered from the saved result instead of run again
+func (s *Server) do(req Request, reply interface{}, fn func(sess *session) error) error {
+	sess, err := s.session(req.Session)
+	if err != nil {
+		return errors.New(encodeError(err))
+	}
+
+	s.run.Lock()
+	defer s.run.Unlock()
+
+	if req.Seq != 0 && req.Seq == sess.seq {
+		if sess.err != "" {
+			return errors.New(sess.err)
+		}
+		return json.Unmarshal(sess.reply, reply)
+	}
+
+	err = fn(sess)
+
+	sess.seq = req.Seq
+	sess.err = ""
+	sess.reply = nil
+	if err != nil {
+		sess.err = encodeError(err)
+		return errors.New(sess.err)
+	}
+
+	sess.reply, _ = json.Marshal(reply)
+
+	return nil
+}
+
+func newHandle() (string, error) {
+	data := make([]byte, 16)
+	if _, err := rand.Read(data); err != nil {
+		return "", xerrors.Errorf("error in rand.Read: %w", err)
+	}
+
+	return hex.EncodeToString(data), nil
+}
@@ -0,0 +1,757 @@
+package remote
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/lemotw/vfsgo"
+	"golang.org/x/xerrors"
+)
+
+// Request: every call carry the session and a sequence number increased by
+// client for each new call
+type Request struct {
+	Session string
+	Seq     uint64
+}
+
+func (r *Request) request() *Request {
+	return r
+}
+
+type Empty struct{}
+
+type NameArgs struct {
+	Request
+	Name string
+}
+
+type WriteAtArgs struct {
+	Request
+	Name   string
+	Offset int64
+}
+
+type CreateFileArgs struct {
+	Request
+	Name string
+	Desc string
+}
+
+type RenameArgs struct {
+	Request
+	OldName string
+	NewName string
+	NewDesc string
+}
+
+type ListArgs struct {
+	Request
+	Dir       string
+	SortField *vfsgo.SortType
+	SortOrder *string
+}
+
+type ListEntriesArgs struct {
+	Request
+	Dir       string
+	FileType  *vfsgo.FileType
+	SortField *vfsgo.SortType
+	SortOrder *string
+}
+
+type ListSortedArgs struct {
+	Request
+	Dir       string
+	FileType  *vfsgo.FileType
+	Keys      []vfsgo.SortKey
+	DirsFirst bool
+}
+
+type ListPageArgs struct {
+	Request
+	Dir   string
+	Query vfsgo.ListQuery
+}
+
+type FindArgs struct {
+	Request
+	Root  string
+	Query vfsgo.FindQuery
+}
+
+type MoveArgs struct {
+	Request
+	Src string
+	Dst string
+}
+
+type TreeArgs struct {
+	Request
+	Root  string
+	Depth int
+}
+
+type UsageArgs struct {
+	Request
+	Dir   string
+	Depth int
+}
+
+type UsageCacheArgs struct {
+	Request
+	Enabled bool
+}
+
+type CompressionArgs struct {
+	Request
+	Dir   string
+	Codec string
+}
+
+type KeyArgs struct {
+	Request
+	Name          string
+	Mode          string
+	Passphrase    string
+	NewPassphrase string
+}
+
+type SearchArgs struct {
+	Request
+	Query string
+	Limit int
+}
+
+type TagArgs struct {
+	Request
+	Path string
+	Tags []string
+}
+
+type AttrArgs struct {
+	Request
+	Path  string
+	Key   string
+	Value string
+}
+
+type RenameBatchArgs struct {
+	Request
+	Dir    string
+	Rule   vfsgo.RenameRule
+	DryRun bool
+}
+
+type ImportArgs struct {
+	Request
+	Host    string
+	Dir     string
+	Exclude []string
+}
+
+type ExportArgs struct {
+	Request
+	Dir    string
+	Host   string
+	Policy string
+}
+
+type ArchiveArgs struct {
+	Request
+	Dir     string
+	Format  string
+	Exclude []string
+}
+
+type BackupArgs struct {
+	Request
+	Format string
+	Users  []string
+}
+
+type StateReply struct {
+	User  *vfsgo.User
+	Block *vfsgo.BlockINode
+}
+
+type HandleReply struct {
+	Handle string
+}
+
+type HandleArgs struct {
+	Request
+	Handle string
+}
+
+type ReadArgs struct {
+	Request
+	Handle string
+	Offset int64
+	Size   int
+}
+
+type ReadReply struct {
+	Data []byte
+	EOF  bool
+}
+
+type WriteArgs struct {
+	Request
+	Handle string
+	Offset int64
+	Data   []byte
+}
+
+// Service: rpc receiver, methods map one to one to ICommandService except
+// content which is moved in chunks through handles
+type Service struct {
+	s *Server
+}
+
+func (sv *Service) State(args Request, reply *StateReply) error {
+	return sv.s.do(args, reply, func(sess *session) error {
+		reply.User = sess.cs.GetCurrentUser()
+		reply.Block = sess.cs.GetCurrentBlock()
+		return nil
+	})
+}
+
+func (sv *Service) Register(args NameArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.cs.Register(args.Name)
+	})
+}
+
+func (sv *Service) Use(args NameArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.cs.Use(args.Name)
+	})
+}
+
+func (sv *Service) ChangeFolder(args NameArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.cs.ChangeFolder(args.Name)
+	})
+}
+
+func (sv *Service) CreateFolder(args NameArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.cs.CreateFolder(args.Name)
+	})
+}
+
+func (sv *Service) DeleteFolder(args NameArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.cs.DeleteFolder(args.Name)
+	})
+}
+
+func (sv *Service) RenameFolder(args RenameArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.cs.RenameFolder(args.OldName, args.NewName)
+	})
+}
+
+func (sv *Service) CreateFile(args CreateFileArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.cs.CreateFile(args.Name, args.Desc)
+	})
+}
+
+func (sv *Service) DeleteFile(args NameArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.cs.DeleteFile(args.Name)
+	})
+}
+
+func (sv *Service) RenameFile(args RenameArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.cs.RenameFile(args.OldName, args.NewName, args.NewDesc)
+	})
+}
+
+func (sv *Service) Stat(args NameArgs, reply *vfsgo.FileHeader) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		header, err := sess.cs.Stat(args.Name)
+		*reply = header
+		return err
+	})
+}
+
+func (sv *Service) List(args ListArgs, reply *[]string) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		files, err := sess.cs.List(args.Dir, args.SortField, args.SortOrder)
+		*reply = files
+		return err
+	})
+}
+
+func (sv *Service) ListEntries(args ListEntriesArgs, reply *[]vfsgo.FileHeader) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		files, err := sess.cs.ListEntries(args.Dir, args.FileType, args.SortField, args.SortOrder)
+		*reply = files
+		return err
+	})
+}
+
+func (sv *Service) ListSorted(args ListSortedArgs, reply *[]vfsgo.FileHeader) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		files, err := sess.cs.ListSorted(args.Dir, args.FileType, args.Keys, args.DirsFirst)
+		*reply = files
+		return err
+	})
+}
+
+func (sv *Service) ListPage(args ListPageArgs, reply *vfsgo.Page) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		page, err := sess.cs.ListPage(args.Dir, args.Query)
+		*reply = page
+		return err
+	})
+}
+
+func (sv *Service) Find(args FindArgs, reply *[]vfsgo.Match) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		matches, err := sess.cs.Find(args.Root, args.Query)
+		*reply = matches
+		return err
+	})
+}
+
+func (sv *Service) Remove(args NameArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.cs.Remove(args.Name)
+	})
+}
+
+func (sv *Service) Copy(args MoveArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.cs.Copy(args.Src, args.Dst)
+	})
+}
+
+func (sv *Service) Glob(args NameArgs, reply *[]vfsgo.Match) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		matches, err := sess.cs.Glob(args.Name)
+		*reply = matches
+		return err
+	})
+}
+
+func (sv *Service) Move(args MoveArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.cs.Move(args.Src, args.Dst)
+	})
+}
+
+func (sv *Service) Tree(args TreeArgs, reply *vfsgo.TreeNode) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		tree, err := sess.cs.Tree(args.Root, args.Depth)
+		*reply = tree
+		return err
+	})
+}
+
+func (sv *Service) Usage(args UsageArgs, reply *vfsgo.DiskUsage) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		du, err := sess.cs.Usage(args.Dir, args.Depth)
+		*reply = du
+		return err
+	})
+}
+
+func (sv *Service) SetUsageCache(args UsageCacheArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.cs.SetUsageCache(args.Enabled)
+	})
+}
+
+func (sv *Service) SetCompression(args CompressionArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.cs.SetCompression(args.Dir, args.Codec)
+	})
+}
+
+func (sv *Service) Encrypt(args KeyArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.cs.Encrypt(args.Passphrase, args.Mode)
+	})
+}
+
+func (sv *Service) Unlock(args KeyArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.cs.Unlock(args.Name, args.Passphrase)
+	})
+}
+
+func (sv *Service) Lock(args KeyArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.cs.Lock(args.Name)
+	})
+}
+
+func (sv *Service) RotateKey(args KeyArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.cs.RotateKey(args.Passphrase, args.NewPassphrase)
+	})
+}
+
+func (sv *Service) Verify(args Request, reply *[]vfsgo.Damage) error {
+	return sv.s.do(args, reply, func(sess *session) error {
+		damages, err := sess.cs.Verify()
+		*reply = damages
+		return err
+	})
+}
+
+func (sv *Service) RootHash(args Request, reply *string) error {
+	return sv.s.do(args, reply, func(sess *session) error {
+		hash, err := sess.cs.RootHash()
+		*reply = hash
+		return err
+	})
+}
+
+// Import: host path is on the server side, progress is not reported
+func (sv *Service) Import(args ImportArgs, reply *vfsgo.ImportReport) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		report, err := sess.cs.Import(args.Host, args.Dir, vfsgo.ImportOptions{Exclude: args.Exclude})
+		*reply = report
+		return err
+	})
+}
+
+// Export: host path is on the server side, progress is not reported
+func (sv *Service) Export(args ExportArgs, reply *vfsgo.ExportReport) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		report, err := sess.cs.Export(args.Dir, args.Host, vfsgo.ExportOptions{Policy: args.Policy})
+		*reply = report
+		return err
+	})
+}
+
+func (sv *Service) Search(args SearchArgs, reply *[]vfsgo.SearchResult) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		results, err := sess.cs.Search(args.Query, args.Limit)
+		*reply = results
+		return err
+	})
+}
+
+func (sv *Service) BeginBatch(args Request, reply *Empty) error {
+	return sv.s.do(args, reply, func(sess *session) error {
+		return sess.cs.BeginBatch()
+	})
+}
+
+func (sv *Service) EndBatch(args Request, reply *Empty) error {
+	return sv.s.do(args, reply, func(sess *session) error {
+		return sess.cs.EndBatch()
+	})
+}
+
+func (sv *Service) Tag(args TagArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.cs.Tag(args.Path, args.Tags...)
+	})
+}
+
+func (sv *Service) Untag(args TagArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.cs.Untag(args.Path, args.Tags...)
+	})
+}
+
+func (sv *Service) SetAttr(args AttrArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.cs.SetAttr(args.Path, args.Key, args.Value)
+	})
+}
+
+func (sv *Service) GetAttr(args AttrArgs, reply *string) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		value, err := sess.cs.GetAttr(args.Path, args.Key)
+		*reply = value
+		return err
+	})
+}
+
+func (sv *Service) RenameBatch(args RenameBatchArgs, reply *[]vfsgo.Rename) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		renames, err := sess.cs.RenameBatch(args.Dir, args.Rule, args.DryRun)
+		*reply = renames
+		return err
+	})
+}
+
+// OpenRead: open file in current folder for chunked read
+func (sv *Service) OpenRead(args NameArgs, reply *HandleReply) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		file, err := sess.cs.ReadFile(args.Name)
+		if err != nil {
+			return err
+		}
+
+		handle, err := newHandle()
+		if err != nil {
+			file.Close()
+			return err
+		}
+
+		sess.reads[handle] = file
+		reply.Handle = handle
+
+		return nil
+	})
+}
+
+func (sv *Service) Read(args ReadArgs, reply *ReadReply) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		file, ok := sess.reads[args.Handle]
+		if !ok {
+			return xerrors.New("handle not exist")
+		}
+
+		ra, ok := file.(io.ReaderAt)
+		if !ok {
+			return xerrors.New("content not support random read")
+		}
+
+		size := args.Size
+		if size <= 0 || size > ChunkSize {
+			size = ChunkSize
+		}
+
+		buf := make([]byte, size)
+		n, err := ra.ReadAt(buf, args.Offset)
+		if err != nil && err != io.EOF {
+			return xerrors.Errorf("error in ReadAt: %w", err)
+		}
+
+		reply.Data = buf[:n]
+		reply.EOF = err == io.EOF
+
+		return nil
+	})
+}
+
+// OpenWrite: start replacing content of file in current folder, content is
+// sent by Write and applied by Commit
+func (sv *Service) OpenWrite(args NameArgs, reply *HandleReply) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.openUpload(&upload{name: args.Name}, reply)
+	})
+}
+
+// OpenWriteAt: like OpenWrite but content is written at offset by Commit,
+// the rest of file is kept
+func (sv *Service) OpenWriteAt(args WriteAtArgs, reply *HandleReply) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.openUpload(&upload{name: args.Name, at: true, offset: args.Offset}, reply)
+	})
+}
+
+func (sess *session) openUpload(u *upload, reply *HandleReply) error {
+	file, err := os.CreateTemp("", "vfsgo-remote-*")
+	if err != nil {
+		return xerrors.Errorf("error in os.CreateTemp: %w", err)
+	}
+
+	handle, err := newHandle()
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	u.file = file
+	sess.uploads[handle] = u
+	reply.Handle = handle
+
+	return nil
+}
+
+func (sv *Service) Write(args WriteArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		u, ok := sess.uploads[args.Handle]
+		if !ok {
+			return xerrors.New("handle not exist")
+		}
+
+		if _, err := u.file.WriteAt(args.Data, args.Offset); err != nil {
+			return xerrors.Errorf("error in WriteAt: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func (sv *Service) Commit(args HandleArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		u, err := sess.takeUpload(args.Handle)
+		if err != nil {
+			return err
+		}
+		defer u.Close()
+
+		if u.at {
+			return sess.cs.WriteFileAt(u.name, u.offset, u.file)
+		}
+
+		return sess.cs.WriteFile(u.name, u.file)
+	})
+}
+
+// takeUpload: upload of handle rewound for reading, it is removed on close
+func (sess *session) takeUpload(handle string) (*upload, error) {
+	u, ok := sess.uploads[handle]
+	if !ok {
+		return nil, xerrors.New("handle not exist")
+	}
+	delete(sess.uploads, handle)
+
+	if _, err := u.file.Seek(0, io.SeekStart); err != nil {
+		u.Close()
+		return nil, xerrors.Errorf("error in Seek: %w", err)
+	}
+
+	return u, nil
+}
+
+func (u *upload) Close() error {
+	u.file.Close()
+	return os.Remove(u.file.Name())
+}
+
+// OpenExportArchive: archive of folder is written to a temp file first, then
+// read by Read like content
+func (sv *Service) OpenExportArchive(args ArchiveArgs, reply *HandleReply) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		file, err := os.CreateTemp("", "vfsgo-archive-*")
+		if err != nil {
+			return xerrors.Errorf("error in os.CreateTemp: %w", err)
+		}
+		f := spooled{file}
+
+		if err := sess.cs.ExportArchive(args.Dir, args.Format, f); err != nil {
+			f.Close()
+			return err
+		}
+
+		handle, err := newHandle()
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		sess.reads[handle] = f
+		reply.Handle = handle
+
+		return nil
+	})
+}
+
+// OpenImportArchive: archive is sent by Write and imported by CommitArchive
+func (sv *Service) OpenImportArchive(args ArchiveArgs, reply *HandleReply) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.openUpload(&upload{name: args.Dir, format: args.Format, exclude: args.Exclude}, reply)
+	})
+}
+
+func (sv *Service) CommitArchive(args HandleArgs, reply *vfsgo.ImportReport) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		u, err := sess.takeUpload(args.Handle)
+		if err != nil {
+			return err
+		}
+		defer u.Close()
+
+		report, err := sess.cs.ImportArchive(u.file, u.name, u.format, vfsgo.ImportOptions{Exclude: u.exclude})
+		*reply = report
+		return err
+	})
+}
+
+// OpenBackup: backup of root is written to a temp file first, then read by
+// Read like content
+func (sv *Service) OpenBackup(args BackupArgs, reply *HandleReply) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		file, err := os.CreateTemp("", "vfsgo-backup-*")
+		if err != nil {
+			return xerrors.Errorf("error in os.CreateTemp: %w", err)
+		}
+		f := spooled{file}
+
+		if err := sess.cs.Backup(f, args.Format); err != nil {
+			f.Close()
+			return err
+		}
+
+		handle, err := newHandle()
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		sess.reads[handle] = f
+		reply.Handle = handle
+
+		return nil
+	})
+}
+
+// OpenRestore: backup is sent by Write and restored by CommitRestore
+func (sv *Service) OpenRestore(args BackupArgs, reply *HandleReply) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		return sess.openUpload(&upload{format: args.Format, users: args.Users}, reply)
+	})
+}
+
+func (sv *Service) CommitRestore(args HandleArgs, reply *vfsgo.RestoreReport) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		u, err := sess.takeUpload(args.Handle)
+		if err != nil {
+			return err
+		}
+		defer u.Close()
+
+		report, err := sess.cs.Restore(u.file, u.format, u.users)
+		*reply = report
+		return err
+	})
+}
+
+func (sv *Service) CloseHandle(args HandleArgs, reply *Empty) error {
+	return sv.s.do(args.Request, reply, func(sess *session) error {
+		if file, ok := sess.reads[args.Handle]; ok {
+			delete(sess.reads, args.Handle)
+			return file.Close()
+		}
+
+		if u, ok := sess.uploads[args.Handle]; ok {
+			delete(sess.uploads, args.Handle)
+			return u.Close()
+		}
+
+		return nil
+	})
+}
+
+// OpenSession: id of a new session, or of the open one named Name which is
+// shared by every client opening it
+func (sv *Service) OpenSession(args NameArgs, reply *string) error {
+	id, err := sv.s.openSession(args.Name)
+	if err != nil {
+		return errors.New(encodeError(err))
+	}
+	*reply = id
+
+	return nil
+}
+
+func (sv *Service) EndSession(args Request, reply *Empty) error {
+	sv.s.endSession(args.Session)
+	return nil
+}
@@ -0,0 +1,177 @@
+package vfsgo
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"golang.org/x/xerrors"
+)
+
+// RenameRule: entries of folder whose name match Regex have the first match
+// replaced by Replace, which may use $1 of regexp and placeholders {n}
+// counter, {n:3} counter padded to width 3, {date} created date and {mdate}
+// modified date
+type RenameRule struct {
+	Regex   string
+	Replace string
+	// Global: every match is replaced like flag g of sed
+	Global bool
+	// Start: first counter, entries are counted in natural name order
+	Start int
+	Type  *FileType
+}
+
+// Rename: one entry renamed by rule
+type Rename struct {
+	Old string
+	New string
+}
+
+var placeholderRegex = regexp.MustCompile(`\{(n|date|mdate)(?::(\d+))?\}`)
+
+const placeholderDateLayout = "20060102"
+
+// expandPlaceholders: replace placeholders of replace for entry
+func expandPlaceholders(replace string, header FileHeader, counter int) string {
+	return placeholderRegex.ReplaceAllStringFunc(replace, func(s string) string {
+		m := placeholderRegex.FindStringSubmatch(s)
+
+		switch m[1] {
+		case "date":
+			return header.CreatedTime.Format(placeholderDateLayout)
+		case "mdate":
+			return header.ModifiedTime.Format(placeholderDateLayout)
+		}
+
+		width, _ := strconv.Atoi(m[2])
+		return fmt.Sprintf("%0*d", width, counter)
+	})
+}
+
+// replaceMatch: name with first match of re replaced by replace, or every
+// match when global
+func replaceMatch(re *regexp.Regexp, name, replace string, global bool) string {
+	if global {
+		return re.ReplaceAllString(name, replace)
+	}
+
+	loc := re.FindStringSubmatchIndex(name)
+	if loc == nil {
+		return name
+	}
+
+	return name[:loc[0]] + string(re.ExpandString(nil, replace, name, loc)) + name[loc[1]:]
+}
+
+// PlanRename: renames of rule over fileMap, fail with ErrExist if two new
+// names collide or a new name is taken by an entry which is kept
+func PlanRename(fileMap map[string]FileHeader, rule RenameRule) ([]Rename, error) {
+	re, err := regexp.Compile(rule.Regex)
+	if err != nil {
+		return nil, errorf(ErrInvalidName, "invalid regex %s", rule.Regex)
+	}
+
+	names := make([]string, 0, len(fileMap))
+	for name := range fileMap {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return NaturalLess(names[i], names[j])
+	})
+
+	renames := []Rename{}
+	renamed := map[string]bool{}
+	counter := rule.Start
+	for _, name := range names {
+		header := fileMap[name]
+		if (rule.Type != nil && header.Type != *rule.Type) || !re.MatchString(name) {
+			continue
+		}
+
+		newName := replaceMatch(re, name, expandPlaceholders(rule.Replace, header, counter), rule.Global)
+		counter++
+
+		if newName == name {
+			continue
+		}
+
+		if newName == "" || newName == "." || newName == ".." || validateName(newName) != nil {
+			return nil, errorf(ErrInvalidName, "invalid new name %s of %s", newName, name)
+		}
+
+		renames = append(renames, Rename{Old: name, New: newName})
+		renamed[name] = true
+	}
+
+	taken := map[string]string{}
+	for _, r := range renames {
+		if other, ok := taken[r.New]; ok {
+			return nil, errorf(ErrExist, "both %s and %s are renamed to %s", other, r.Old, r.New)
+		}
+		taken[r.New] = r.Old
+
+		if _, ok := fileMap[r.New]; ok && !renamed[r.New] {
+			return nil, errorf(ErrExist, "%s is renamed to existing %s", r.Old, r.New)
+		}
+	}
+
+	return renames, nil
+}
+
+// RenameBatch: rename entries of folder by rule at once, nothing is renamed
+// if any name collide. With dryRun the renames are only returned
+func (cs *commandService) RenameBatch(dirName string, rule RenameRule, dryRun bool) ([]Rename, error) {
+	unlock, err := cs.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	block, err := cs.travelFolder(dirName)
+	if err != nil {
+		return nil, xerrors.Errorf("err in travelFolder: %w", err)
+	}
+
+	renames, err := PlanRename(block.FileMap, rule)
+	if err != nil || dryRun || len(renames) == 0 {
+		return renames, err
+	}
+
+	headers := make([]FileHeader, 0, len(renames))
+	for _, r := range renames {
+		header := block.FileMap[r.Old]
+		header.Name = r.New
+		headers = append(headers, header)
+	}
+
+	// save every header before the map is changed, so a failure leaves the
+	// block as it was
+	for i := range headers {
+		if err := headers[i].Save(block.GetBlockPath()); err != nil {
+			return nil, xerrors.Errorf("err in header.Save: %w", err)
+		}
+	}
+
+	// remove every old name first, so swapped names not overwrite each other
+	for _, r := range renames {
+		delete(block.FileMap, r.Old)
+	}
+
+	names := make([]string, 0, len(headers))
+	for _, header := range headers {
+		block.FileMap[header.Name] = header
+		names = append(names, header.Name)
+	}
+
+	if err := cs.saveBlocks(block); err != nil {
+		return nil, xerrors.Errorf("err in saveBlocks: %w", err)
+	}
+
+	if err := cs.reindex(block, names...); err != nil {
+		return nil, xerrors.Errorf("err in reindex: %w", err)
+	}
+
+	return renames, nil
+}
@@ -0,0 +1,135 @@
+package vfsgo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPlanRename(t *testing.T) {
+	created := time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC)
+	fileMap := map[string]FileHeader{}
+	for _, name := range []string{"img10.jpg", "img2.jpg", "img1.jpg", "notes"} {
+		fileMap[name] = FileHeader{Type: File, Name: name, CreatedTime: created}
+	}
+
+	renames, err := PlanRename(fileMap, RenameRule{Regex: `^img\d+\.(jpg)$`, Replace: "{date}_{n:3}.$1", Start: 1})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	want := []Rename{
+		{Old: "img1.jpg", New: "20240309_001.jpg"},
+		{Old: "img2.jpg", New: "20240309_002.jpg"},
+		{Old: "img10.jpg", New: "20240309_003.jpg"},
+	}
+	if len(renames) != len(want) {
+		t.Errorf("unexpected renames %v", renames)
+		return
+	}
+	for i := range want {
+		if renames[i] != want[i] {
+			t.Errorf("unexpected renames %v", renames)
+			return
+		}
+	}
+
+	if _, err := PlanRename(fileMap, RenameRule{Regex: `\d+`, Replace: "x"}); err == nil {
+		t.Error("renames to the same name should fail")
+		return
+	}
+
+	if _, err := PlanRename(fileMap, RenameRule{Regex: `^img1\.jpg$`, Replace: "notes"}); err == nil {
+		t.Error("rename to kept entry should fail")
+		return
+	}
+
+	if _, err := PlanRename(fileMap, RenameRule{Regex: `^notes$`, Replace: "a/b"}); err == nil {
+		t.Error("rename to invalid name should fail")
+		return
+	}
+}
+
+func TestRenameBatch(t *testing.T) {
+	cmdService := newFindTree(t)
+
+	if err := cmdService.ChangeFolder("a"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.RenameFile("note.txt", "b", ""); err == nil {
+		t.Error("rename to existing name should fail")
+		return
+	}
+
+	rule := RenameRule{Regex: `^(b|note\.txt)$`, Replace: "${1}_"}
+	renames, err := cmdService.RenameBatch(".", rule, true)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if len(renames) != 2 || cmdService.GetCurrentBlock().FileMap["b"].Name != "b" {
+		t.Error("dry run should not rename")
+		return
+	}
+
+	renames, err = cmdService.RenameBatch("/a", RenameRule{Regex: `^b$|^note\.txt$`, Replace: "{n}"}, false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if len(renames) != 2 {
+		t.Errorf("unexpected renames %v", renames)
+		return
+	}
+
+	// 0 -> 1 while 1 -> 2, names taken by renamed entries are free
+	renames, err = cmdService.RenameBatch(".", RenameRule{Regex: `^0$|^1$`, Replace: "{n}", Start: 1}, false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if len(renames) != 2 || renames[0].New != "1" || renames[1].New != "2" {
+		t.Errorf("unexpected renames %v", renames)
+		return
+	}
+
+	if err := cmdService.ChangeFolder("1"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if _, ok := cmdService.GetCurrentBlock().FileMap["deep.txt"]; !ok {
+		t.Error("renamed folder lost its entries")
+		return
+	}
+
+	if got := searchPaths(t, cmdService, "note"); got != "/a/1/deep.txt,/a/2" {
+		t.Errorf("unexpected search after rename %s", got)
+		return
+	}
+}
+
+func TestPlanRenameGlobal(t *testing.T) {
+	fileMap := map[string]FileHeader{"a-b-c": {Type: File, Name: "a-b-c"}}
+
+	for _, c := range []struct {
+		global bool
+		want   string
+	}{{false, "a_b-c"}, {true, "a_b_c"}} {
+		renames, err := PlanRename(fileMap, RenameRule{Regex: `-`, Replace: "_", Global: c.global})
+		if err != nil {
+			t.Error(err.Error())
+			return
+		}
+
+		if len(renames) != 1 || renames[0].New != c.want {
+			t.Errorf("unexpected renames %v", renames)
+			return
+		}
+	}
+}
@@ -0,0 +1,525 @@
+package vfsgo
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	SearchIndexFileName = ".searchIndex"
+
+	// MaxIndexContentSize: content larger than it is not indexed
+	MaxIndexContentSize = 1 << 20
+)
+
+// field weights of rank, a hit in name count more than in content
+var fieldWeights = [...]float64{3, 2, 1}
+
+// indexDoc: indexed entry, keyed by HashFileName which is kept on rename
+// and move, Ends are the end positions of name, description and content
+type indexDoc struct {
+	Block uint64
+	Name  string
+	Ends  [3]int
+	Terms []string
+}
+
+// SearchIndex: inverted index of names, descriptions and text content of
+// all entries of a user
+type SearchIndex struct {
+	path string
+
+	Docs map[string]*indexDoc
+	// Postings: term -> doc -> positions
+	Postings map[string]map[string][]int
+}
+
+// SearchResult: matched entry, higher score rank first
+type SearchResult struct {
+	Path   string
+	Score  float64
+	Header FileHeader
+}
+
+// tokenize: lower case words of s
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func newSearchIndex(user *User) *SearchIndex {
+	return &SearchIndex{
+		path:     user.GetUserPath() + "/" + SearchIndexFileName,
+		Docs:     make(map[string]*indexDoc),
+		Postings: make(map[string]map[string][]int),
+	}
+}
+
+// LoadSearchIndex: index of user, built from blocks when not exist yet
+func LoadSearchIndex(user *User) (*SearchIndex, error) {
+	index := newSearchIndex(user)
+
+	buf, err := readSealed(index.path)
+	if os.IsNotExist(err) {
+		return BuildSearchIndex(user)
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("error in readSealed: %w", err)
+	}
+
+	if err := json.Unmarshal(buf, index); err != nil {
+		return nil, xerrors.Errorf("error in json.Unmarshal: %w", err)
+	}
+
+	return index, nil
+}
+
+// BuildSearchIndex: index every entry of user and save it
+func BuildSearchIndex(user *User) (*SearchIndex, error) {
+	index := newSearchIndex(user)
+
+	for id := range user.BlockMap {
+		block := user.BlockMap[id]
+		for name := range block.FileMap {
+			if err := index.add(&block, name); err != nil {
+				return nil, xerrors.Errorf("error in index.add: %w", err)
+			}
+		}
+	}
+
+	if err := index.Save(); err != nil {
+		return nil, xerrors.Errorf("error in index.Save: %w", err)
+	}
+
+	return index, nil
+}
+
+func (s *SearchIndex) Save() error {
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return xerrors.Errorf("error in json.Marshal: %w", err)
+	}
+
+	if err := writeSealed(s.path, buf); err != nil {
+		return xerrors.Errorf("error in writeSealed: %w", err)
+	}
+
+	return nil
+}
+
+// add: index entry name of block, replace the old one of the same entry
+func (s *SearchIndex) add(block *BlockINode, name string) error {
+	header := block.FileMap[name]
+	s.remove(header.HashFileName)
+
+	content, err := indexContent(block, header)
+	if err != nil {
+		return xerrors.Errorf("error in indexContent: %w", err)
+	}
+
+	doc := &indexDoc{Block: block.NodeID, Name: name}
+	pos := 0
+	for i, text := range []string{header.Name, header.Description, content} {
+		for _, term := range tokenize(text) {
+			docs, ok := s.Postings[term]
+			if !ok {
+				docs = make(map[string][]int)
+				s.Postings[term] = docs
+			}
+
+			if _, ok := docs[header.HashFileName]; !ok {
+				doc.Terms = append(doc.Terms, term)
+			}
+			docs[header.HashFileName] = append(docs[header.HashFileName], pos)
+			pos++
+		}
+
+		doc.Ends[i] = pos
+		// gap so phrase not match across fields
+		pos++
+	}
+
+	s.Docs[header.HashFileName] = doc
+
+	return nil
+}
+
+// remove: drop doc of entry with hash from index
+func (s *SearchIndex) remove(hash string) {
+	doc, ok := s.Docs[hash]
+	if !ok {
+		return
+	}
+
+	for _, term := range doc.Terms {
+		delete(s.Postings[term], hash)
+		if len(s.Postings[term]) == 0 {
+			delete(s.Postings, term)
+		}
+	}
+
+	delete(s.Docs, hash)
+}
+
+// indexContent: text content of file, empty for folder, binary or large file
+func indexContent(block *BlockINode, header FileHeader) (string, error) {
+	if header.Type != File || header.Size == 0 || header.Size > MaxIndexContentSize {
+		return "", nil
+	}
+
+	file, err := OpenFileContent(block, header.Name)
+	if err != nil {
+		return "", xerrors.Errorf("error in OpenFileContent: %w", err)
+	}
+	defer file.Close()
+
+	buf, err := ioutil.ReadAll(io.LimitReader(file, MaxIndexContentSize))
+	if err != nil {
+		return "", xerrors.Errorf("error in ioutil.ReadAll: %w", err)
+	}
+
+	if !utf8.Valid(buf) {
+		return "", nil
+	}
+
+	return string(buf), nil
+}
+
+// searchClause: one part of query, every clause must match
+type searchClause struct {
+	terms  []string
+	prefix bool
+}
+
+// parseQuery: words are terms, word ending with * is prefix and words in
+// double quotes are phrase
+func parseQuery(query string) ([]searchClause, error) {
+	clauses := []searchClause{}
+
+	parts := strings.Split(query, "\"")
+	if len(parts)%2 == 0 {
+		return nil, NewError(ErrInvalidName, "unclosed quote in query")
+	}
+
+	for i, part := range parts {
+		if i%2 == 1 {
+			if terms := tokenize(part); len(terms) > 0 {
+				clauses = append(clauses, searchClause{terms: terms})
+			}
+			continue
+		}
+
+		for _, word := range strings.Fields(part) {
+			terms := tokenize(word)
+			prefix := strings.HasSuffix(word, "*")
+			if prefix && len(terms) == 0 {
+				return nil, errorf(ErrInvalidName, "no term before * of %s in query", word)
+			}
+
+			for _, term := range terms {
+				clauses = append(clauses, searchClause{terms: []string{term}})
+			}
+
+			if prefix {
+				clauses[len(clauses)-1].prefix = true
+			}
+		}
+	}
+
+	if len(clauses) == 0 {
+		return nil, NewError(ErrInvalidName, "empty query")
+	}
+
+	return clauses, nil
+}
+
+// match: positions where clause start in each doc
+func (s *SearchIndex) match(c searchClause) map[string][]int {
+	if c.prefix {
+		hits := make(map[string][]int)
+		for term, docs := range s.Postings {
+			if !strings.HasPrefix(term, c.terms[0]) {
+				continue
+			}
+
+			for hash, positions := range docs {
+				hits[hash] = append(hits[hash], positions...)
+			}
+		}
+		return hits
+	}
+
+	hits := make(map[string][]int)
+	for hash, positions := range s.Postings[c.terms[0]] {
+		hits[hash] = positions
+	}
+
+	// phrase: keep start where every next term follows
+	for i, term := range c.terms[1:] {
+		docs := s.Postings[term]
+		for hash, starts := range hits {
+			next := make(map[int]bool, len(docs[hash]))
+			for _, p := range docs[hash] {
+				next[p] = true
+			}
+
+			kept := starts[:0:0]
+			for _, p := range starts {
+				if next[p+i+1] {
+					kept = append(kept, p)
+				}
+			}
+
+			if len(kept) == 0 {
+				delete(hits, hash)
+			} else {
+				hits[hash] = kept
+			}
+		}
+	}
+
+	return hits
+}
+
+// Search: entries matching every clause of query ranked by weighted hits,
+// limit not positive means all
+func (s *SearchIndex) Search(user *User, query string, limit int) ([]SearchResult, error) {
+	clauses, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := map[string]float64{}
+	for i, c := range clauses {
+		hits := s.match(c)
+		idf := math.Log(1 + float64(len(s.Docs))/float64(len(hits)+1))
+
+		next := make(map[string]float64, len(hits))
+		for hash, positions := range hits {
+			if _, ok := scores[hash]; !ok && i > 0 {
+				continue
+			}
+
+			doc := s.Docs[hash]
+			score := 0.0
+			for _, p := range positions {
+				for field, end := range doc.Ends {
+					if p < end {
+						score += fieldWeights[field]
+						break
+					}
+				}
+			}
+			next[hash] = scores[hash] + score*idf
+		}
+		scores = next
+	}
+
+	results := make([]SearchResult, 0, len(scores))
+	for hash, score := range scores {
+		doc := s.Docs[hash]
+		block, ok := user.BlockMap[doc.Block]
+		if !ok {
+			continue
+		}
+
+		dir, err := blockPath(user, doc.Block)
+		if err != nil {
+			continue
+		}
+
+		results = append(results, SearchResult{
+			Path:   joinPath(dir, doc.Name),
+			Score:  score,
+			Header: block.FileMap[doc.Name],
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Path < results[j].Path
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results, nil
+}
+
+// blockPath: absolute path of block, found through folder entry in parent
+func blockPath(user *User, id uint64) (string, error) {
+	if id == 0 {
+		return "/", nil
+	}
+
+	block, ok := user.BlockMap[id]
+	if !ok {
+		return "", errorf(ErrNotExist, "block %d not exist", id)
+	}
+
+	parent, ok := user.BlockMap[block.PrevNodeID]
+	if !ok {
+		return "", errorf(ErrNotExist, "block %d not exist", block.PrevNodeID)
+	}
+
+	for name, header := range parent.FileMap {
+		if header.DirNodeID != nil && *header.DirNodeID == id {
+			dir, err := blockPath(user, parent.NodeID)
+			if err != nil {
+				return "", err
+			}
+			return joinPath(dir, name), nil
+		}
+	}
+
+	return "", errorf(ErrNotExist, "block %d not in parent", id)
+}
+
+// Search: entries of current user matching query, see SearchIndex.Search
+func (cs *commandService) Search(query string, limit int) ([]SearchResult, error) {
+	unlock, err := cs.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if cs.currentUser == nil {
+		return nil, NewError(ErrNoUser, "current user is nil")
+	}
+
+	if cs.currentUser.index == nil {
+		index, err := LoadSearchIndex(cs.currentUser)
+		if err != nil {
+			return nil, xerrors.Errorf("err in LoadSearchIndex: %w", err)
+		}
+		cs.currentUser.index = index
+	}
+
+	results, err := cs.currentUser.index.Search(cs.currentUser, query, limit)
+	if err != nil {
+		return nil, xerrors.Errorf("err in Search: %w", err)
+	}
+
+	return results, nil
+}
+
+// updateIndex: apply fn to index of current user and save it, skipped if
+// the index is not built yet since it is built from blocks on first search
+func (cs *commandService) updateIndex(fn func(index *SearchIndex) error) error {
+	user := cs.currentUser
+	if user.index == nil {
+		if _, err := os.Stat(newSearchIndex(user).path); os.IsNotExist(err) {
+			return nil
+		}
+
+		index, err := LoadSearchIndex(user)
+		if err != nil {
+			return xerrors.Errorf("err in LoadSearchIndex: %w", err)
+		}
+		user.index = index
+	}
+
+	if err := fn(user.index); err != nil {
+		return err
+	}
+
+	if cs.batch > 0 {
+		if cs.dirty != nil && cs.dirty != user {
+			if err := cs.dirty.index.Save(); err != nil {
+				return xerrors.Errorf("err in index.Save: %w", err)
+			}
+		}
+		cs.dirty = user
+		return nil
+	}
+
+	if err := user.index.Save(); err != nil {
+		return xerrors.Errorf("err in index.Save: %w", err)
+	}
+
+	return nil
+}
+
+// BeginBatch: index changes are kept in memory until EndBatch, so a
+// command changing many entries saves index once. Batches can be nested
+func (cs *commandService) BeginBatch() error {
+	cs.batch++
+	return nil
+}
+
+// EndBatch: end batch of BeginBatch, index is saved when the outer batch
+// ends
+func (cs *commandService) EndBatch() error {
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return cs.endBatch()
+}
+
+func (cs *commandService) endBatch() error {
+	if cs.batch == 0 {
+		return nil
+	}
+
+	cs.batch--
+	if cs.batch > 0 || cs.dirty == nil {
+		return nil
+	}
+
+	user := cs.dirty
+	cs.dirty = nil
+	if err := user.index.Save(); err != nil {
+		return xerrors.Errorf("err in index.Save: %w", err)
+	}
+
+	return nil
+}
+
+// reindex: index entries of block again after they changed
+func (cs *commandService) reindex(block *BlockINode, names ...string) error {
+	return cs.updateIndex(func(index *SearchIndex) error {
+		for _, name := range names {
+			if err := index.add(block, name); err != nil {
+				return xerrors.Errorf("err in index.add: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// unindex: drop entry and everything under it from index
+func (cs *commandService) unindex(header FileHeader) error {
+	return cs.updateIndex(func(index *SearchIndex) error {
+		var drop func(header FileHeader)
+		drop = func(header FileHeader) {
+			index.remove(header.HashFileName)
+
+			if header.Type != Directory || header.DirNodeID == nil {
+				return
+			}
+
+			for _, child := range cs.currentUser.BlockMap[*header.DirNodeID].FileMap {
+				drop(child)
+			}
+		}
+
+		drop(header)
+		return nil
+	})
+}
@@ -0,0 +1,136 @@
+package vfsgo
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func searchPaths(t *testing.T, cmdService ICommandService, query string) string {
+	results, err := cmdService.Search(query, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	paths := make([]string, 0, len(results))
+	for _, r := range results {
+		paths = append(paths, r.Path)
+	}
+
+	return strings.Join(paths, ",")
+}
+
+func TestSearch(t *testing.T) {
+	cmdService := newFindTree(t)
+
+	if got := searchPaths(t, cmdService, "note"); got != "/a/note.txt,/a/b/deep.txt" {
+		t.Errorf("unexpected term result %s", got)
+		return
+	}
+
+	if got := searchPaths(t, cmdService, "de*"); got != "/a/b/deep.txt" {
+		t.Errorf("unexpected prefix result %s", got)
+		return
+	}
+
+	if got := searchPaths(t, cmdService, `"deep note"`); got != "/a/b/deep.txt" {
+		t.Errorf("unexpected phrase result %s", got)
+		return
+	}
+
+	if got := searchPaths(t, cmdService, `"note deep"`); got != "" {
+		t.Errorf("unexpected reversed phrase result %s", got)
+		return
+	}
+
+	// index follows changes after it is built
+	must(t, cmdService.ChangeFolder("/a"))
+	must(t, cmdService.WriteFile("note.txt", strings.NewReader("Quarterly Report draft")))
+	must(t, cmdService.RenameFolder("b", "archive"))
+	must(t, cmdService.ChangeFolder("/"))
+	must(t, cmdService.Move("a/archive", "c"))
+	must(t, cmdService.Remove("top.log"))
+
+	if got := searchPaths(t, cmdService, "quarterly report"); got != "/a/note.txt" {
+		t.Errorf("unexpected content result %s", got)
+		return
+	}
+
+	if got := searchPaths(t, cmdService, "deep"); got != "/c/archive/deep.txt" {
+		t.Errorf("unexpected result after rename and move %s", got)
+		return
+	}
+
+	if got := searchPaths(t, cmdService, "log"); got != "" {
+		t.Errorf("removed entry still found %s", got)
+		return
+	}
+
+	// persisted index is loaded by new service
+	reloaded := NewCommandService(cmdService.GetCurrentUser().RootPath)
+	if err := reloaded.Use("testFind"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if got := searchPaths(t, reloaded, "archive"); got != "/c/archive" {
+		t.Errorf("unexpected result after reload %s", got)
+		return
+	}
+
+	if _, err := cmdService.Search(`"open`, 0); err == nil {
+		t.Error("unclosed quote should fail")
+		return
+	}
+
+	if _, err := cmdService.Search("archive *", 0); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("lone * should fail: %v", err)
+		return
+	}
+}
+
+func TestSearchBatch(t *testing.T) {
+	cmdService := newFindTree(t)
+	searchPaths(t, cmdService, "note")
+
+	path := newSearchIndex(cmdService.GetCurrentUser()).path
+	before, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// index is only saved when the outer batch ends
+	must(t, cmdService.BeginBatch())
+	must(t, cmdService.BeginBatch())
+	must(t, cmdService.CreateFile("memo", "weekly"))
+	must(t, cmdService.Remove("top.log"))
+	must(t, cmdService.EndBatch())
+
+	if got := searchPaths(t, cmdService, "weekly"); got != "/memo" {
+		t.Errorf("unexpected result in batch %s", got)
+		return
+	}
+
+	if during, _ := ioutil.ReadFile(path); !bytes.Equal(during, before) {
+		t.Error("index saved before batch ends")
+		return
+	}
+
+	must(t, cmdService.EndBatch())
+
+	reloaded := NewCommandService(cmdService.GetCurrentUser().RootPath)
+	must(t, reloaded.Use("testFind"))
+
+	if got := searchPaths(t, reloaded, "weekly"); got != "/memo" {
+		t.Errorf("unexpected result after batch %s", got)
+		return
+	}
+
+	if got := searchPaths(t, reloaded, "top"); got != "" {
+		t.Errorf("removed entry still found %s", got)
+		return
+	}
+}
@@ -0,0 +1,313 @@
+package sftpserver
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/lemotw/vfsgo"
+	"github.com/pkg/sftp"
+	"golang.org/x/xerrors"
+)
+
+// handler: translate sftp request to command service, command service keep
+// current folder and share cached users so every request is serialized by mu
+// of server
+type handler struct {
+	mu *sync.Mutex
+	cs vfsgo.ICommandService
+}
+
+func newHandler(cs vfsgo.ICommandService, mu *sync.Mutex) *handler {
+	return &handler{mu: mu, cs: cs}
+}
+
+// chdir: change to folder of filePath and return the base name
+func (h *handler) chdir(filePath string) (string, error) {
+	dir, name := path.Split(path.Clean(filePath))
+	if err := h.cs.ChangeFolder(dir); err != nil {
+		return "", status(err)
+	}
+
+	return name, nil
+}
+
+func (h *handler) stat(filePath string) (vfsgo.FileHeader, error) {
+	header, err := h.cs.Stat(filePath)
+	if err != nil {
+		return vfsgo.FileHeader{}, status(err)
+	}
+
+	return header, nil
+}
+
+func (h *handler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	header, err := h.stat(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Type != vfsgo.File {
+		return nil, sftp.ErrSSHFxFailure
+	}
+
+	name, err := h.chdir(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := h.cs.ReadFile(name)
+	if err != nil {
+		return nil, status(err)
+	}
+
+	if ra, ok := content.(io.ReaderAt); ok {
+		return ra, nil
+	}
+
+	content.Close()
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+func (h *handler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	dir, err := h.stat(path.Dir(r.Filepath))
+	if err != nil {
+		return nil, err
+	}
+
+	if dir.Type != vfsgo.Directory {
+		return nil, sftp.ErrSSHFxNoSuchFile
+	}
+
+	header, err := h.stat(r.Filepath)
+	exist := err == nil
+	if exist && header.Type != vfsgo.File {
+		return nil, sftp.ErrSSHFxFailure
+	}
+
+	tmp, err := os.CreateTemp("", "vfsgo-sftp-*")
+	if err != nil {
+		return nil, xerrors.Errorf("error in os.CreateTemp: %w", err)
+	}
+
+	// without truncate the upload starts from existing content, so append
+	// and write at offset keep the rest of file
+	if exist && !r.Pflags().Trunc {
+		if err := h.seed(tmp, r.Filepath); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return nil, err
+		}
+	}
+
+	return &uploadFile{File: tmp, h: h, filePath: r.Filepath}, nil
+}
+
+// seed: copy content of filePath into tmp
+func (h *handler) seed(tmp *os.File, filePath string) error {
+	name, err := h.chdir(filePath)
+	if err != nil {
+		return err
+	}
+
+	content, err := h.cs.ReadFile(name)
+	if err != nil {
+		return status(err)
+	}
+	defer content.Close()
+
+	if _, err := io.Copy(tmp, content); err != nil {
+		return xerrors.Errorf("error in io.Copy: %w", err)
+	}
+
+	return nil
+}
+
+func (h *handler) Filecmd(r *sftp.Request) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch r.Method {
+	case "Setstat":
+		// timestamps and permissions are not kept, accept to let clients finish put
+		if _, err := h.stat(r.Filepath); err != nil {
+			return err
+		}
+		return nil
+	case "Rename":
+		header, err := h.stat(r.Filepath)
+		if err != nil {
+			return err
+		}
+
+		if path.Dir(path.Clean(r.Filepath)) != path.Dir(path.Clean(r.Target)) {
+			return sftp.ErrSSHFxOpUnsupported
+		}
+
+		name, err := h.chdir(r.Filepath)
+		if err != nil {
+			return err
+		}
+
+		if header.Type == vfsgo.Directory {
+			return status(h.cs.RenameFolder(name, path.Base(r.Target)))
+		}
+		return status(h.cs.RenameFile(name, path.Base(r.Target), header.Description))
+	case "Remove":
+		name, err := h.chdir(r.Filepath)
+		if err != nil {
+			return err
+		}
+		return status(h.cs.DeleteFile(name))
+	case "Rmdir":
+		name, err := h.chdir(r.Filepath)
+		if err != nil {
+			return err
+		}
+		return status(h.cs.DeleteFolder(name))
+	case "Mkdir":
+		name, err := h.chdir(r.Filepath)
+		if err != nil {
+			return err
+		}
+		return status(h.cs.CreateFolder(name))
+	}
+
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (h *handler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch r.Method {
+	case "List":
+		names, err := h.cs.List(r.Filepath, nil, nil)
+		if err != nil {
+			return nil, status(err)
+		}
+
+		infos := make(listerAt, 0, len(names))
+		for _, name := range names {
+			header, err := h.stat(path.Join(r.Filepath, name))
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, fileInfo{header: header})
+		}
+
+		return infos, nil
+	case "Stat":
+		header, err := h.stat(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+
+		if header.Name == "" {
+			header.Name = path.Base(r.Filepath)
+		}
+		return listerAt{fileInfo{header: header}}, nil
+	}
+
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// status: error with sftp status code of vfsgo error kind, others are sent
+// as failure with message
+func status(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, fs.ErrNotExist):
+		return sftp.ErrSSHFxNoSuchFile
+	case errors.Is(err, fs.ErrPermission):
+		return sftp.ErrSSHFxPermissionDenied
+	}
+
+	return err
+}
+
+// uploadFile: sftp write at random offset, so buffer to a temp file and write
+// into vfsgo when client close the handle
+type uploadFile struct {
+	*os.File
+	h        *handler
+	filePath string
+}
+
+func (u *uploadFile) Close() error {
+	defer os.Remove(u.File.Name())
+	defer u.File.Close()
+
+	if _, err := u.File.Seek(0, io.SeekStart); err != nil {
+		return xerrors.Errorf("error in Seek: %w", err)
+	}
+
+	u.h.mu.Lock()
+	defer u.h.mu.Unlock()
+
+	if _, err := u.h.stat(u.filePath); err != nil {
+		name, err := u.h.chdir(u.filePath)
+		if err != nil {
+			return err
+		}
+
+		if err := u.h.cs.CreateFile(name, ""); err != nil {
+			return xerrors.Errorf("error in cs.CreateFile: %w", err)
+		}
+	}
+
+	name, err := u.h.chdir(u.filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := u.h.cs.WriteFile(name, u.File); err != nil {
+		return xerrors.Errorf("error in cs.WriteFile: %w", err)
+	}
+
+	return nil
+}
+
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// fileInfo: os.FileInfo of FileHeader
+type fileInfo struct {
+	header vfsgo.FileHeader
+}
+
+func (f fileInfo) Name() string       { return f.header.Name }
+func (f fileInfo) Size() int64        { return f.header.Size }
+func (f fileInfo) ModTime() time.Time { return f.header.ModifiedTime }
+func (f fileInfo) IsDir() bool        { return f.header.Type == vfsgo.Directory }
+func (f fileInfo) Sys() interface{}   { return nil }
+
+func (f fileInfo) Mode() os.FileMode {
+	if f.IsDir() {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
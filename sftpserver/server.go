@@ -0,0 +1,103 @@
+package sftpserver
+
+import (
+	"net"
+	"sync"
+
+	"github.com/lemotw/vfsgo"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/xerrors"
+)
+
+// Server: ssh server which only provide the sftp subsystem, the ssh user
+// name is the vfsgo user name. Connections share cached users and requests
+// are run one at a time
+type Server struct {
+	root   string
+	config *ssh.ServerConfig
+
+	// mu: held while a request is run, users is only touched under it
+	mu    sync.Mutex
+	users map[string]*vfsgo.User
+}
+
+// NewServer: config should already have authentication and host key set
+func NewServer(root string, config *ssh.ServerConfig) *Server {
+	return &Server{
+		root:   root,
+		config: config,
+		users:  make(map[string]*vfsgo.User),
+	}
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return xerrors.Errorf("error in l.Accept: %w", err)
+		}
+
+		go s.ServeConn(conn)
+	}
+}
+
+func (s *Server) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return xerrors.Errorf("error in ssh.NewServerConn: %w", err)
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	// every connection has it own current user and folder
+	s.mu.Lock()
+	cs := vfsgo.NewCommandServiceWithUsers(s.root, s.users)
+	err = cs.Use(sconn.User())
+	s.mu.Unlock()
+	if err != nil {
+		return xerrors.Errorf("error in cs.Use: %w", err)
+	}
+	h := newHandler(cs, &s.mu)
+
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, requests, err := newChan.Accept()
+		if err != nil {
+			return xerrors.Errorf("error in newChan.Accept: %w", err)
+		}
+
+		go s.serveChannel(channel, requests, h)
+	}
+
+	return nil
+}
+
+func (s *Server) serveChannel(channel ssh.Channel, requests <-chan *ssh.Request, h *handler) {
+	defer channel.Close()
+
+	for req := range requests {
+		// payload of subsystem request is ssh string: uint32 length + name
+		if req.Type != "subsystem" || len(req.Payload) < 4 || string(req.Payload[4:]) != "sftp" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+
+		server := sftp.NewRequestServer(channel, sftp.Handlers{
+			FileGet:  h,
+			FilePut:  h,
+			FileCmd:  h,
+			FileList: h,
+		})
+		server.Serve()
+		server.Close()
+		return
+	}
+}
@@ -0,0 +1,251 @@
+package sftpserver
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/lemotw/vfsgo"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+func startServer(t *testing.T, root string) string {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go NewServer(root, config).Serve(l)
+
+	return l.Addr().String()
+}
+
+func dial(t *testing.T, addr, user string) *sftp.Client {
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.Password("pass")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func TestSFTP(t *testing.T) {
+	root := t.TempDir()
+	if err := vfsgo.NewCommandService(root).Register("alice"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	client := dial(t, startServer(t, root), "alice")
+
+	if err := client.Mkdir("/docs"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// put
+	file, err := client.Create("/docs/note")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if _, err := file.Write([]byte("hello sftp")); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if err := file.Close(); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// list
+	infos, err := client.ReadDir("/docs")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if len(infos) != 1 || infos[0].Name() != "note" || infos[0].Size() != 10 {
+		t.Error("list /docs not match")
+		return
+	}
+
+	// rename
+	if err := client.Rename("/docs/note", "/docs/memo"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// get
+	file, err = client.Open("/docs/memo")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	content, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if string(content) != "hello sftp" {
+		t.Errorf("content %q not match", content)
+		return
+	}
+
+	// remove
+	if err := client.Remove("/docs/memo"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if _, err := client.Stat("/docs/memo"); err == nil {
+		t.Error("removed file still exist")
+		return
+	}
+
+	if err := client.RemoveDirectory("/docs"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	infos, err = client.ReadDir("/")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if len(infos) != 0 {
+		t.Error("root should be empty")
+		return
+	}
+}
+
+func TestSFTPUnknownUser(t *testing.T) {
+	root := t.TempDir()
+	addr := startServer(t, root)
+
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "nobody",
+		Auth:            []ssh.AuthMethod{ssh.Password("pass")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if client, err := sftp.NewClient(conn); err == nil {
+		client.Close()
+		t.Error("unknown user should not get sftp session")
+		return
+	}
+}
+
+func TestSFTPSharedUser(t *testing.T) {
+	root := t.TempDir()
+	if err := vfsgo.NewCommandService(root).Register("alice"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	addr := startServer(t, root)
+	first, second := dial(t, addr, "alice"), dial(t, addr, "alice")
+
+	if err := first.Mkdir("/docs"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// both connections use the one cached user
+	if info, err := second.Stat("/docs"); err != nil || !info.IsDir() {
+		t.Errorf("folder of other connection not seen: %v", err)
+		return
+	}
+}
+
+func TestSFTPAppend(t *testing.T) {
+	root := t.TempDir()
+	if err := vfsgo.NewCommandService(root).Register("alice"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	client := dial(t, startServer(t, root), "alice")
+
+	writeAt := func(flags int, off int64, data string) error {
+		file, err := client.OpenFile("/note", flags)
+		if err != nil {
+			return err
+		}
+
+		if _, err := file.WriteAt([]byte(data), off); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}
+
+	if err := writeAt(os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0, "hello"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// append and overwrite at offset keep the rest of file
+	if err := writeAt(os.O_WRONLY|os.O_APPEND, 5, " sftp"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := writeAt(os.O_WRONLY, 0, "J"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	file, err := client.Open("/note")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	content, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if string(content) != "Jello sftp" {
+		t.Errorf("content %q not match", content)
+		return
+	}
+}
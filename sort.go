@@ -0,0 +1,138 @@
+package vfsgo
+
+import (
+	"sort"
+	"strings"
+)
+
+// SortKey: one key of multi key sort
+type SortKey struct {
+	Field SortType
+	Order string
+}
+
+// SortHeaders: stable sort headers by keys, later key break tie of former
+// ones and name ascending break the last tie. Directories come before files
+// if dirsFirst, whatever the keys are.
+func SortHeaders(headers []FileHeader, keys []SortKey, dirsFirst bool) {
+	sort.SliceStable(headers, func(i, j int) bool {
+		return lessHeader(headers[i], headers[j], keys, dirsFirst)
+	})
+}
+
+// SortMatches: SortHeaders on headers of matches, paths break tie of name
+func SortMatches(matches []Match, keys []SortKey, dirsFirst bool) {
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if lessHeader(a.Header, b.Header, keys, dirsFirst) {
+			return true
+		}
+
+		if lessHeader(b.Header, a.Header, keys, dirsFirst) {
+			return false
+		}
+		return a.Path < b.Path
+	})
+}
+
+func lessHeader(a, b FileHeader, keys []SortKey, dirsFirst bool) bool {
+	if dirsFirst && a.Type != b.Type {
+		return a.Type == Directory
+	}
+
+	for _, key := range keys {
+		c := compareField(a, b, key.Field)
+		if key.Order == DESC {
+			c = -c
+		}
+
+		if c != 0 {
+			return c < 0
+		}
+	}
+
+	return naturalCompare(a.Name, b.Name) < 0
+}
+
+func compareField(a, b FileHeader, field SortType) int {
+	switch field {
+	case SortByName:
+		return naturalCompare(a.Name, b.Name)
+	case SortByCreatedTime:
+		return a.CreatedTime.Compare(b.CreatedTime)
+	case SortByModifiedTime:
+		return a.ModifiedTime.Compare(b.ModifiedTime)
+	case SortBySize:
+		return compareInt(a.Size, b.Size)
+	case SortByType:
+		return compareInt(int64(a.Type), int64(b.Type))
+	case SortByDescription:
+		return naturalCompare(a.Description, b.Description)
+	}
+
+	return 0
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
+
+// NaturalLess: name order with digit runs compared as numbers, so "file2"
+// come before "file10"
+func NaturalLess(a, b string) bool {
+	return naturalCompare(a, b) < 0
+}
+
+func naturalCompare(a, b string) int {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			na, nb := digitPrefix(a), digitPrefix(b)
+			a, b = a[len(na):], b[len(nb):]
+
+			// longer number without leading zeros is bigger
+			ta, tb := strings.TrimLeft(na, "0"), strings.TrimLeft(nb, "0")
+			if len(ta) != len(tb) {
+				return compareInt(int64(len(ta)), int64(len(tb)))
+			}
+
+			if c := strings.Compare(ta, tb); c != 0 {
+				return c
+			}
+
+			// same number, less leading zeros first
+			if len(na) != len(nb) {
+				return compareInt(int64(len(na)), int64(len(nb)))
+			}
+
+			continue
+		}
+
+		if a[0] != b[0] {
+			return compareInt(int64(a[0]), int64(b[0]))
+		}
+
+		a, b = a[1:], b[1:]
+	}
+
+	return compareInt(int64(len(a)), int64(len(b)))
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func digitPrefix(s string) string {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+
+	return s[:i]
+}
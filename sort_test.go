@@ -0,0 +1,90 @@
+package vfsgo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNaturalLess(t *testing.T) {
+	cases := []struct {
+		a, b string
+		less bool
+	}{
+		{"file2", "file10", true},
+		{"file10", "file2", false},
+		{"a", "b", true},
+		{"file", "file1", true},
+		{"file01", "file1", false},
+		{"file1", "file01", true},
+		{"v1.10", "v1.9", false},
+		{"x", "x", false},
+	}
+
+	for _, c := range cases {
+		if NaturalLess(c.a, c.b) != c.less {
+			t.Errorf("NaturalLess(%q, %q) != %v", c.a, c.b, c.less)
+		}
+	}
+}
+
+func TestSortHeaders(t *testing.T) {
+	now := time.Now()
+	headers := []FileHeader{
+		{Name: "file10", Type: File, Size: 1, ModifiedTime: now},
+		{Name: "dir", Type: Directory, ModifiedTime: now.Add(time.Second)},
+		{Name: "file2", Type: File, Size: 1, ModifiedTime: now.Add(2 * time.Second)},
+		{Name: "big", Type: File, Size: 9, ModifiedTime: now},
+	}
+
+	SortHeaders(headers, []SortKey{{Field: SortBySize, Order: DESC}}, true)
+	expect := []string{"dir", "big", "file2", "file10"}
+	for i := range expect {
+		if headers[i].Name != expect[i] {
+			t.Errorf("size desc dirs first: %d is %s not %s", i, headers[i].Name, expect[i])
+			return
+		}
+	}
+
+	SortHeaders(headers, []SortKey{{Field: SortBySize, Order: ASC}, {Field: SortByModifiedTime, Order: DESC}}, false)
+	expect = []string{"dir", "file2", "file10", "big"}
+	for i := range expect {
+		if headers[i].Name != expect[i] {
+			t.Errorf("size asc modified desc: %d is %s not %s", i, headers[i].Name, expect[i])
+			return
+		}
+	}
+}
+
+func TestListSortByCreatedTime(t *testing.T) {
+	cmdService := NewCommandService(t.TempDir())
+
+	if err := cmdService.Register("testListSort"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := cmdService.Use("testListSort"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	for _, name := range []string{"b", "a"} {
+		if err := cmdService.CreateFolder(name); err != nil {
+			t.Error(err.Error())
+			return
+		}
+	}
+
+	// folder names have "/" suffix in List
+	sortField, sortOrder := SortByCreatedTime, ASC
+	files, err := cmdService.List(".", &sortField, &sortOrder)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if len(files) != 2 || files[0] != "b/" || files[1] != "a/" {
+		t.Error("folders not sorted by created time")
+		return
+	}
+}
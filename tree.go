@@ -0,0 +1,60 @@
+package vfsgo
+
+import (
+	"golang.org/x/xerrors"
+)
+
+// TreeNode: entry with the entries under it when it is a folder
+type TreeNode struct {
+	Header   FileHeader
+	Children []TreeNode
+}
+
+// Count: folders and files under node, node itself is not counted
+func (n *TreeNode) Count() (dirs int, files int) {
+	for i := range n.Children {
+		if n.Children[i].Header.Type == Directory {
+			dirs++
+		} else {
+			files++
+		}
+
+		d, f := n.Children[i].Count()
+		dirs += d
+		files += f
+	}
+
+	return dirs, files
+}
+
+// BuildTree: entries under block in natural name order, entries directly in
+// block have depth 1, depth not positive means unlimited
+func BuildTree(user *User, block *BlockINode, depth int) ([]TreeNode, error) {
+	headers := make([]FileHeader, 0, len(block.FileMap))
+	for _, header := range block.FileMap {
+		headers = append(headers, header)
+	}
+	SortHeaders(headers, nil, false)
+
+	nodes := make([]TreeNode, 0, len(headers))
+	for _, header := range headers {
+		node := TreeNode{Header: header}
+
+		if header.Type == Directory && header.DirNodeID != nil && depth != 1 {
+			child, ok := user.BlockMap[*header.DirNodeID]
+			if !ok {
+				return nil, errorf(ErrNotExist, "block of %s not exist", header.Name)
+			}
+
+			children, err := BuildTree(user, &child, depth-1)
+			if err != nil {
+				return nil, xerrors.Errorf("err in BuildTree: %w", err)
+			}
+			node.Children = children
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
@@ -0,0 +1,41 @@
+package vfsgo
+
+import (
+	"testing"
+)
+
+func TestTree(t *testing.T) {
+	cmdService := newFindTree(t)
+
+	tree, err := cmdService.Tree("/", 0)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if dirs, files := tree.Count(); dirs != 3 || files != 3 {
+		t.Errorf("unexpected count %d dirs %d files", dirs, files)
+		return
+	}
+
+	if len(tree.Children) != 3 || tree.Children[0].Header.Name != "a" || tree.Children[0].Children[0].Header.Name != "b" {
+		t.Error("unexpected tree order")
+		return
+	}
+
+	tree, err = cmdService.Tree("a", 1)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if dirs, files := tree.Count(); dirs != 1 || files != 1 {
+		t.Errorf("unexpected count with depth %d dirs %d files", dirs, files)
+		return
+	}
+
+	if _, err := cmdService.Tree("top.log", 0); err == nil {
+		t.Error("tree of file should fail")
+		return
+	}
+}
@@ -0,0 +1,226 @@
+package vfsgo
+
+import (
+	"sort"
+
+	"golang.org/x/xerrors"
+)
+
+// BlockUsage: bytes and entries in the whole subtree of a block, Stored is
+// bytes content take in blob store after compression
+type BlockUsage struct {
+	Bytes  int64 `json:"bytes"`
+	Stored int64 `json:"stored"`
+	Files  int   `json:"files"`
+	Dirs   int   `json:"dirs"`
+}
+
+func (u *BlockUsage) add(d BlockUsage) {
+	u.Bytes += d.Bytes
+	u.Stored += d.Stored
+	u.Files += d.Files
+	u.Dirs += d.Dirs
+}
+
+func (u BlockUsage) neg() BlockUsage {
+	return BlockUsage{Bytes: -u.Bytes, Stored: -u.Stored, Files: -u.Files, Dirs: -u.Dirs}
+}
+
+// DiskUsage: usage of folder at Path with usage of folders under it
+type DiskUsage struct {
+	Path string
+	BlockUsage
+	Children []DiskUsage
+}
+
+// ComputeUsage: usage of block, folders are broken down in Children down to
+// depth, 0 lists none and negative is unlimited. Cached aggregate of block
+// is used when exist so a summary does not rescan the subtree
+func ComputeUsage(user *User, block *BlockINode, dir string, depth int) (DiskUsage, error) {
+	du := DiskUsage{Path: dir}
+	cached := block.Usage != nil
+
+	names := make([]string, 0, len(block.FileMap))
+	for name := range block.FileMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		header := block.FileMap[name]
+
+		if header.Type != Directory || header.DirNodeID == nil {
+			if !cached {
+				du.Bytes += header.Size
+				du.Stored += header.StoredSize
+				du.Files++
+			}
+			continue
+		}
+
+		if !cached {
+			du.Dirs++
+		} else if depth == 0 {
+			continue
+		}
+
+		child, ok := user.BlockMap[*header.DirNodeID]
+		if !ok {
+			return DiskUsage{}, errorf(ErrNotExist, "block of %s not exist", name)
+		}
+
+		c, err := ComputeUsage(user, &child, joinPath(dir, name), depth-1)
+		if err != nil {
+			return DiskUsage{}, xerrors.Errorf("err in ComputeUsage: %w", err)
+		}
+
+		if !cached {
+			du.add(c.BlockUsage)
+		}
+
+		if depth != 0 {
+			du.Children = append(du.Children, c)
+		}
+	}
+
+	if cached {
+		du.BlockUsage = *block.Usage
+	}
+
+	return du, nil
+}
+
+// Usage: usage of folder dirPath, see ComputeUsage
+func (cs *commandService) Usage(dirPath string, depth int) (DiskUsage, error) {
+	block, err := cs.travelFolder(dirPath)
+	if err != nil {
+		return DiskUsage{}, xerrors.Errorf("err in travelFolder: %w", err)
+	}
+
+	du, err := ComputeUsage(cs.currentUser, block, dirPath, depth)
+	if err != nil {
+		return DiskUsage{}, xerrors.Errorf("err in ComputeUsage: %w", err)
+	}
+
+	return du, nil
+}
+
+// SetUsageCache: keep aggregate usage in every block of current user, it is
+// updated on every change instead of rescanning on Usage
+func (cs *commandService) SetUsageCache(enabled bool) error {
+	unlock, err := cs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if cs.currentUser == nil {
+		return NewError(ErrNoUser, "current user is nil")
+	}
+
+	if enabled {
+		if _, err := cs.cacheUsage(0); err != nil {
+			return xerrors.Errorf("err in cacheUsage: %w", err)
+		}
+	} else {
+		for id, block := range cs.currentUser.BlockMap {
+			block.Usage = nil
+			cs.currentUser.BlockMap[id] = block
+		}
+	}
+	cs.currentUser.UsageCache = enabled
+
+	blocks := make([]*BlockINode, 0, len(cs.currentUser.BlockMap))
+	for id := range cs.currentUser.BlockMap {
+		block := cs.currentUser.BlockMap[id]
+		blocks = append(blocks, &block)
+	}
+
+	if err := cs.writeBlocks(blocks...); err != nil {
+		return xerrors.Errorf("err in writeBlocks: %w", err)
+	}
+
+	if err := cs.currentUser.Save(); err != nil {
+		return xerrors.Errorf("err in currentUser.Save: %w", err)
+	}
+
+	return nil
+}
+
+func (cs *commandService) cacheUsage(id uint64) (BlockUsage, error) {
+	block, ok := cs.currentUser.BlockMap[id]
+	if !ok {
+		return BlockUsage{}, errorf(ErrNotExist, "block %d not exist", id)
+	}
+
+	usage := BlockUsage{}
+	for _, header := range block.FileMap {
+		if header.Type != Directory || header.DirNodeID == nil {
+			usage.add(fileUsage(header))
+			continue
+		}
+
+		child, err := cs.cacheUsage(*header.DirNodeID)
+		if err != nil {
+			return BlockUsage{}, err
+		}
+		usage.add(child)
+		usage.Dirs++
+	}
+
+	block.Usage = &usage
+	cs.currentUser.BlockMap[id] = block
+
+	return usage, nil
+}
+
+// entryUsage: usage an entry adds to its folder, only valid with cache
+func (cs *commandService) entryUsage(header FileHeader) BlockUsage {
+	if header.Type != Directory || header.DirNodeID == nil {
+		return fileUsage(header)
+	}
+
+	usage := BlockUsage{Dirs: 1}
+	if child, ok := cs.currentUser.BlockMap[*header.DirNodeID]; ok && child.Usage != nil {
+		usage.add(*child.Usage)
+	}
+
+	return usage
+}
+
+func fileUsage(header FileHeader) BlockUsage {
+	return BlockUsage{Bytes: header.Size, Stored: header.StoredSize, Files: 1}
+}
+
+// adjustUsage: add delta to cached usage of block id and all its ancestors,
+// the user is saved by caller with the rest of the change
+func (cs *commandService) adjustUsage(id uint64, delta BlockUsage) error {
+	if !cs.currentUser.UsageCache {
+		return nil
+	}
+
+	blocks := []*BlockINode{}
+	for {
+		block, ok := cs.currentUser.BlockMap[id]
+		if !ok {
+			return errorf(ErrNotExist, "block %d not exist", id)
+		}
+
+		if block.Usage == nil {
+			block.Usage = &BlockUsage{}
+		}
+		block.Usage.add(delta)
+		blocks = append(blocks, &block)
+
+		if id == block.PrevNodeID {
+			break
+		}
+		id = block.PrevNodeID
+	}
+
+	if err := cs.writeBlocks(blocks...); err != nil {
+		return xerrors.Errorf("err in writeBlocks: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package vfsgo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUsage(t *testing.T) {
+	cmdService := newFindTree(t)
+
+	du, err := cmdService.Usage("/", -1)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if du.Bytes != 5 || du.Files != 3 || du.Dirs != 3 {
+		t.Errorf("unexpected usage %+v", du.BlockUsage)
+		return
+	}
+
+	if len(du.Children) != 2 || du.Children[0].Path != "/a" || du.Children[0].Children[0].Path != "/a/b" || du.Children[0].Files != 2 {
+		t.Errorf("unexpected breakdown %+v", du.Children)
+		return
+	}
+
+	if err := cmdService.SetUsageCache(true); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	must(t, cmdService.ChangeFolder("/a/b"))
+	must(t, cmdService.WriteFile("deep.txt", strings.NewReader("0123456789")))
+	must(t, cmdService.CreateFolder("d"))
+	must(t, cmdService.ChangeFolder("/"))
+	must(t, cmdService.Move("a/b", "c"))
+	must(t, cmdService.Remove("a/note.txt"))
+	must(t, cmdService.CreateFile("new", ""))
+
+	cached, err := cmdService.Usage("/", 1)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// user is saved once with usage of every block it changed
+	if got := damageList(t, cmdService); got != "" {
+		t.Errorf("unexpected damages %s", got)
+		return
+	}
+
+	// cached usage is kept after reload
+	reloaded := NewCommandService(cmdService.GetCurrentUser().RootPath)
+	if err := reloaded.Use("testFind"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := reloaded.SetUsageCache(false); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	scanned, err := reloaded.Usage("/", 1)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if cached.BlockUsage != scanned.BlockUsage || cached.BlockUsage != (BlockUsage{Bytes: 10, Stored: 10, Files: 3, Dirs: 4}) {
+		t.Errorf("cached usage %+v not match scan %+v", cached.BlockUsage, scanned.BlockUsage)
+		return
+	}
+
+	for i := range scanned.Children {
+		if cached.Children[i].BlockUsage != scanned.Children[i].BlockUsage {
+			t.Errorf("cached usage of %s not match scan", scanned.Children[i].Path)
+			return
+		}
+	}
+}
+
+func TestSetUsageCacheReload(t *testing.T) {
+	cmdService := newFindTree(t)
+	root := cmdService.GetCurrentUser().RootPath
+
+	for _, enabled := range []bool{true, false} {
+		if err := cmdService.SetUsageCache(enabled); err != nil {
+			t.Error(err.Error())
+			return
+		}
+
+		reloaded := NewCommandService(root)
+		if err := reloaded.Use("testFind"); err != nil {
+			t.Error(err.Error())
+			return
+		}
+
+		if reloaded.GetCurrentUser().UsageCache != enabled {
+			t.Errorf("usage cache %v not kept after reload", enabled)
+			return
+		}
+	}
+}
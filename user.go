@@ -2,6 +2,7 @@ package vfsgo
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"os"
 	"strconv"
@@ -20,6 +21,18 @@ type User struct {
 	CurrentNodeID uint64 `json:"current_node_id"`
 
 	BlockMap map[uint64]BlockINode `json:"block_map"`
+	// UsageCache: blocks keep aggregate usage, see SetUsageCache
+	UsageCache bool `json:"usage_cache,omitempty"`
+	// Compression: default codec of new content, see SetCompression
+	Compression string `json:"compression,omitempty"`
+
+	// RootHash, BlockHashes: merkle hashes of tree updated on every save,
+	// see VerifyTree
+	RootHash    string            `json:"root_hash,omitempty"`
+	BlockHashes map[uint64]string `json:"block_hashes,omitempty"`
+
+	// index: search index, loaded on first use
+	index *SearchIndex
 
 	CreatedTime time.Time `json:"created_time"`
 }
@@ -33,22 +46,31 @@ func (u *User) GetUserINodePath() string {
 }
 
 func (u *User) Save() error {
-	file, err := os.OpenFile(u.GetUserINodePath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	hashes, err := merkleHashes(u)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	buf, err := json.Marshal(u)
-	if err != nil {
-		return err
+	u.RootHash, u.BlockHashes = hashes[0], hashes
+
+	user := *u
+	if namesSealer(u.GetUserPath()) != nil {
+		user.BlockMap = make(map[uint64]BlockINode, len(u.BlockMap))
+		for id := range u.BlockMap {
+			block := u.BlockMap[id]
+			hidden, err := block.hideNames()
+			if err != nil {
+				return err
+			}
+			user.BlockMap[id] = *hidden
+		}
 	}
 
-	if _, err := file.Write(buf); err != nil {
+	buf, err := json.Marshal(&user)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return writeINode(u.GetUserINodePath(), buf)
 }
 
 func AttemptUser(rootPath, name string) error {
@@ -57,7 +79,9 @@ func AttemptUser(rootPath, name string) error {
 		Name:     name,
 	}
 
-	if _, err := os.Stat(user.GetUserPath()); err != nil {
+	if _, err := os.Stat(user.GetUserPath()); os.IsNotExist(err) {
+		return errorf(ErrNotExist, "user %s not exist", name)
+	} else if err != nil {
 		return xerrors.Errorf("error in os.Stat: %w", err)
 	}
 
@@ -77,7 +101,7 @@ func CreateUser(rootPath, name string) (User, error) {
 	}
 
 	if _, err := os.Stat(user.GetUserPath()); err == nil {
-		return User{}, xerrors.New("user already exist")
+		return User{}, NewError(ErrExist, "user already exist")
 	}
 
 	if err := os.Mkdir(user.GetUserPath(), 0755); err != nil {
@@ -103,24 +127,45 @@ func CreateUser(rootPath, name string) (User, error) {
 }
 
 func GetUser(rootPath, name string) (User, error) {
+	// a rekey interrupted before is finished or dropped first
+	if err := recoverRekey(rootPath, name); err != nil {
+		return User{}, xerrors.Errorf("error in recoverRekey: %w", err)
+	}
+
 	user := User{
 		RootPath: rootPath,
 		Name:     name,
 	}
 
-	file, err := os.Open(user.GetUserINodePath())
-	if err != nil {
-		return User{}, xerrors.Errorf("error in os.Open: %w", err)
+	// user encrypted in another process is locked here until unlock
+	if _, ok := keyOf(user.GetUserPath()); !ok {
+		if _, err := os.Stat(user.GetUserPath() + "/" + UserKeyFileName); err == nil {
+			return User{}, NewError(ErrLocked, "user is encrypted, unlock it first")
+		}
 	}
-	defer file.Close()
 
-	buf, err := ioutil.ReadAll(file)
+	buf, err := readINode(user.GetUserINodePath())
+	if os.IsNotExist(err) {
+		return User{}, errorf(ErrNotExist, "user %s not exist", name)
+	}
 	if err != nil {
-		return User{}, xerrors.Errorf("error in ioutil.ReadAll: %w", err)
+		return User{}, xerrors.Errorf("error in readINode: %w", err)
 	}
 
 	if err := json.Unmarshal(buf, &user); err != nil {
-		return User{}, xerrors.Errorf("error in json.Unmarshal: %w", err)
+		return User{}, errorf(ErrCorrupt, "user inode: %s", err.Error())
+	}
+
+	// paths are where the user is found, a restored root may be elsewhere
+	user.RootPath, user.Name = rootPath, name
+
+	for id := range user.BlockMap {
+		block := user.BlockMap[id]
+		block.UserPath = user.GetUserPath()
+		if err := block.showNames(); err != nil {
+			return User{}, xerrors.Errorf("error in showNames: %w", err)
+		}
+		user.BlockMap[id] = block
 	}
 
 	blocks, err := ioutil.ReadDir(user.GetUserPath())
@@ -150,12 +195,27 @@ func DeleteUser(rootPath, name string) error {
 		Name:     name,
 	}
 
-	if _, err := os.Stat(user.GetUserPath()); err != nil {
-		return err
+	if _, err := os.Stat(user.GetUserPath()); os.IsNotExist(err) {
+		return errorf(ErrNotExist, "user %s not exist", name)
+	} else if err != nil {
+		return xerrors.Errorf("error in os.Stat: %w", err)
+	}
+
+	// files of user in shared store are released, a user encrypted in
+	// full mode keeps its own store removed with it
+	key, err := loadUserKey(user.GetUserPath())
+	if err != nil && !errors.Is(err, ErrNotExist) {
+		return xerrors.Errorf("error in loadUserKey: %w", err)
+	}
+
+	if err != nil || key.Mode != EncryptFull {
+		if err := releaseUserBlobs(NewBlobStore(rootPath), user.GetUserPath()); err != nil {
+			return xerrors.Errorf("error in releaseUserBlobs: %w", err)
+		}
 	}
 
 	if err := os.RemoveAll(user.GetUserPath()); err != nil {
-		return err
+		return xerrors.Errorf("error in os.RemoveAll: %w", err)
 	}
 
 	return nil
@@ -2,6 +2,7 @@ package vfsgo
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -170,3 +171,38 @@ func TestDeleteUser(t *testing.T) {
 		return
 	}
 }
+
+func TestUserErrors(t *testing.T) {
+	root := t.TempDir()
+
+	if err := AttemptUser(root, "nobody"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("unexpected error %v of attempt", err)
+		return
+	}
+
+	if _, err := GetUser(root, "nobody"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("unexpected error %v of get", err)
+		return
+	}
+
+	if err := DeleteUser(root, "nobody"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("unexpected error %v of delete", err)
+		return
+	}
+
+	user, err := CreateUser(root, "broken")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if err := ioutil.WriteFile(user.GetUserINodePath(), []byte("{"), 0644); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if _, err := GetUser(root, "broken"); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("unexpected error %v of broken user", err)
+		return
+	}
+}